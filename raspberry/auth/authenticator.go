@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/xTaube/vr-controlled-robot-arm/logging"
+)
+
+var logger = logging.Logger("auth")
+
+var ErrMissingToken = errors.New("missing access token")
+var ErrInvalidToken = errors.New("invalid access token")
+
+// Authenticator checks tokens from the configuration file and the token store.
+// The store is read again whenever the file changes, so tokens added or
+// revoked with the CLI apply to the next connection without a restart.
+type Authenticator struct {
+	tokens    map[string]Principal
+	storePath string
+
+	mu           sync.Mutex
+	storeModTime time.Time
+	storeTokens  []StoredToken
+}
+
+func (a *Authenticator) reloadStore() {
+	if a.storePath == "" {
+		return
+	}
+	info, err := os.Stat(a.storePath)
+	if err != nil {
+		a.storeTokens = nil
+		return
+	}
+	if info.ModTime().Equal(a.storeModTime) {
+		return
+	}
+
+	store, err := LoadTokenStore(a.storePath)
+	if err != nil {
+		logger.Warn("Cannot reload token store, keeping previous tokens", "path", a.storePath, "error", err)
+		return
+	}
+	a.storeModTime = info.ModTime()
+	a.storeTokens = store.Tokens
+}
+
+func (a *Authenticator) Authenticate(token string) (*Principal, error) {
+	if token == "" {
+		return nil, ErrMissingToken
+	}
+
+	var found *Principal
+	for known, principal := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(known), []byte(token)) == 1 {
+			found = &principal
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.reloadStore()
+	hash := hashToken(token)
+	for _, stored := range a.storeTokens {
+		if subtle.ConstantTimeCompare([]byte(stored.SHA256), []byte(hash)) == 1 {
+			role, _ := ParseRole(stored.Role)
+			found = &Principal{Name: stored.Name, Role: role}
+		}
+	}
+
+	if found == nil {
+		return nil, ErrInvalidToken
+	}
+	return found, nil
+}
+
+func InitAuthenticator(tokens map[string]Principal, storePath string) *Authenticator {
+	return &Authenticator{tokens: tokens, storePath: storePath}
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAuthenticate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.yaml")
+	store, _ := LoadTokenStore(path)
+	stored, _ := store.Add("vr-headset", ROLE_OPERATOR)
+	if err := store.Save(); err != nil {
+		t.Fatal(err)
+	}
+	authenticator := InitAuthenticator(map[string]Principal{"configured": {Name: "ci", Role: ROLE_ADMIN}}, path)
+
+	for token, want := range map[string]Principal{
+		"configured": {Name: "ci", Role: ROLE_ADMIN},
+		stored:       {Name: "vr-headset", Role: ROLE_OPERATOR},
+	} {
+		principal, err := authenticator.Authenticate(token)
+		if err != nil || *principal != want {
+			t.Errorf("got %v %v, want %v", principal, err, want)
+		}
+	}
+	if _, err := authenticator.Authenticate(""); !errors.Is(err, ErrMissingToken) {
+		t.Errorf("empty token: got %v", err)
+	}
+	if _, err := authenticator.Authenticate("guess"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("unknown token: got %v", err)
+	}
+
+	// A token revoked with the CLI is refused on the next connection.
+	store.Revoke("vr-headset")
+	if err := store.Save(); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := authenticator.Authenticate(stored); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("revoked token: got %v", err)
+	}
+}
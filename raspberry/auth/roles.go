@@ -0,0 +1,56 @@
+package auth
+
+import "fmt"
+
+// Roles are ordered, every role may do what the ones before it can.
+type Role byte
+
+const (
+	ROLE_OBSERVER Role = iota + 1
+	ROLE_OPERATOR
+	ROLE_ADMIN
+)
+
+var ROLE_NAMES = map[Role]string{
+	ROLE_OBSERVER: "observer",
+	ROLE_OPERATOR: "operator",
+	ROLE_ADMIN:    "admin",
+}
+
+type UnknownRoleError struct {
+	name string
+}
+
+func (err *UnknownRoleError) Error() string {
+	return fmt.Sprintf("Unknown role %q, expected observer, operator or admin.", err.name)
+}
+
+func (r Role) String() string {
+	name, ok := ROLE_NAMES[r]
+	if !ok {
+		return fmt.Sprintf("ROLE_%d", r)
+	}
+	return name
+}
+
+func (r Role) Includes(required Role) bool {
+	return r >= required
+}
+
+func ParseRole(name string) (Role, error) {
+	for role, roleName := range ROLE_NAMES {
+		if roleName == name {
+			return role, nil
+		}
+	}
+	return 0, &UnknownRoleError{name}
+}
+
+type Principal struct {
+	Name string
+	Role Role
+}
+
+func (p Principal) String() string {
+	return fmt.Sprintf("%s (%s)", p.Name, p.Role)
+}
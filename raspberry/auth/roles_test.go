@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseRole(t *testing.T) {
+	for role, name := range ROLE_NAMES {
+		parsed, err := ParseRole(name)
+		if err != nil || parsed != role {
+			t.Errorf("%q: got %v %v, want %v", name, parsed, err, role)
+		}
+	}
+	var unknown *UnknownRoleError
+	if _, err := ParseRole("root"); !errors.As(err, &unknown) {
+		t.Errorf("got %v, want an UnknownRoleError", err)
+	}
+}
+
+func TestRolesIncludeTheOnesBefore(t *testing.T) {
+	roles := []Role{ROLE_OBSERVER, ROLE_OPERATOR, ROLE_ADMIN}
+	for i, role := range roles {
+		for j, required := range roles {
+			if role.Includes(required) != (i >= j) {
+				t.Errorf("%s includes %s: got %v", role, required, role.Includes(required))
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/xTaube/vr-controlled-robot-arm/storage"
+	"gopkg.in/yaml.v3"
+)
+
+const TOKEN_SIZE = 32
+
+type TokenNameTakenError struct {
+	name string
+}
+
+func (err *TokenNameTakenError) Error() string {
+	return fmt.Sprintf("A token named %q already exists.", err.name)
+}
+
+type TokenNotFoundError struct {
+	name string
+}
+
+func (err *TokenNotFoundError) Error() string {
+	return fmt.Sprintf("No token named %q.", err.name)
+}
+
+// Only a hash of every token is kept, the token itself is shown once when it is created.
+type StoredToken struct {
+	Name    string    `yaml:"name"`
+	Role    string    `yaml:"role"`
+	SHA256  string    `yaml:"sha256"`
+	Created time.Time `yaml:"created"`
+}
+
+type TokenStore struct {
+	path   string
+	Tokens []StoredToken `yaml:"tokens"`
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func GenerateToken() (string, error) {
+	data := make([]byte, TOKEN_SIZE)
+	_, err := rand.Read(data)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func (s *TokenStore) Add(name string, role Role) (string, error) {
+	for _, stored := range s.Tokens {
+		if stored.Name == name {
+			return "", &TokenNameTakenError{name}
+		}
+	}
+
+	token, err := GenerateToken()
+	if err != nil {
+		return "", err
+	}
+	s.Tokens = append(s.Tokens, StoredToken{Name: name, Role: role.String(), SHA256: hashToken(token), Created: time.Now().UTC()})
+	return token, nil
+}
+
+func (s *TokenStore) Revoke(name string) error {
+	for i, stored := range s.Tokens {
+		if stored.Name == name {
+			s.Tokens = append(s.Tokens[:i], s.Tokens[i+1:]...)
+			return nil
+		}
+	}
+	return &TokenNotFoundError{name}
+}
+
+func (s *TokenStore) Save() error {
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return err
+	}
+	// The hashes are kept from other users of the machine.
+	return storage.WriteFile(s.path, data, 0600)
+}
+
+// A missing file is an empty store, it is created on the first Save.
+func LoadTokenStore(path string) (*TokenStore, error) {
+	store := &TokenStore{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read token store: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err = decoder.Decode(store)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("Cannot parse token store %s: %w", path, err)
+	}
+	for _, stored := range store.Tokens {
+		_, err := ParseRole(stored.Role)
+		if err != nil {
+			return nil, fmt.Errorf("Token %q in %s: %w", stored.Name, path, err)
+		}
+	}
+	return store, nil
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTokenStoreKeepsOnlyHashes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.yaml")
+	store, err := LoadTokenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := store.Add("vr-headset", ROLE_OPERATOR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Tokens[0].SHA256 != hashToken(token) || hashToken(token) == hashToken(token+"x") {
+		t.Errorf("stored %q for token %q", store.Tokens[0].SHA256, token)
+	}
+	var taken *TokenNameTakenError
+	if _, err := store.Add("vr-headset", ROLE_ADMIN); !errors.As(err, &taken) {
+		t.Errorf("got %v, want a TokenNameTakenError", err)
+	}
+
+	if err := store.Save(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), token) {
+		t.Error("the token itself was saved")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("token store mode %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestTokenStoreReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.yaml")
+	store, _ := LoadTokenStore(path)
+	store.Add("vr-headset", ROLE_OPERATOR)
+	store.Add("dashboard", ROLE_OBSERVER)
+	if err := store.Revoke("vr-headset"); err != nil {
+		t.Fatal(err)
+	}
+	var notFound *TokenNotFoundError
+	if err := store.Revoke("vr-headset"); !errors.As(err, &notFound) {
+		t.Errorf("got %v, want a TokenNotFoundError", err)
+	}
+	if err := store.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := LoadTokenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.Tokens) != 1 || reloaded.Tokens[0] != store.Tokens[0] {
+		t.Errorf("got %+v, want %+v", reloaded.Tokens, store.Tokens)
+	}
+}
+
+func TestLoadTokenStoreRefusesUnknownRoles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.yaml")
+	if err := os.WriteFile(path, []byte("tokens:\n  - name: old\n    role: root\n    sha256: abc\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var unknown *UnknownRoleError
+	if _, err := LoadTokenStore(path); !errors.As(err, &unknown) {
+		t.Errorf("got %v, want an UnknownRoleError", err)
+	}
+}
@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+)
+
+func camerasCommand() *Command {
+	return &Command{
+		Name:    "cameras",
+		Usage:   "cameras list [-config path]",
+		Summary: "list configured cameras and detected video devices",
+		Run: func(args []string) error {
+			if len(args) == 0 || args[0] != "list" {
+				return &UsageError{"Expected subcommand: list."}
+			}
+
+			flags, common := newFlagSet("cameras list", false)
+			err := parseFlags(flags, args[1:])
+			if err != nil {
+				return err
+			}
+
+			cfg, err := common.loadConfig()
+			if err != nil {
+				return err
+			}
+
+			detected, err := filepath.Glob("/dev/video*")
+			if err != nil {
+				return err
+			}
+
+			fmt.Println("Configured cameras:")
+			table := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(table, "  NAME\tDEVICE\tPRESENT\tRESOLUTION\tFPS\tFORMAT\tSTREAM")
+			missing := 0
+			for _, camera := range cfg.Cameras {
+				_, statErr := os.Stat(camera.Device)
+				if statErr != nil {
+					missing++
+				}
+				fmt.Fprintf(
+					table, "  %s\t%s\t%t\t%dx%d\t%d\t%s\t%s\n",
+					camera.Name, camera.Device, statErr == nil, camera.Width, camera.Height,
+					camera.Framerate, camera.InputFormat, camera.StreamAddress,
+				)
+			}
+			table.Flush()
+
+			fmt.Println("\nDetected video devices:")
+			if len(detected) == 0 {
+				fmt.Println("  none")
+			}
+			for _, device := range detected {
+				usage := ""
+				for _, camera := range cfg.Cameras {
+					if camera.Device == device {
+						usage = fmt.Sprintf(" (used by %s)", camera.Name)
+					}
+				}
+				fmt.Printf("  %s%s\n", device, usage)
+			}
+
+			if missing > 0 {
+				return fmt.Errorf("%d configured camera(s) not present", missing)
+			}
+			return nil
+		},
+	}
+}
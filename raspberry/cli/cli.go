@@ -0,0 +1,185 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/xTaube/vr-controlled-robot-arm/config"
+	"github.com/xTaube/vr-controlled-robot-arm/logging"
+	"github.com/xTaube/vr-controlled-robot-arm/robot"
+	"github.com/xTaube/vr-controlled-robot-arm/video"
+)
+
+type UsageError struct {
+	message string
+}
+
+func (err *UsageError) Error() string {
+	return err.message
+}
+
+type Command struct {
+	Name    string
+	Usage   string
+	Summary string
+	Run     func(args []string) error
+}
+
+func commands() []*Command {
+	return []*Command{
+		serveCommand(),
+		simulateCommand(),
+		calibrateCommand(),
+		jogCommand(),
+		positionCommand(),
+		gripperCommand(),
+		camerasCommand(),
+		configCommand(),
+		tokenCommand(),
+		tlsCommand(),
+		replayCommand(),
+	}
+}
+
+func programName() string {
+	return filepath.Base(os.Args[0])
+}
+
+func printUsage(output io.Writer) {
+	fmt.Fprintf(output, "Usage: %s <command> [flags] [arguments]\n", programName())
+	fmt.Fprintln(output, "")
+	fmt.Fprintln(output, "Commands:")
+	table := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+	for _, command := range commands() {
+		fmt.Fprintf(table, "  %s\t%s\n", command.Usage, command.Summary)
+	}
+	table.Flush()
+	fmt.Fprintln(output, "")
+	fmt.Fprintln(output, "Running without a command is the same as running 'serve'.")
+	fmt.Fprintf(output, "Use '%s <command> -h' to see the flags of a command.\n", programName())
+}
+
+func Run(args []string) int {
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	if name == "help" {
+		printUsage(os.Stdout)
+		return 0
+	}
+
+	for _, command := range commands() {
+		if command.Name != name {
+			continue
+		}
+
+		err := command.Run(args)
+		var usageErr *UsageError
+		switch {
+		case err == nil:
+			return 0
+		case errors.Is(err, flag.ErrHelp):
+			return 0
+		case errors.As(err, &usageErr):
+			if usageErr.message != "" {
+				fmt.Fprintf(os.Stderr, "%s\nUsage: %s %s\n", usageErr, programName(), command.Usage)
+			}
+			return 2
+		default:
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			return 1
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Unknown command %q.\n\n", name)
+	printUsage(os.Stderr)
+	return 2
+}
+
+type commonFlags struct {
+	configPath *string
+	simulate   *bool
+}
+
+func newFlagSet(command string, withSimulate bool) (*flag.FlagSet, *commonFlags) {
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	common := &commonFlags{
+		configPath: flags.String("config", os.Getenv("CONFIG_FILE"), "path to the YAML configuration file"),
+	}
+	if withSimulate {
+		common.simulate = flags.Bool("simulate", false, "use the simulated robot instead of the UART connection")
+	}
+	return flags, common
+}
+
+func parseFlags(flags *flag.FlagSet, args []string) error {
+	err := flags.Parse(args)
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		return &UsageError{}
+	}
+	return err
+}
+
+func (f *commonFlags) loadConfig() (*config.Config, error) {
+	return config.Load(*f.configPath)
+}
+
+func (f *commonFlags) openRobot(cfg *config.Config) (*robot.Robot, error) {
+	if f.simulate != nil && *f.simulate {
+		return robot.InitSimulatedRobot(cfg.Limits.RobotLimits()), nil
+	}
+	return robot.InitRobot(cfg.Serial.UartConfig(), cfg.Limits.RobotLimits())
+}
+
+var logger = logging.Logger("cli")
+
+// Levels and format were checked when the configuration was loaded.
+func setupLogging(cfg *config.LoggingConfig) (*os.File, error) {
+	options := logging.Options{Format: cfg.Format, Microseconds: cfg.Microseconds, Packages: map[string]slog.Level{}}
+	options.Level, _ = logging.ParseLevel(cfg.Level)
+	for pkg, name := range cfg.Packages {
+		options.Packages[pkg], _ = logging.ParseLevel(name)
+	}
+
+	var logFile *os.File
+	if cfg.File != "" {
+		var err error
+		logFile, err = os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		options.Output = logFile
+	}
+	logging.Configure(options)
+	return logFile, nil
+}
+
+func initVideoStreams(cfg *config.Config) []*video.VideoStream {
+	videos := make([]*video.VideoStream, 0, len(cfg.Cameras))
+	for _, camera := range cfg.Cameras {
+		videos = append(videos, video.InitVideoStream(
+			camera.Device,
+			video.Resoulution{Width: camera.Width, Height: camera.Height},
+			video.Framerate(camera.Framerate),
+			video.InputFormat(camera.InputFormat),
+			camera.StreamAddress,
+			cfg.Stream.PublicHost,
+			cfg.Stream.LogDir,
+		))
+		logger.Info("Camera initialized", "camera", camera.Name, "device", camera.Device)
+	}
+	return videos
+}
+
+func printJointsAngles(output io.Writer, joints *robot.JointsAngles) {
+	fmt.Fprintf(output, "Z: %8.3f\nY: %8.3f\nX: %8.3f\nV: %8.3f\nW: %8.3f\n", joints.Z, joints.Y, joints.X, joints.V, joints.W)
+}
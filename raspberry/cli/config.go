@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+func configCommand() *Command {
+	return &Command{
+		Name:    "config",
+		Usage:   "config check [-config path] [-print]",
+		Summary: "validate the configuration and optionally print the effective values",
+		Run: func(args []string) error {
+			if len(args) == 0 || args[0] != "check" {
+				return &UsageError{"Expected subcommand: check."}
+			}
+
+			flags, common := newFlagSet("config check", false)
+			print := flags.Bool("print", false, "print the effective configuration after defaults and environment overrides")
+			err := parseFlags(flags, args[1:])
+			if err != nil {
+				return err
+			}
+
+			cfg, err := common.loadConfig()
+			if err != nil {
+				return err
+			}
+
+			if *print {
+				encoder := yaml.NewEncoder(os.Stdout)
+				encoder.SetIndent(2)
+				err = encoder.Encode(cfg)
+				if err != nil {
+					return err
+				}
+				encoder.Close()
+			}
+
+			source := *common.configPath
+			if source == "" {
+				source = "Default configuration"
+			}
+			fmt.Fprintf(os.Stderr, "%s is valid.\n", source)
+			return nil
+		},
+	}
+}
@@ -0,0 +1,126 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/xTaube/vr-controlled-robot-arm/client"
+	"github.com/xTaube/vr-controlled-robot-arm/codec"
+	"github.com/xTaube/vr-controlled-robot-arm/config"
+	"github.com/xTaube/vr-controlled-robot-arm/gcode"
+	"github.com/xTaube/vr-controlled-robot-arm/pose"
+	"github.com/xTaube/vr-controlled-robot-arm/program"
+	"github.com/xTaube/vr-controlled-robot-arm/robot"
+	"github.com/xTaube/vr-controlled-robot-arm/script"
+	"github.com/xTaube/vr-controlled-robot-arm/server"
+)
+
+type replayTransport struct {
+	connection *websocket.Conn
+}
+
+func (t *replayTransport) ReadMessage() ([]byte, error) {
+	_, data, err := t.connection.ReadMessage()
+	return data, err
+}
+
+func (t *replayTransport) WriteMessage(data []byte) error {
+	return t.connection.WriteMessage(websocket.TextMessage, data)
+}
+
+func (t *replayTransport) Close() error {
+	return t.connection.Close()
+}
+
+// Without an address the recording is replayed against a simulated arm in this process.
+func openReplayTransport(ctx context.Context, address string, options client.DialOptions, cfg *config.Config) (server.MessageTransport, error) {
+	if address != "" {
+		options.Subprotocol = codec.JSON_SUBPROTOCOL
+		connection, err := client.DialConnection(ctx, address, options)
+		if err != nil {
+			return nil, err
+		}
+		return &replayTransport{connection}, nil
+	}
+
+	poses, err := pose.LoadLibrary(cfg.Poses.File)
+	if err != nil {
+		return nil, err
+	}
+	controlServer := server.InitControlServer(
+		robot.InitSimulatedRobot(cfg.Limits.RobotLimits()),
+		nil,
+		cfg.Server.LeaseTimeout,
+		nil,
+		"",
+		program.InitStore(cfg.Programs.Dir),
+		gcode.InitStore(cfg.Programs.Dir),
+		cfg.Kinematics.Geometry(),
+		script.InitStore(cfg.Scripts.Dir),
+		cfg.Scripts.Limits(),
+		poses,
+		server.DefaultMiddleware(),
+	)
+	serverEnd, clientEnd := server.InitMemoryTransportPair()
+	identity := server.NewSessionIdentity("memory", "replay", codec.JSON_SUBPROTOCOL, server.ANONYMOUS_PRINCIPAL)
+	go controlServer.Serve(server.InitTransportSession(ctx, serverEnd, &server.JSONCodec{}, identity))
+	return clientEnd, nil
+}
+
+func replayCommand() *Command {
+	return &Command{
+		Name:    "replay",
+		Usage:   "replay [-address url] [-token token] [-fingerprint sha256] [-speed factor] [-strict] recording",
+		Summary: "replay a recorded session against a server or the simulator and report diverging responses",
+		Run: func(args []string) error {
+			flags, common := newFlagSet("replay", false)
+			address := flags.String("address", "", "websocket URL of the server, the simulator is used when empty")
+			token := flags.String("token", "", "access token for servers with authentication")
+			fingerprint := flags.String("fingerprint", "", "SHA-256 fingerprint to pin a self-signed server certificate")
+			speed := flags.Float64("speed", 1, "replay speed relative to the recording")
+			strict := flags.Bool("strict", false, "compare whole responses instead of status and code")
+			err := parseFlags(flags, args)
+			if err != nil {
+				return err
+			}
+			if flags.NArg() != 1 {
+				return &UsageError{"Expected one recording file."}
+			}
+			if *speed <= 0 {
+				return &UsageError{"-speed must be positive."}
+			}
+
+			cfg, err := common.loadConfig()
+			if err != nil {
+				return err
+			}
+			recording, err := server.LoadRecording(flags.Arg(0))
+			if err != nil {
+				return err
+			}
+
+			ctx := context.Background()
+			transport, err := openReplayTransport(ctx, *address, client.DialOptions{Token: *token, Fingerprint: *fingerprint}, cfg)
+			if err != nil {
+				return err
+			}
+			defer transport.Close()
+
+			fmt.Printf("Replaying %d requests of %s (%s) at %gx.\n", len(recording.Requests), recording.Session.Session, recording.Session.Started, *speed)
+			report, err := server.Replay(ctx, transport, recording, server.ReplayOptions{Speed: *speed, Strict: *strict})
+			if err != nil {
+				return err
+			}
+			for _, divergence := range report.Divergences {
+				fmt.Println(divergence.String())
+			}
+			fmt.Printf("%d requests, %d responses, %d divergences in %s.\n", report.Requests, report.Responses, len(report.Divergences), report.Duration.Round(time.Millisecond))
+			if len(report.Divergences) > 0 {
+				return fmt.Errorf("Replay diverged from the recording.")
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,269 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/xTaube/vr-controlled-robot-arm/robot"
+	"github.com/xTaube/vr-controlled-robot-arm/server"
+)
+
+const CALIBRATION_HELP = `Terminal calibration commands:
+  move <z> <y> <x> <v> <w>   move joints to the given angles (degrees)
+  confirm                    set the current position as the reference and finish
+  abort                      abort calibration`
+
+type terminalSession struct {
+	input    *bufio.Scanner
+	output   io.Writer
+	identity server.SessionIdentity
+}
+
+func (t *terminalSession) Receive() (*server.Request, error) {
+	for {
+		fmt.Fprint(t.output, "> ")
+		if !t.input.Scan() {
+			if t.input.Err() != nil {
+				return nil, t.input.Err()
+			}
+			return nil, io.EOF
+		}
+
+		fields := strings.Fields(t.input.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "confirm":
+			return &server.Request{Command: server.CALIBRATION_CONFIRM}, nil
+		case "abort":
+			return &server.Request{Command: server.CALIBRATION_ABORT}, nil
+		case "move":
+			if len(fields) != 6 {
+				fmt.Fprintln(t.output, "move needs exactly 5 angles: move <z> <y> <x> <v> <w>")
+				continue
+			}
+			return &server.Request{Command: server.CALIBRATION_MOVE, Args: fields[1:]}, nil
+		case "help":
+			fmt.Fprintln(t.output, CALIBRATION_HELP)
+		default:
+			fmt.Fprintf(t.output, "Unknown command %q, type 'help' to list commands.\n", fields[0])
+		}
+	}
+}
+
+func (t *terminalSession) Send(request *server.Request, response server.Response) error {
+	// The prompt names protocol commands, the terminal has its own.
+	if prompt, ok := response.(*server.PromptResponse); ok {
+		_, err := fmt.Fprintf(t.output, "%s\n%s\n", prompt.Message, CALIBRATION_HELP)
+		return err
+	}
+	fields := strings.Split(string(response.Parse()), "$")
+	if fields[0] != "0" {
+		_, err := fmt.Fprintf(t.output, "Error %s: %s\n", fields[0], strings.Join(fields[1:], " "))
+		return err
+	}
+	_, err := fmt.Fprintln(t.output, strings.Join(fields[1:], " "))
+	return err
+}
+
+func (t *terminalSession) Context() context.Context {
+	return context.Background()
+}
+
+func (t *terminalSession) Identity() server.SessionIdentity {
+	return t.identity
+}
+
+func (t *terminalSession) Close() error {
+	return nil
+}
+
+func openRobotFromFlags(common *commonFlags) (*robot.Robot, error) {
+	cfg, err := common.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	return common.openRobot(cfg)
+}
+
+func calibrateCommand() *Command {
+	return &Command{
+		Name:    "calibrate",
+		Usage:   "calibrate [-config path] [-simulate]",
+		Summary: "run the calibration workflow from the terminal",
+		Run: func(args []string) error {
+			flags, common := newFlagSet("calibrate", true)
+			err := parseFlags(flags, args)
+			if err != nil {
+				return err
+			}
+
+			robot, err := openRobotFromFlags(common)
+			if err != nil {
+				return err
+			}
+			defer robot.ShutDown()
+
+			fmt.Println(CALIBRATION_HELP)
+			terminal := &terminalSession{
+				input:    bufio.NewScanner(os.Stdin),
+				output:   os.Stdout,
+				identity: server.NewSessionIdentity("terminal", "stdin", "", server.ANONYMOUS_PRINCIPAL),
+			}
+			err = server.InitRobotCalibrationWorkflow(terminal, robot, nil).Start(&server.Request{Command: server.CALIBRATE_ROBOT})
+			if err != nil {
+				return err
+			}
+
+			fmt.Println("Calibration finished.")
+			return nil
+		},
+	}
+}
+
+func waitUntilIdle(robot *robot.Robot, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for !robot.IsIdle() {
+		if time.Now().After(deadline) {
+			return errors.New("robot did not stop moving in time")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil
+}
+
+func jogCommand() *Command {
+	return &Command{
+		Name:    "jog",
+		Usage:   "jog [-config path] [-simulate] [-x|-y|-z|-v|-w deg]...",
+		Summary: "move joints relative to the current position",
+		Run: func(args []string) error {
+			flags, common := newFlagSet("jog", true)
+			x := flags.Float64("x", 0, "X joint offset in degrees")
+			y := flags.Float64("y", 0, "Y joint offset in degrees")
+			z := flags.Float64("z", 0, "Z joint offset in degrees")
+			v := flags.Float64("v", 0, "V joint offset in degrees")
+			w := flags.Float64("w", 0, "W joint offset in degrees")
+			speed := flags.Float64("speed", 0, "set this speed before moving")
+			wait := flags.Duration("wait", 30*time.Second, "how long to wait for the move to finish, 0 to return immediately")
+			err := parseFlags(flags, args)
+			if err != nil {
+				return err
+			}
+			if flags.NArg() > 0 {
+				return &UsageError{fmt.Sprintf("Unexpected arguments: %s", strings.Join(flags.Args(), " "))}
+			}
+
+			robot, err := openRobotFromFlags(common)
+			if err != nil {
+				return err
+			}
+			defer robot.ShutDown()
+
+			if *speed != 0 {
+				err = robot.SetSpeed(float32(*speed))
+				if err != nil {
+					return err
+				}
+			}
+
+			position, err := robot.GetCurrentPosition()
+			if err != nil {
+				return err
+			}
+			position.X += float32(*x)
+			position.Y += float32(*y)
+			position.Z += float32(*z)
+			position.V += float32(*v)
+			position.W += float32(*w)
+
+			reached, err := robot.Move(*position)
+			if err != nil {
+				return err
+			}
+
+			if *wait > 0 {
+				err = waitUntilIdle(robot, *wait)
+				if err != nil {
+					return err
+				}
+			}
+
+			fmt.Println("Target position:")
+			printJointsAngles(os.Stdout, reached)
+			return nil
+		},
+	}
+}
+
+func positionCommand() *Command {
+	return &Command{
+		Name:    "position",
+		Usage:   "position [-config path] [-simulate]",
+		Summary: "print the current joint angles",
+		Run: func(args []string) error {
+			flags, common := newFlagSet("position", true)
+			err := parseFlags(flags, args)
+			if err != nil {
+				return err
+			}
+
+			robot, err := openRobotFromFlags(common)
+			if err != nil {
+				return err
+			}
+			defer robot.ShutDown()
+
+			position, err := robot.GetCurrentPosition()
+			if err != nil {
+				return err
+			}
+			printJointsAngles(os.Stdout, position)
+			return nil
+		},
+	}
+}
+
+func gripperCommand() *Command {
+	return &Command{
+		Name:    "gripper",
+		Usage:   "gripper [-config path] [-simulate] open|close",
+		Summary: "open or close the gripper",
+		Run: func(args []string) error {
+			flags, common := newFlagSet("gripper", true)
+			err := parseFlags(flags, args)
+			if err != nil {
+				return err
+			}
+			if flags.NArg() != 1 || (flags.Arg(0) != "open" && flags.Arg(0) != "close") {
+				return &UsageError{"Expected exactly one argument: open or close."}
+			}
+
+			robot, err := openRobotFromFlags(common)
+			if err != nil {
+				return err
+			}
+			defer robot.ShutDown()
+
+			if flags.Arg(0) == "open" {
+				err = robot.OpenGripper()
+			} else {
+				err = robot.CloseGripper()
+			}
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Gripper %s.\n", map[string]string{"open": "opened", "close": "closed"}[flags.Arg(0)])
+			return nil
+		},
+	}
+}
@@ -0,0 +1,184 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/xTaube/vr-controlled-robot-arm/auth"
+	"github.com/xTaube/vr-controlled-robot-arm/config"
+	"github.com/xTaube/vr-controlled-robot-arm/gcode"
+	"github.com/xTaube/vr-controlled-robot-arm/logging"
+	"github.com/xTaube/vr-controlled-robot-arm/pose"
+	"github.com/xTaube/vr-controlled-robot-arm/program"
+	"github.com/xTaube/vr-controlled-robot-arm/robot"
+	"github.com/xTaube/vr-controlled-robot-arm/script"
+	"github.com/xTaube/vr-controlled-robot-arm/server"
+	"github.com/xTaube/vr-controlled-robot-arm/video"
+)
+
+// shutDown gives every step what is left of the timeout, ffmpeg and the serial port are released even when it ran out.
+func shutDown(cfg *config.ShutdownConfig, controlServer *server.ControlServer, robot *robot.Robot, videos []*video.VideoStream) {
+	started := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancel()
+
+	err := controlServer.Shutdown(ctx)
+	if err != nil {
+		logger.Warn("Sessions did not finish in time", "error", err)
+	}
+
+	if cfg.Park {
+		err := robot.MoveAndWait(ctx, cfg.ParkPose)
+		if err != nil {
+			logger.Warn("Cannot park the arm", "error", err)
+		} else {
+			logger.Info("Arm parked")
+		}
+	}
+
+	for _, videoStream := range videos {
+		err := videoStream.Stop()
+		var streamOff *video.StreamOffError
+		if err != nil && !errors.As(err, &streamOff) {
+			logger.Warn("Cannot stop video stream", "device", videoStream.Device(), "error", err)
+		}
+	}
+	robot.ShutDown()
+	logger.Info("Shut down", logging.Elapsed(started))
+}
+
+func runServer(cfg *config.Config, robot *robot.Robot, videos []*video.VideoStream) error {
+	signals, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	ctx, cancel := context.WithCancel(signals)
+	defer cancel()
+
+	var authenticator *auth.Authenticator
+	if cfg.Auth.Enabled {
+		authenticator = auth.InitAuthenticator(cfg.Auth.Principals(), cfg.Auth.TokenStore)
+	}
+
+	poses, err := pose.LoadLibrary(cfg.Poses.File)
+	if err != nil {
+		robot.ShutDown()
+		return err
+	}
+
+	controlServer := server.InitControlServer(
+		robot,
+		videos,
+		cfg.Server.LeaseTimeout,
+		authenticator,
+		cfg.Recording.Directory(),
+		program.InitStore(cfg.Programs.Dir),
+		gcode.InitStore(cfg.Programs.Dir),
+		cfg.Kinematics.Geometry(),
+		script.InitStore(cfg.Scripts.Dir),
+		cfg.Scripts.Limits(),
+		poses,
+		server.DefaultMiddleware(),
+	)
+	errs := make(chan error, 2)
+	if cfg.Server.WebSocket {
+		certificate, err := loadCertificate(cfg)
+		if err != nil {
+			robot.ShutDown()
+			return err
+		}
+		go func() {
+			errs <- server.RunWebSocketServer(ctx, cfg.Server.Port, certificate, cfg.Server.TLS.PlainConnections, controlServer)
+		}()
+	}
+	if cfg.Server.WebTransport.Enabled {
+		webTransport := cfg.Server.WebTransport
+		go func() {
+			errs <- server.RunWebTransportServer(ctx, webTransport.Port, webTransport.CertFile, webTransport.KeyFile, controlServer)
+		}()
+	}
+
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+		logger.Info("Shutting down", "timeout", cfg.Shutdown.Timeout)
+	}
+	// A second signal terminates the process right away.
+	stopSignals()
+	cancel()
+	shutDown(&cfg.Shutdown, controlServer, robot, videos)
+	return err
+}
+
+func serveCommand() *Command {
+	return &Command{
+		Name:    "serve",
+		Usage:   "serve [-config path]",
+		Summary: "run the control server with the real arm and cameras",
+		Run: func(args []string) error {
+			flags, common := newFlagSet("serve", false)
+			err := parseFlags(flags, args)
+			if err != nil {
+				return err
+			}
+
+			cfg, err := common.loadConfig()
+			if err != nil {
+				return err
+			}
+			logFile, err := setupLogging(&cfg.Logging)
+			if err != nil {
+				return err
+			}
+			if logFile != nil {
+				defer logFile.Close()
+			}
+
+			robot, err := common.openRobot(cfg)
+			if err != nil {
+				return err
+			}
+			logger.Info("Robot arm initialized")
+
+			return runServer(cfg, robot, initVideoStreams(cfg))
+		},
+	}
+}
+
+func simulateCommand() *Command {
+	return &Command{
+		Name:    "simulate",
+		Usage:   "simulate [-config path] [-cameras]",
+		Summary: "run the control server against a simulated arm",
+		Run: func(args []string) error {
+			flags, common := newFlagSet("simulate", false)
+			withCameras := flags.Bool("cameras", false, "stream from the configured cameras as well")
+			err := parseFlags(flags, args)
+			if err != nil {
+				return err
+			}
+
+			cfg, err := common.loadConfig()
+			if err != nil {
+				return err
+			}
+			logFile, err := setupLogging(&cfg.Logging)
+			if err != nil {
+				return err
+			}
+			if logFile != nil {
+				defer logFile.Close()
+			}
+
+			robot := robot.InitSimulatedRobot(cfg.Limits.RobotLimits())
+
+			videos := []*video.VideoStream{}
+			if *withCameras {
+				videos = initVideoStreams(cfg)
+			}
+			return runServer(cfg, robot, videos)
+		},
+	}
+}
@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"github.com/xTaube/vr-controlled-robot-arm/config"
+	"github.com/xTaube/vr-controlled-robot-arm/server"
+)
+
+func loadCertificate(cfg *config.Config) (*tls.Certificate, error) {
+	if !cfg.Server.TLS.Enabled {
+		return nil, nil
+	}
+	tlsConfig := cfg.Server.TLS
+	hosts := append(append([]string{}, tlsConfig.Hosts...), cfg.Stream.PublicHost)
+	return server.LoadOrCreateCertificate(tlsConfig.CertFile, tlsConfig.KeyFile, tlsConfig.SelfSigned, hosts)
+}
+
+func tlsCommand() *Command {
+	return &Command{
+		Name:    "tls",
+		Usage:   "tls fingerprint [-config path]",
+		Summary: "print the SHA-256 fingerprints of the server certificates for client pinning",
+		Run: func(args []string) error {
+			if len(args) == 0 || args[0] != "fingerprint" {
+				return &UsageError{"Expected subcommand: fingerprint."}
+			}
+
+			flags, common := newFlagSet("tls fingerprint", false)
+			err := parseFlags(flags, args[1:])
+			if err != nil {
+				return err
+			}
+
+			cfg, err := common.loadConfig()
+			if err != nil {
+				return err
+			}
+			if !cfg.Server.TLS.Enabled && !cfg.Server.WebTransport.Enabled {
+				return fmt.Errorf("Neither server.tls nor server.webtransport is enabled.")
+			}
+
+			if cfg.Server.TLS.Enabled {
+				certificate, err := loadCertificate(cfg)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("websocket     %s\n", server.CertificateFingerprint(certificate))
+			}
+			if cfg.Server.WebTransport.Enabled {
+				webTransport := cfg.Server.WebTransport
+				certificate, err := server.LoadOrCreateCertificate(webTransport.CertFile, webTransport.KeyFile, false, nil)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("webtransport  %s\n", server.CertificateFingerprint(certificate))
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,89 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/xTaube/vr-controlled-robot-arm/auth"
+)
+
+func tokenCommand() *Command {
+	return &Command{
+		Name:    "token",
+		Usage:   "token add|list|revoke [-config path] [-store path] [-name name] [-role role]",
+		Summary: "manage access tokens in the local token store",
+		Run: func(args []string) error {
+			if len(args) == 0 || (args[0] != "add" && args[0] != "list" && args[0] != "revoke") {
+				return &UsageError{"Expected subcommand: add, list or revoke."}
+			}
+			subcommand := args[0]
+
+			flags, common := newFlagSet("token "+subcommand, false)
+			storePath := flags.String("store", "", "token store file, defaults to auth.token_store from the configuration")
+			name := flags.String("name", "", "name of the token, e.g. the device or person using it")
+			roleName := flags.String("role", "operator", "role granted by a new token: observer, operator or admin")
+			err := parseFlags(flags, args[1:])
+			if err != nil {
+				return err
+			}
+
+			if *storePath == "" {
+				cfg, err := common.loadConfig()
+				if err != nil {
+					return err
+				}
+				*storePath = cfg.Auth.TokenStore
+			}
+			if *storePath == "" {
+				return &UsageError{"No token store, set auth.token_store in the configuration or pass -store."}
+			}
+			if subcommand != "list" && *name == "" {
+				return &UsageError{"-name is required."}
+			}
+
+			store, err := auth.LoadTokenStore(*storePath)
+			if err != nil {
+				return err
+			}
+
+			switch subcommand {
+			case "add":
+				role, err := auth.ParseRole(*roleName)
+				if err != nil {
+					return &UsageError{err.Error()}
+				}
+				token, err := store.Add(*name, role)
+				if err != nil {
+					return err
+				}
+				err = store.Save()
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(os.Stderr, "Added %s token %q to %s, it is not shown again:\n", role, *name, *storePath)
+				fmt.Println(token)
+
+			case "revoke":
+				err := store.Revoke(*name)
+				if err != nil {
+					return err
+				}
+				err = store.Save()
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(os.Stderr, "Revoked token %q.\n", *name)
+
+			case "list":
+				table := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(table, "NAME\tROLE\tCREATED")
+				for _, stored := range store.Tokens {
+					fmt.Fprintf(table, "%s\t%s\t%s\n", stored.Name, stored.Role, stored.Created.Format("2006-01-02 15:04"))
+				}
+				table.Flush()
+			}
+			return nil
+		},
+	}
+}
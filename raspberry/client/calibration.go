@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/xTaube/vr-controlled-robot-arm/robot"
+	"github.com/xTaube/vr-controlled-robot-arm/server"
+)
+
+var ErrCalibrationAborted = errors.New("calibration aborted")
+
+var ErrCalibrationStepUnfinished = errors.New("calibration step handler returned without confirming or aborting")
+
+var ErrCalibrationFinished = errors.New("calibration step already finished")
+
+type CalibrationStepHandler func(ctx context.Context, step *CalibrationStep) error
+
+type CalibrationStep struct {
+	Prompt string
+
+	client     *Client
+	trigger    chan Message
+	finished   bool
+	nextPrompt string
+	result     error
+}
+
+func (s *CalibrationStep) exchange(ctx context.Context, command server.CommandIdentifier, args ...any) (Message, bool, error) {
+	id, pending, err := s.client.send(command, args...)
+	if err != nil {
+		return Message{}, false, err
+	}
+	defer s.client.forget(id)
+
+	return s.client.await(ctx, pending, s.trigger)
+}
+
+func (s *CalibrationStep) finish(message Message, err error) {
+	s.finished = true
+	s.result = err
+	s.nextPrompt = strings.Join(message.Args, "$")
+}
+
+func (s *CalibrationStep) Move(ctx context.Context, joints robot.JointsAngles) (*robot.JointsAngles, error) {
+	message, fromTrigger, err := s.exchange(ctx, server.CALIBRATION_MOVE, jointsAnglesArgs(joints)...)
+	if fromTrigger {
+		s.finish(message, err)
+		return nil, ErrCalibrationFinished
+	}
+	if err != nil {
+		return nil, err
+	}
+	return jointsAnglesFromMessage(message)
+}
+
+func (s *CalibrationStep) Confirm(ctx context.Context) error {
+	message, fromTrigger, err := s.exchange(ctx, server.CALIBRATION_CONFIRM)
+	if fromTrigger {
+		s.finish(message, err)
+	}
+	return err
+}
+
+func (s *CalibrationStep) Abort(ctx context.Context) error {
+	message, fromTrigger, err := s.exchange(ctx, server.CALIBRATION_ABORT)
+	if !fromTrigger {
+		return err
+	}
+
+	var serverErr *ServerError
+	if errors.As(err, &serverErr) && serverErr.Code == server.RESPONSE_ROBOT_CALIBRATION_ERROR {
+		err = ErrCalibrationAborted
+	}
+	s.finish(message, err)
+	return nil
+}
+
+func (c *Client) Calibrate(ctx context.Context, handler CalibrationStepHandler) error {
+	id, trigger, err := c.send(server.CALIBRATE_ROBOT)
+	if err != nil {
+		return err
+	}
+	defer c.forget(id)
+
+	message, _, err := c.await(ctx, trigger, nil)
+	if err != nil {
+		return err
+	}
+
+	prompt := strings.Join(message.Args, "$")
+	for prompt != "" {
+		step := &CalibrationStep{Prompt: prompt, client: c, trigger: trigger}
+		err = handler(ctx, step)
+
+		if !step.finished {
+			abortErr := step.Abort(context.WithoutCancel(ctx))
+			if err == nil {
+				err = ErrCalibrationStepUnfinished
+			}
+			return errors.Join(err, abortErr)
+		}
+		if step.result != nil {
+			return step.result
+		}
+		if err != nil {
+			return err
+		}
+		prompt = step.nextPrompt
+	}
+	return nil
+}
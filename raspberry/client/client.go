@@ -0,0 +1,404 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/xTaube/vr-controlled-robot-arm/codec"
+	"github.com/xTaube/vr-controlled-robot-arm/server"
+)
+
+var ErrClosed = errors.New("connection closed")
+
+var ErrUnauthorized = errors.New("server rejected the access token")
+
+type ServerError struct {
+	Code    server.ErrorCode
+	Message string
+}
+
+func (err *ServerError) Error() string {
+	if err.Message == "" {
+		return fmt.Sprintf("Server responded with error code %d.", err.Code)
+	}
+	return fmt.Sprintf("Server responded with error code %d: %s", err.Code, err.Message)
+}
+
+type MalformedMessageError struct {
+	Raw    string
+	Reason string
+}
+
+func (err *MalformedMessageError) Error() string {
+	return fmt.Sprintf("Malformed message %q: %s", err.Raw, err.Reason)
+}
+
+type Message struct {
+	ID     string
+	Code   byte
+	Args   []string
+	Floats []float32
+	Event  *Event
+	Raw    string
+}
+
+func (m *Message) IsError() bool {
+	return m.Code >= byte(server.RESPONSE_UNKNOWN_COMMAND_ERROR)
+}
+
+func (m *Message) err() error {
+	if !m.IsError() {
+		return nil
+	}
+	return &ServerError{Code: server.ErrorCode(m.Code), Message: strings.Join(m.Args, "$")}
+}
+
+func (m *Message) float32Args(count int) ([]float32, error) {
+	if len(m.Floats) > 0 {
+		if len(m.Floats) < count {
+			return nil, &MalformedMessageError{m.Raw, fmt.Sprintf("expected %d values, got %d", count, len(m.Floats))}
+		}
+		return m.Floats[:count], nil
+	}
+	if len(m.Args) < count {
+		return nil, &MalformedMessageError{m.Raw, fmt.Sprintf("expected %d arguments, got %d", count, len(m.Args))}
+	}
+	values := make([]float32, count)
+	for i := range values {
+		value, err := strconv.ParseFloat(m.Args[i], 32)
+		if err != nil {
+			return nil, &MalformedMessageError{m.Raw, fmt.Sprintf("argument %d is not a number", i)}
+		}
+		values[i] = float32(value)
+	}
+	return values, nil
+}
+
+func (m *Message) stringArgs(count int) ([]string, error) {
+	if len(m.Args) < count {
+		return nil, &MalformedMessageError{m.Raw, fmt.Sprintf("expected %d arguments, got %d", count, len(m.Args))}
+	}
+	return m.Args[:count], nil
+}
+
+type messageCodec interface {
+	encodeRequest(command server.CommandIdentifier, id uint32, args []any) ([]byte, error)
+	decodeMessage(data []byte) (Message, error)
+	messageType() int
+}
+
+type textCodec struct{}
+
+func (c *textCodec) encodeRequest(command server.CommandIdentifier, id uint32, args []any) ([]byte, error) {
+	message := codec.TextMessage{
+		Head: strconv.Itoa(int(command)),
+		ID:   strconv.FormatUint(uint64(id), 10),
+		Args: make([]string, 0, len(args)),
+	}
+	for _, arg := range args {
+		switch arg := arg.(type) {
+		case float32:
+			message.Args = append(message.Args, formatFloat32(arg))
+		case string:
+			message.Args = append(message.Args, arg)
+		default:
+			return nil, fmt.Errorf("Unsupported argument %v.", arg)
+		}
+	}
+	return codec.EncodeText(message), nil
+}
+
+func (c *textCodec) decodeMessage(data []byte) (Message, error) {
+	raw := string(data)
+	message := codec.DecodeText(data)
+	if message.Head == codec.TEXT_EVENT_HEAD {
+		event, err := textEvent(message.Args)
+		if err != nil {
+			return Message{}, &MalformedMessageError{raw, err.Error()}
+		}
+		return Message{Event: event, Raw: raw}, nil
+	}
+	code, err := strconv.ParseUint(message.Head, 10, 8)
+	if err != nil {
+		return Message{}, &MalformedMessageError{raw, "missing response code"}
+	}
+	return Message{ID: message.ID, Code: byte(code), Args: message.Args, Raw: raw}, nil
+}
+
+func (c *textCodec) messageType() int {
+	return websocket.TextMessage
+}
+
+type binaryCodec struct{}
+
+func (c *binaryCodec) encodeRequest(command server.CommandIdentifier, id uint32, args []any) ([]byte, error) {
+	frame := codec.Frame{Type: codec.FRAME_REQUEST, Code: byte(command), ID: id}
+	for _, arg := range args {
+		switch arg := arg.(type) {
+		case float32:
+			frame.Floats = append(frame.Floats, arg)
+		case string:
+			frame.Strings = append(frame.Strings, arg)
+		default:
+			return nil, fmt.Errorf("Unsupported argument %v.", arg)
+		}
+	}
+	return frame.MarshalBinary()
+}
+
+func (c *binaryCodec) decodeMessage(data []byte) (Message, error) {
+	frame := codec.Frame{}
+	err := frame.UnmarshalBinary(data)
+	if err != nil {
+		return Message{}, &MalformedMessageError{fmt.Sprintf("%x", data), err.Error()}
+	}
+	if frame.Type == codec.FRAME_EVENT {
+		event, err := binaryEvent(&frame)
+		if err != nil {
+			return Message{}, &MalformedMessageError{fmt.Sprintf("%x", data), err.Error()}
+		}
+		return Message{Event: event, Raw: fmt.Sprintf("%x", data)}, nil
+	}
+	message := Message{Code: frame.Code, Args: frame.Strings, Floats: frame.Floats, Raw: fmt.Sprintf("%x", data)}
+	if frame.ID != 0 {
+		message.ID = strconv.FormatUint(uint64(frame.ID), 10)
+	}
+	return message, nil
+}
+
+func (c *binaryCodec) messageType() int {
+	return websocket.BinaryMessage
+}
+
+type Client struct {
+	connection *websocket.Conn
+	codec      messageCodec
+	writeMu    sync.Mutex
+
+	mu            sync.Mutex
+	nextID        uint32
+	pending       map[string]chan Message
+	onUnsolicited func(Message)
+	onEvent       func(Event)
+	readErr       error
+	closed        chan struct{}
+}
+
+func Dial(ctx context.Context, address string) (*Client, error) {
+	return DialSubprotocol(ctx, address, codec.TEXT_SUBPROTOCOL)
+}
+
+func DialSubprotocol(ctx context.Context, address string, subprotocol string) (*Client, error) {
+	return DialWithOptions(ctx, address, DialOptions{Subprotocol: subprotocol})
+}
+
+type DialOptions struct {
+	Subprotocol string
+	// Sent as a bearer token when the server requires authentication.
+	Token string
+	// SHA-256 fingerprint of the server certificate as printed by "tls fingerprint".
+	// When set the certificate is trusted by its fingerprint alone, which suits self-signed ones.
+	Fingerprint string
+	TLSConfig   *tls.Config
+}
+
+var ErrFingerprintMismatch = errors.New("server certificate does not match the pinned fingerprint")
+
+func pinnedTLSConfig(base *tls.Config, fingerprint string) *tls.Config {
+	config := &tls.Config{}
+	if base != nil {
+		config = base.Clone()
+	}
+	expected := strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))
+	config.InsecureSkipVerify = true
+	config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return ErrFingerprintMismatch
+		}
+		sum := sha256.Sum256(rawCerts[0])
+		if hex.EncodeToString(sum[:]) != expected {
+			return ErrFingerprintMismatch
+		}
+		return nil
+	}
+	return config
+}
+
+func DialWithOptions(ctx context.Context, address string, options DialOptions) (*Client, error) {
+	if options.Subprotocol == "" {
+		options.Subprotocol = codec.TEXT_SUBPROTOCOL
+	}
+
+	var messageCodec messageCodec
+	switch options.Subprotocol {
+	case codec.TEXT_SUBPROTOCOL:
+		messageCodec = &textCodec{}
+	case codec.BINARY_SUBPROTOCOL:
+		messageCodec = &binaryCodec{}
+	default:
+		return nil, fmt.Errorf("Unsupported subprotocol %q.", options.Subprotocol)
+	}
+
+	connection, err := DialConnection(ctx, address, options)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &Client{
+		connection: connection,
+		codec:      messageCodec,
+		pending:    map[string]chan Message{},
+		closed:     make(chan struct{}),
+	}
+	go client.readLoop()
+	return client, nil
+}
+
+// DialConnection opens the websocket without a Client on top, for tools speaking a subprotocol themselves.
+func DialConnection(ctx context.Context, address string, options DialOptions) (*websocket.Conn, error) {
+	subprotocol := options.Subprotocol
+	if !strings.Contains(address, "://") {
+		address = fmt.Sprintf("ws://%s/control", address)
+	}
+
+	header := http.Header{}
+	if options.Token != "" {
+		header.Set("Authorization", "Bearer "+options.Token)
+	}
+
+	dialer := websocket.Dialer{Subprotocols: []string{subprotocol}, TLSClientConfig: options.TLSConfig}
+	if options.Fingerprint != "" {
+		dialer.TLSClientConfig = pinnedTLSConfig(options.TLSConfig, options.Fingerprint)
+	}
+	connection, response, err := dialer.DialContext(ctx, address, header)
+	if response != nil && response.StatusCode == http.StatusUnauthorized {
+		return nil, ErrUnauthorized
+	}
+	if err != nil {
+		return nil, err
+	}
+	if connection.Subprotocol() != subprotocol {
+		connection.Close()
+		return nil, fmt.Errorf("Server does not support subprotocol %q.", subprotocol)
+	}
+	return connection, nil
+}
+
+func (c *Client) OnUnsolicited(handler func(Message)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onUnsolicited = handler
+}
+
+func (c *Client) readLoop() {
+	for {
+		_, data, err := c.connection.ReadMessage()
+		if err != nil {
+			c.mu.Lock()
+			c.readErr = err
+			c.mu.Unlock()
+			close(c.closed)
+			return
+		}
+
+		message, err := c.codec.decodeMessage(data)
+		if err != nil {
+			continue
+		}
+
+		c.mu.Lock()
+		pending, ok := c.pending[message.ID]
+		handler := c.onUnsolicited
+		eventHandler := c.onEvent
+		c.mu.Unlock()
+
+		if message.Event != nil {
+			if eventHandler != nil {
+				eventHandler(*message.Event)
+			}
+		} else if ok {
+			pending <- message
+		} else if handler != nil {
+			handler(message)
+		}
+	}
+}
+
+func (c *Client) send(command server.CommandIdentifier, args ...any) (string, chan Message, error) {
+	c.mu.Lock()
+	c.nextID++
+	if c.nextID == 0 {
+		c.nextID++
+	}
+	requestID := c.nextID
+	id := strconv.FormatUint(uint64(requestID), 10)
+	pending := make(chan Message, 4)
+	c.pending[id] = pending
+	c.mu.Unlock()
+
+	request, err := c.codec.encodeRequest(command, requestID, args)
+	if err == nil {
+		err = c.write(c.codec.messageType(), request)
+	}
+	if err != nil {
+		c.forget(id)
+		return "", nil, err
+	}
+	return id, pending, nil
+}
+
+func (c *Client) forget(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, id)
+}
+
+func (c *Client) await(ctx context.Context, pending chan Message, trigger chan Message) (Message, bool, error) {
+	select {
+	case message := <-pending:
+		return message, false, message.err()
+	case message := <-trigger:
+		return message, true, message.err()
+	case <-ctx.Done():
+		return Message{}, false, ctx.Err()
+	case <-c.closed:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return Message{}, false, fmt.Errorf("%w: %s", ErrClosed, c.readErr)
+	}
+}
+
+func (c *Client) roundTrip(ctx context.Context, command server.CommandIdentifier, args ...any) (Message, error) {
+	id, pending, err := c.send(command, args...)
+	if err != nil {
+		return Message{}, err
+	}
+	defer c.forget(id)
+
+	message, _, err := c.await(ctx, pending, nil)
+	return message, err
+}
+
+func (c *Client) write(messageType int, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.connection.WriteMessage(messageType, data)
+}
+
+func (c *Client) Close() error {
+	c.write(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+	)
+	return c.connection.Close()
+}
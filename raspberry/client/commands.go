@@ -0,0 +1,283 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/xTaube/vr-controlled-robot-arm/robot"
+	"github.com/xTaube/vr-controlled-robot-arm/server"
+)
+
+func formatFloat32(value float32) string {
+	return strconv.FormatFloat(float64(value), 'f', -1, 32)
+}
+
+func jointsAnglesArgs(joints robot.JointsAngles) []any {
+	return []any{joints.Z, joints.Y, joints.X, joints.V, joints.W}
+}
+
+func jointsAnglesFromMessage(message Message) (*robot.JointsAngles, error) {
+	values, err := message.float32Args(5)
+	if err != nil {
+		return nil, err
+	}
+	return &robot.JointsAngles{Z: values[0], Y: values[1], X: values[2], V: values[3], W: values[4]}, nil
+}
+
+func (c *Client) Move(ctx context.Context, joints robot.JointsAngles) (*robot.JointsAngles, error) {
+	message, err := c.roundTrip(ctx, server.MOVE_ROBOT, jointsAnglesArgs(joints)...)
+	if err != nil {
+		return nil, err
+	}
+	return jointsAnglesFromMessage(message)
+}
+
+func (c *Client) SetSpeed(ctx context.Context, speed float32) error {
+	_, err := c.roundTrip(ctx, server.SET_ROBOT_SPEED, speed)
+	return err
+}
+
+func (c *Client) GetPosition(ctx context.Context) (*robot.JointsAngles, error) {
+	message, err := c.roundTrip(ctx, server.GET_ROBOT_CURRENT_POSITION)
+	if err != nil {
+		return nil, err
+	}
+	return jointsAnglesFromMessage(message)
+}
+
+func (c *Client) OpenGripper(ctx context.Context) error {
+	_, err := c.roundTrip(ctx, server.OPEN_GRIPPER)
+	return err
+}
+
+func (c *Client) CloseGripper(ctx context.Context) error {
+	_, err := c.roundTrip(ctx, server.CLOSE_GRIPPER)
+	return err
+}
+
+func (c *Client) StartVideo(ctx context.Context) ([]string, error) {
+	message, err := c.roundTrip(ctx, server.START_VIDEO_STREAM)
+	if err != nil {
+		return nil, err
+	}
+	return message.Args, nil
+}
+
+func (c *Client) StopVideo(ctx context.Context) error {
+	_, err := c.roundTrip(ctx, server.STOP_VIDEO_STREAM)
+	return err
+}
+
+func (c *Client) Subscribe(ctx context.Context, topic string) ([]string, error) {
+	message, err := c.roundTrip(ctx, server.SUBSCRIBE, topic)
+	if err != nil {
+		return nil, err
+	}
+	return message.Args, nil
+}
+
+func (c *Client) Unsubscribe(ctx context.Context, topic string) ([]string, error) {
+	message, err := c.roundTrip(ctx, server.UNSUBSCRIBE, topic)
+	if err != nil {
+		return nil, err
+	}
+	return message.Args, nil
+}
+
+func (c *Client) AcquireControl(ctx context.Context) (string, error) {
+	message, err := c.roundTrip(ctx, server.ACQUIRE_CONTROL)
+	if err != nil {
+		return "", err
+	}
+	args, err := message.stringArgs(1)
+	if err != nil {
+		return "", err
+	}
+	return args[0], nil
+}
+
+func (c *Client) ReleaseControl(ctx context.Context) error {
+	_, err := c.roundTrip(ctx, server.RELEASE_CONTROL)
+	return err
+}
+
+func (c *Client) HandoverControl(ctx context.Context, session string) error {
+	_, err := c.roundTrip(ctx, server.HANDOVER_CONTROL, session)
+	return err
+}
+
+func (c *Client) Heartbeat(ctx context.Context) error {
+	_, err := c.roundTrip(ctx, server.HEARTBEAT)
+	return err
+}
+
+// GetControl returns the id of this session and of the one holding control, empty when nobody does.
+func (c *Client) GetControl(ctx context.Context) (string, string, error) {
+	message, err := c.roundTrip(ctx, server.GET_CONTROL)
+	if err != nil {
+		return "", "", err
+	}
+	args, err := message.stringArgs(2)
+	if err != nil {
+		return "", "", err
+	}
+	return args[0], args[1], nil
+}
+
+func (c *Client) GetVideoStreams(ctx context.Context) ([]string, error) {
+	message, err := c.roundTrip(ctx, server.GET_VIDEO_STREAMS)
+	if err != nil {
+		return nil, err
+	}
+	return message.Args, nil
+}
+
+func (c *Client) TeachStart(ctx context.Context, name string) error {
+	_, err := c.roundTrip(ctx, server.TEACH_START, name)
+	return err
+}
+
+// AddWaypoint records the position the arm stands at and returns it.
+func (c *Client) AddWaypoint(ctx context.Context) (*robot.JointsAngles, error) {
+	message, err := c.roundTrip(ctx, server.TEACH_ADD_WAYPOINT)
+	if err != nil {
+		return nil, err
+	}
+	return jointsAnglesFromMessage(message)
+}
+
+// SaveProgram returns the number of waypoints saved.
+func (c *Client) SaveProgram(ctx context.Context) (int, error) {
+	message, err := c.roundTrip(ctx, server.TEACH_SAVE)
+	if err != nil {
+		return 0, err
+	}
+	return waypointsFromMessage(message)
+}
+
+// RunProgram returns once the program started, with its number of waypoints.
+// Progress is reported on the program topic.
+func (c *Client) RunProgram(ctx context.Context, name string) (int, error) {
+	message, err := c.roundTrip(ctx, server.RUN_PROGRAM, name)
+	if err != nil {
+		return 0, err
+	}
+	return waypointsFromMessage(message)
+}
+
+func waypointsFromMessage(message Message) (int, error) {
+	args, err := message.stringArgs(2)
+	if err != nil {
+		return 0, err
+	}
+	waypoints, err := strconv.Atoi(args[1])
+	if err != nil {
+		return 0, &MalformedMessageError{message.Raw, "waypoint count is not a number"}
+	}
+	return waypoints, nil
+}
+
+func (c *Client) PauseProgram(ctx context.Context) error {
+	_, err := c.roundTrip(ctx, server.PAUSE_PROGRAM)
+	return err
+}
+
+func (c *Client) ResumeProgram(ctx context.Context) error {
+	_, err := c.roundTrip(ctx, server.RESUME_PROGRAM)
+	return err
+}
+
+func (c *Client) AbortProgram(ctx context.Context) error {
+	_, err := c.roundTrip(ctx, server.ABORT_PROGRAM)
+	return err
+}
+
+// GotoPose starts moving to a stored pose and returns its joints.
+func (c *Client) GotoPose(ctx context.Context, name string) (*robot.JointsAngles, error) {
+	message, err := c.roundTrip(ctx, server.GOTO_POSE, name)
+	if err != nil {
+		return nil, err
+	}
+	return jointsAnglesFromMessage(message)
+}
+
+// SaveCurrentAsPose stores where the arm stands under name, replacing a pose of that name.
+func (c *Client) SaveCurrentAsPose(ctx context.Context, name string) (*robot.JointsAngles, error) {
+	message, err := c.roundTrip(ctx, server.SAVE_CURRENT_AS_POSE, name)
+	if err != nil {
+		return nil, err
+	}
+	return jointsAnglesFromMessage(message)
+}
+
+func (c *Client) AddPose(ctx context.Context, name string, joints robot.JointsAngles) error {
+	_, err := c.roundTrip(ctx, server.ADD_POSE, append([]any{name}, jointsAnglesArgs(joints)...)...)
+	return err
+}
+
+func (c *Client) ListPoses(ctx context.Context) ([]string, error) {
+	message, err := c.roundTrip(ctx, server.LIST_POSES)
+	if err != nil {
+		return nil, err
+	}
+	return message.Args, nil
+}
+
+func (c *Client) RenamePose(ctx context.Context, from string, to string) error {
+	_, err := c.roundTrip(ctx, server.RENAME_POSE, from, to)
+	return err
+}
+
+func (c *Client) DeletePose(ctx context.Context, name string) error {
+	_, err := c.roundTrip(ctx, server.DELETE_POSE, name)
+	return err
+}
+
+// UploadScript stores a script under name, replacing one of that name. Mistakes are reported with their line.
+func (c *Client) UploadScript(ctx context.Context, name string, source string) error {
+	_, err := c.roundTrip(ctx, server.UPLOAD_SCRIPT, name, source)
+	return err
+}
+
+// RunScript returns once the script started, its output arrives on the script event topic.
+func (c *Client) RunScript(ctx context.Context, name string) error {
+	_, err := c.roundTrip(ctx, server.RUN_SCRIPT, name)
+	return err
+}
+
+func (c *Client) StopScript(ctx context.Context) error {
+	_, err := c.roundTrip(ctx, server.STOP_SCRIPT)
+	return err
+}
+
+// UploadGCode stores a G-code program under name, replacing one of that name. Mistakes are reported with their line.
+func (c *Client) UploadGCode(ctx context.Context, name string, source string) error {
+	_, err := c.roundTrip(ctx, server.UPLOAD_GCODE, name, source)
+	return err
+}
+
+// RunGCode returns once the program started, it is paused, resumed and aborted like a taught program.
+func (c *Client) RunGCode(ctx context.Context, name string) error {
+	_, err := c.roundTrip(ctx, server.RUN_GCODE, name)
+	return err
+}
+
+// ListCommands returns the server's catalogue of commands in the order of their ids.
+func (c *Client) ListCommands(ctx context.Context) ([]server.CommandDescription, error) {
+	message, err := c.roundTrip(ctx, server.LIST_COMMANDS)
+	if err != nil {
+		return nil, err
+	}
+	commands := make([]server.CommandDescription, 0, len(message.Args))
+	for _, arg := range message.Args {
+		var command server.CommandDescription
+		err := json.Unmarshal([]byte(arg), &command)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot decode command description: %w", err)
+		}
+		commands = append(commands, command)
+	}
+	return commands, nil
+}
@@ -0,0 +1,73 @@
+package client
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/xTaube/vr-controlled-robot-arm/codec"
+	"github.com/xTaube/vr-controlled-robot-arm/robot"
+	"github.com/xTaube/vr-controlled-robot-arm/server"
+)
+
+type Event struct {
+	Topic   string
+	Name    string
+	Joints  *robot.JointsAngles
+	Details []string
+}
+
+// Text events do not mark whether joints follow the name, so the events carrying them are listed here.
+func textEventHasJoints(event *Event) bool {
+	switch event.Topic {
+	case server.TOPIC_POSITION.String():
+		return true
+	case server.TOPIC_PROGRAM.String():
+		return event.Name == "waypoint" || event.Name == "line"
+	case server.TOPIC_POSES.String():
+		return event.Name == "saved"
+	default:
+		return false
+	}
+}
+
+func textEvent(args []string) (*Event, error) {
+	if len(args) < 2 {
+		return nil, errors.New("event without topic and name")
+	}
+	event := &Event{Topic: args[0], Name: args[1], Details: args[2:]}
+	if !textEventHasJoints(event) {
+		return event, nil
+	}
+
+	if len(event.Details) < 5 {
+		return nil, errors.New(event.Topic + " event without joints")
+	}
+	values := make([]float32, 5)
+	for i := range values {
+		value, err := strconv.ParseFloat(event.Details[i], 32)
+		if err != nil {
+			return nil, errors.New(event.Topic + " event joint is not a number")
+		}
+		values[i] = float32(value)
+	}
+	event.Joints = &robot.JointsAngles{Z: values[0], Y: values[1], X: values[2], V: values[3], W: values[4]}
+	event.Details = event.Details[5:]
+	return event, nil
+}
+
+func binaryEvent(frame *codec.Frame) (*Event, error) {
+	if len(frame.Strings) < 1 {
+		return nil, errors.New("event without name")
+	}
+	event := &Event{Topic: server.EventTopic(frame.Code).String(), Name: frame.Strings[0], Details: frame.Strings[1:]}
+	if len(frame.Floats) >= 5 {
+		event.Joints = &robot.JointsAngles{Z: frame.Floats[0], Y: frame.Floats[1], X: frame.Floats[2], V: frame.Floats[3], W: frame.Floats[4]}
+	}
+	return event, nil
+}
+
+func (c *Client) OnEvent(handler func(Event)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onEvent = handler
+}
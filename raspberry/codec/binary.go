@@ -0,0 +1,127 @@
+package codec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+type FrameType byte
+
+const (
+	FRAME_REQUEST FrameType = iota + 1
+	FRAME_RESPONSE
+	FRAME_PROMPT
+	FRAME_EVENT
+)
+
+// Frame layout, multi-byte values are little-endian like on the UART:
+//
+//	type (1) | code (1) | id (4, 0 = none) | float count (1) | float32 values (4 each)
+//	| string count (1) | per string: length (2) + UTF-8 bytes
+const (
+	FRAME_TYPE_OFFSET         = 0
+	FRAME_CODE_OFFSET         = 1
+	FRAME_ID_OFFSET           = 2
+	FRAME_ID_SIZE             = 4
+	FRAME_FLOATS_COUNT_OFFSET = FRAME_ID_OFFSET + FRAME_ID_SIZE
+	FRAME_HEADER_SIZE         = FRAME_FLOATS_COUNT_OFFSET + 1
+	FRAME_FLOAT_SIZE          = 4
+	FRAME_STRING_LENGTH_SIZE  = 2
+	FRAME_MAX_VALUES          = math.MaxUint8
+	FRAME_MAX_STRING_LENGTH   = math.MaxUint16
+)
+
+type MalformedFrameError struct {
+	reason string
+}
+
+func (err *MalformedFrameError) Error() string {
+	return fmt.Sprintf("Malformed frame: %s.", err.reason)
+}
+
+type Frame struct {
+	Type    FrameType
+	Code    byte
+	ID      uint32
+	Floats  []float32
+	Strings []string
+}
+
+func (f *Frame) size() int {
+	size := FRAME_HEADER_SIZE + len(f.Floats)*FRAME_FLOAT_SIZE + 1
+	for _, value := range f.Strings {
+		size += FRAME_STRING_LENGTH_SIZE + len(value)
+	}
+	return size
+}
+
+func (f *Frame) AppendBinary(data []byte) ([]byte, error) {
+	if len(f.Floats) > FRAME_MAX_VALUES {
+		return nil, &MalformedFrameError{fmt.Sprintf("%d floats exceed the limit of %d", len(f.Floats), FRAME_MAX_VALUES)}
+	}
+	if len(f.Strings) > FRAME_MAX_VALUES {
+		return nil, &MalformedFrameError{fmt.Sprintf("%d strings exceed the limit of %d", len(f.Strings), FRAME_MAX_VALUES)}
+	}
+
+	data = append(data, byte(f.Type), f.Code)
+	data = binary.LittleEndian.AppendUint32(data, f.ID)
+	data = append(data, byte(len(f.Floats)))
+	for _, value := range f.Floats {
+		data = binary.LittleEndian.AppendUint32(data, math.Float32bits(value))
+	}
+	data = append(data, byte(len(f.Strings)))
+	for i, value := range f.Strings {
+		if len(value) > FRAME_MAX_STRING_LENGTH {
+			return nil, &MalformedFrameError{fmt.Sprintf("string %d is longer than %d bytes", i, FRAME_MAX_STRING_LENGTH)}
+		}
+		data = binary.LittleEndian.AppendUint16(data, uint16(len(value)))
+		data = append(data, value...)
+	}
+	return data, nil
+}
+
+func (f *Frame) MarshalBinary() ([]byte, error) {
+	return f.AppendBinary(make([]byte, 0, f.size()))
+}
+
+func (f *Frame) UnmarshalBinary(data []byte) error {
+	if len(data) < FRAME_HEADER_SIZE {
+		return &MalformedFrameError{fmt.Sprintf("%d bytes is shorter than the %d byte header", len(data), FRAME_HEADER_SIZE)}
+	}
+	f.Type = FrameType(data[FRAME_TYPE_OFFSET])
+	f.Code = data[FRAME_CODE_OFFSET]
+	f.ID = binary.LittleEndian.Uint32(data[FRAME_ID_OFFSET : FRAME_ID_OFFSET+FRAME_ID_SIZE])
+
+	floatsCount := int(data[FRAME_FLOATS_COUNT_OFFSET])
+	offset := FRAME_HEADER_SIZE
+	if len(data) < offset+floatsCount*FRAME_FLOAT_SIZE+1 {
+		return &MalformedFrameError{fmt.Sprintf("truncated, expected %d floats", floatsCount)}
+	}
+	f.Floats = make([]float32, floatsCount)
+	for i := range f.Floats {
+		f.Floats[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[offset : offset+FRAME_FLOAT_SIZE]))
+		offset += FRAME_FLOAT_SIZE
+	}
+
+	stringsCount := int(data[offset])
+	offset++
+	f.Strings = make([]string, stringsCount)
+	for i := range f.Strings {
+		if len(data) < offset+FRAME_STRING_LENGTH_SIZE {
+			return &MalformedFrameError{fmt.Sprintf("truncated, expected %d strings", stringsCount)}
+		}
+		length := int(binary.LittleEndian.Uint16(data[offset : offset+FRAME_STRING_LENGTH_SIZE]))
+		offset += FRAME_STRING_LENGTH_SIZE
+		if len(data) < offset+length {
+			return &MalformedFrameError{fmt.Sprintf("truncated string %d", i)}
+		}
+		f.Strings[i] = string(data[offset : offset+length])
+		offset += length
+	}
+
+	if offset != len(data) {
+		return &MalformedFrameError{fmt.Sprintf("%d unexpected trailing bytes", len(data)-offset)}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package codec
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testFrame = Frame{
+	Type:    FRAME_RESPONSE,
+	Code:    7,
+	ID:      0xDEADBEEF,
+	Floats:  []float32{12.345678, -87.654321, 0, float32(math.Inf(1))},
+	Strings: []string{"", "pick", "zażółć $ # gęślą"},
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	for _, frame := range []Frame{
+		testFrame,
+		{Type: FRAME_REQUEST, Code: 1, Floats: []float32{}, Strings: []string{}},
+		{Type: FRAME_EVENT, Floats: []float32{}, Strings: []string{strings.Repeat("x", FRAME_MAX_STRING_LENGTH)}},
+	} {
+		data, err := frame.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded := Frame{}
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, frame) {
+			t.Errorf("got %+v, want %+v", decoded, frame)
+		}
+	}
+}
+
+func TestFrameUnmarshalRefusesTruncatedFrames(t *testing.T) {
+	data, err := testFrame.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for length := 0; length < len(data); length++ {
+		err := (&Frame{}).UnmarshalBinary(data[:length])
+		var malformed *MalformedFrameError
+		if !errors.As(err, &malformed) {
+			t.Errorf("%d of %d bytes: got %v, want a MalformedFrameError", length, len(data), err)
+		}
+	}
+}
+
+func TestFrameUnmarshalRefusesTrailingBytes(t *testing.T) {
+	data, err := testFrame.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = (&Frame{}).UnmarshalBinary(append(data, 0, 0))
+	if err == nil || err.Error() != "Malformed frame: 2 unexpected trailing bytes." {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestFrameUnmarshalRefusesStringsLongerThanTheFrame(t *testing.T) {
+	// One string announced as 0xFFFF bytes long, followed by only three.
+	data := []byte{byte(FRAME_REQUEST), 1, 0, 0, 0, 0, 0, 1, 0xFF, 0xFF, 'a', 'b', 'c'}
+	err := (&Frame{}).UnmarshalBinary(data)
+	if err == nil || err.Error() != "Malformed frame: truncated string 0." {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestFrameMarshalRefusesOversizedValues(t *testing.T) {
+	for _, frame := range []Frame{
+		{Strings: []string{strings.Repeat("x", FRAME_MAX_STRING_LENGTH+1)}},
+		{Floats: make([]float32, FRAME_MAX_VALUES+1)},
+		{Strings: make([]string, FRAME_MAX_VALUES+1)},
+	} {
+		_, err := frame.MarshalBinary()
+		var malformed *MalformedFrameError
+		if !errors.As(err, &malformed) {
+			t.Errorf("%d floats, %d strings: got %v, want a MalformedFrameError", len(frame.Floats), len(frame.Strings), err)
+		}
+	}
+}
+
+func BenchmarkFrameMarshal(b *testing.B) {
+	b.ReportAllocs()
+	for range b.N {
+		testFrame.MarshalBinary()
+	}
+}
+
+func BenchmarkFrameUnmarshal(b *testing.B) {
+	data, err := testFrame.MarshalBinary()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for range b.N {
+		(&Frame{}).UnmarshalBinary(data)
+	}
+}
@@ -0,0 +1,7 @@
+package codec
+
+const (
+	TEXT_SUBPROTOCOL   = "v-arm.text.v1"
+	JSON_SUBPROTOCOL   = "v-arm.json.v1"
+	BINARY_SUBPROTOCOL = "v-arm.binary.v1"
+)
@@ -0,0 +1,40 @@
+package codec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Streams carry no message boundaries, so every message is prefixed with its
+// little-endian uint32 length.
+const (
+	STREAM_LENGTH_PREFIX_SIZE = 4
+	MAX_STREAM_MESSAGE_SIZE   = 1 << 16
+)
+
+func WriteStreamMessage(w io.Writer, data []byte) error {
+	if len(data) > MAX_STREAM_MESSAGE_SIZE {
+		return &MalformedFrameError{fmt.Sprintf("%d byte message exceeds the limit of %d bytes", len(data), MAX_STREAM_MESSAGE_SIZE)}
+	}
+	message := make([]byte, STREAM_LENGTH_PREFIX_SIZE, STREAM_LENGTH_PREFIX_SIZE+len(data))
+	binary.LittleEndian.PutUint32(message, uint32(len(data)))
+	_, err := w.Write(append(message, data...))
+	return err
+}
+
+func ReadStreamMessage(r io.Reader) ([]byte, error) {
+	prefix := make([]byte, STREAM_LENGTH_PREFIX_SIZE)
+	_, err := io.ReadFull(r, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	length := binary.LittleEndian.Uint32(prefix)
+	if length > MAX_STREAM_MESSAGE_SIZE {
+		return nil, &MalformedFrameError{fmt.Sprintf("%d byte message exceeds the limit of %d bytes", length, MAX_STREAM_MESSAGE_SIZE)}
+	}
+	data := make([]byte, length)
+	_, err = io.ReadFull(r, data)
+	return data, err
+}
@@ -0,0 +1,29 @@
+package codec
+
+import "strings"
+
+const (
+	TEXT_ARGUMENTS_SEPARATOR = "$"
+	TEXT_ID_SEPARATOR        = "#"
+	TEXT_EVENT_HEAD          = "E"
+)
+
+type TextMessage struct {
+	Head string
+	ID   string
+	Args []string
+}
+
+func DecodeText(data []byte) TextMessage {
+	fields := strings.Split(string(data), TEXT_ARGUMENTS_SEPARATOR)
+	head, id, _ := strings.Cut(fields[0], TEXT_ID_SEPARATOR)
+	return TextMessage{Head: head, ID: id, Args: fields[1:]}
+}
+
+func EncodeText(message TextMessage) []byte {
+	head := message.Head
+	if message.ID != "" {
+		head += TEXT_ID_SEPARATOR + message.ID
+	}
+	return []byte(strings.Join(append([]string{head}, message.Args...), TEXT_ARGUMENTS_SEPARATOR))
+}
@@ -0,0 +1,62 @@
+package codec
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestTextRoundTrip(t *testing.T) {
+	for _, message := range []TextMessage{
+		{Head: "1", Args: []string{}},
+		{Head: "2", ID: "42", Args: []string{"12.5", "", "pick"}},
+	} {
+		decoded := DecodeText(EncodeText(message))
+		if !reflect.DeepEqual(decoded, message) {
+			t.Errorf("got %+v, want %+v", decoded, message)
+		}
+	}
+}
+
+func TestStreamMessages(t *testing.T) {
+	stream := &bytes.Buffer{}
+	for _, message := range []string{"first", "", "third"} {
+		if err := WriteStreamMessage(stream, []byte(message)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range []string{"first", "", "third"} {
+		data, err := ReadStreamMessage(stream)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("got %q, want %q", data, want)
+		}
+	}
+
+	if err := WriteStreamMessage(stream, make([]byte, MAX_STREAM_MESSAGE_SIZE+1)); err == nil {
+		t.Error("oversized message was written")
+	}
+	// A length prefix beyond the limit is refused before anything is allocated.
+	_, err := ReadStreamMessage(bytes.NewReader([]byte{0xFF, 0xFF, 0xFF, 0xFF}))
+	if _, ok := err.(*MalformedFrameError); !ok {
+		t.Errorf("got %v, want a MalformedFrameError", err)
+	}
+}
+
+func BenchmarkEncodeText(b *testing.B) {
+	message := TextMessage{Head: "2", ID: "42", Args: []string{"12.345678", "-87.65432", "33.333332", "-4.5", "71.0001"}}
+	b.ReportAllocs()
+	for range b.N {
+		EncodeText(message)
+	}
+}
+
+func BenchmarkDecodeText(b *testing.B) {
+	data := []byte("2#42$12.345678$-87.65432$33.333332$-4.5$71.0001")
+	b.ReportAllocs()
+	for range b.N {
+		DecodeText(data)
+	}
+}
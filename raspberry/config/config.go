@@ -0,0 +1,619 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/xTaube/vr-controlled-robot-arm/auth"
+	"github.com/xTaube/vr-controlled-robot-arm/kinematics"
+	"github.com/xTaube/vr-controlled-robot-arm/logging"
+	"github.com/xTaube/vr-controlled-robot-arm/robot"
+	"github.com/xTaube/vr-controlled-robot-arm/script"
+	"go.bug.st/serial"
+	"gopkg.in/yaml.v3"
+)
+
+type SerialConfig struct {
+	Port     string `yaml:"port"`
+	BaudRate int    `yaml:"baud_rate"`
+	DataBits int    `yaml:"data_bits"`
+	Parity   string `yaml:"parity"`
+	StopBits string `yaml:"stop_bits"`
+}
+
+type CameraConfig struct {
+	Name          string `yaml:"name"`
+	Device        string `yaml:"device"`
+	Width         int    `yaml:"width"`
+	Height        int    `yaml:"height"`
+	Framerate     int    `yaml:"framerate"`
+	InputFormat   string `yaml:"input_format"`
+	StreamAddress string `yaml:"stream_address"`
+}
+
+type StreamConfig struct {
+	PublicHost string `yaml:"public_host"`
+	LogDir     string `yaml:"log_dir"`
+}
+
+type WebTransportConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Port     string `yaml:"port"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+type TLSConfig struct {
+	Enabled          bool     `yaml:"enabled"`
+	CertFile         string   `yaml:"cert_file"`
+	KeyFile          string   `yaml:"key_file"`
+	SelfSigned       bool     `yaml:"self_signed"`
+	Hosts            []string `yaml:"hosts"`
+	PlainConnections string   `yaml:"plain_connections"`
+}
+
+type ServerConfig struct {
+	Port         string             `yaml:"port"`
+	WebSocket    bool               `yaml:"websocket"`
+	TLS          TLSConfig          `yaml:"tls"`
+	WebTransport WebTransportConfig `yaml:"webtransport"`
+	LeaseTimeout time.Duration      `yaml:"lease_timeout"`
+}
+
+type TokenConfig struct {
+	Name  string `yaml:"name"`
+	Token string `yaml:"token"`
+	Role  string `yaml:"role"`
+}
+
+type AuthConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	Tokens     []TokenConfig `yaml:"tokens"`
+	TokenStore string        `yaml:"token_store"`
+}
+
+type JointLimits struct {
+	Min float32 `yaml:"min"`
+	Max float32 `yaml:"max"`
+}
+
+type LimitsConfig struct {
+	MinSpeed float32     `yaml:"min_speed"`
+	MaxSpeed float32     `yaml:"max_speed"`
+	X        JointLimits `yaml:"x"`
+	Y        JointLimits `yaml:"y"`
+	Z        JointLimits `yaml:"z"`
+	V        JointLimits `yaml:"v"`
+	W        JointLimits `yaml:"w"`
+}
+
+type LoggingConfig struct {
+	File         string            `yaml:"file"`
+	Microseconds bool              `yaml:"microseconds"`
+	Level        string            `yaml:"level"`
+	Format       string            `yaml:"format"`
+	Packages     map[string]string `yaml:"packages"`
+}
+
+type ShutdownConfig struct {
+	Timeout  time.Duration      `yaml:"timeout"`
+	Park     bool               `yaml:"park"`
+	ParkPose robot.JointsAngles `yaml:"park_pose"`
+}
+
+type RecordingConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Dir     string `yaml:"dir"`
+}
+
+type ProgramsConfig struct {
+	Dir string `yaml:"dir"`
+}
+
+type ScriptsConfig struct {
+	Dir      string        `yaml:"dir"`
+	Timeout  time.Duration `yaml:"timeout"`
+	MaxSteps uint64        `yaml:"max_steps"`
+}
+
+type PosesConfig struct {
+	File string `yaml:"file"`
+}
+
+// AxisConfig places a joint of the kinematic model on the arm, arm angle = zero + direction * model angle.
+type AxisConfig struct {
+	Zero      float64 `yaml:"zero"`
+	Direction float64 `yaml:"direction"`
+}
+
+// KinematicsConfig describes the arm's geometry, Cartesian G-code moves are refused without it.
+type KinematicsConfig struct {
+	Enabled    bool       `yaml:"enabled"`
+	BaseHeight float64    `yaml:"base_height"`
+	UpperArm   float64    `yaml:"upper_arm"`
+	Forearm    float64    `yaml:"forearm"`
+	Tool       float64    `yaml:"tool"`
+	Base       AxisConfig `yaml:"base"`
+	Shoulder   AxisConfig `yaml:"shoulder"`
+	Elbow      AxisConfig `yaml:"elbow"`
+	Wrist      AxisConfig `yaml:"wrist"`
+}
+
+type Config struct {
+	Serial     SerialConfig     `yaml:"serial"`
+	Cameras    []CameraConfig   `yaml:"cameras"`
+	Stream     StreamConfig     `yaml:"stream"`
+	Server     ServerConfig     `yaml:"server"`
+	Auth       AuthConfig       `yaml:"auth"`
+	Limits     LimitsConfig     `yaml:"limits"`
+	Logging    LoggingConfig    `yaml:"logging"`
+	Shutdown   ShutdownConfig   `yaml:"shutdown"`
+	Recording  RecordingConfig  `yaml:"recording"`
+	Programs   ProgramsConfig   `yaml:"programs"`
+	Poses      PosesConfig      `yaml:"poses"`
+	Scripts    ScriptsConfig    `yaml:"scripts"`
+	Kinematics KinematicsConfig `yaml:"kinematics"`
+}
+
+type ValidationError struct {
+	Path     string
+	Problems []string
+}
+
+func (err *ValidationError) Error() string {
+	source := "default configuration"
+	if err.Path != "" {
+		source = err.Path
+	}
+	return fmt.Sprintf("Invalid configuration in %s:\n  - %s", source, strings.Join(err.Problems, "\n  - "))
+}
+
+var validParities = []string{"none", "odd", "even", "mark", "space"}
+var validStopBits = []string{"1", "1.5", "2"}
+var validInputFormats = []string{"mjpeg"}
+var validPlainConnections = []string{"reject", "redirect"}
+var validLogFormats = []string{logging.FORMAT_TEXT, logging.FORMAT_JSON}
+
+// Programs, poses and scripts are made to be kept, so they default to the user's configuration directory rather than a temporary one.
+func defaultDataPath(name string) string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return name
+	}
+	return filepath.Join(dir, "v-arm", name)
+}
+
+func Default() *Config {
+	return &Config{
+		Serial: SerialConfig{
+			Port:     "/dev/ttyACM0",
+			BaudRate: 115200,
+			DataBits: 8,
+			Parity:   "even",
+			StopBits: "1",
+		},
+		Cameras: []CameraConfig{
+			{
+				Name:          "feed0",
+				Device:        "/dev/video0",
+				Width:         1280,
+				Height:        720,
+				Framerate:     30,
+				InputFormat:   "mjpeg",
+				StreamAddress: "rtsp://localhost:8554/video/feed0",
+			},
+			{
+				Name:          "feed1",
+				Device:        "/dev/video2",
+				Width:         1280,
+				Height:        720,
+				Framerate:     30,
+				InputFormat:   "mjpeg",
+				StreamAddress: "rtsp://localhost:8554/video/feed1",
+			},
+		},
+		Stream: StreamConfig{
+			PublicHost: "localhost",
+			LogDir:     filepath.Join(os.TempDir(), "v-arm-stream-logs"),
+		},
+		Server: ServerConfig{
+			Port:         "8080",
+			WebSocket:    true,
+			TLS:          TLSConfig{PlainConnections: "reject"},
+			WebTransport: WebTransportConfig{Port: "4433"},
+			LeaseTimeout: 10 * time.Second,
+		},
+		Limits: LimitsConfig{
+			MinSpeed: 50,
+			MaxSpeed: 1000,
+			X:        JointLimits{Min: -65, Max: 120},
+			Y:        JointLimits{Min: -180, Max: 5},
+			Z:        JointLimits{Min: -360, Max: 360},
+			V:        JointLimits{Min: -90, Max: 90},
+			W:        JointLimits{Min: -90, Max: 90},
+		},
+		Logging: LoggingConfig{
+			Level:  "info",
+			Format: logging.FORMAT_TEXT,
+		},
+		Shutdown: ShutdownConfig{
+			Timeout:  10 * time.Second,
+			ParkPose: robot.JointsAngles{Y: -90},
+		},
+		Recording: RecordingConfig{
+			Dir: filepath.Join(os.TempDir(), "v-arm-recordings"),
+		},
+		Programs: ProgramsConfig{
+			Dir: defaultDataPath("programs"),
+		},
+		Poses: PosesConfig{
+			File: defaultDataPath("poses.yaml"),
+		},
+		Scripts: ScriptsConfig{
+			Dir:      defaultDataPath("scripts"),
+			Timeout:  5 * time.Minute,
+			MaxSteps: 10_000_000,
+		},
+		Kinematics: KinematicsConfig{
+			Base:     AxisConfig{Direction: 1},
+			Shoulder: AxisConfig{Direction: 1},
+			Elbow:    AxisConfig{Direction: 1},
+			Wrist:    AxisConfig{Direction: 1},
+		},
+	}
+}
+
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read configuration file: %w", err)
+		}
+
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(cfg)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("Cannot parse configuration file %s: %w", path, err)
+		}
+	}
+
+	cfg.applyCameraDefaults()
+	cfg.applyEnvOverrides()
+
+	err := cfg.Validate()
+	if err != nil {
+		var validationErr *ValidationError
+		if errors.As(err, &validationErr) {
+			validationErr.Path = path
+		}
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (cfg *Config) applyCameraDefaults() {
+	for i := range cfg.Cameras {
+		camera := &cfg.Cameras[i]
+		if camera.Name == "" {
+			camera.Name = fmt.Sprintf("feed%d", i)
+		}
+		if camera.Width == 0 && camera.Height == 0 {
+			camera.Width, camera.Height = 1280, 720
+		}
+		if camera.Framerate == 0 {
+			camera.Framerate = 30
+		}
+		if camera.InputFormat == "" {
+			camera.InputFormat = "mjpeg"
+		}
+		if camera.StreamAddress == "" {
+			camera.StreamAddress = fmt.Sprintf("rtsp://localhost:8554/video/%s", camera.Name)
+		}
+	}
+}
+
+func (cfg *Config) applyEnvOverrides() {
+	if value, ok := os.LookupEnv("UART_PORT"); ok {
+		cfg.Serial.Port = value
+	}
+	if value, ok := os.LookupEnv("PORT"); ok {
+		cfg.Server.Port = value
+	}
+	if value, ok := os.LookupEnv("RASPBERRY_ADDRESS"); ok {
+		cfg.Stream.PublicHost = value
+	}
+	for i := range cfg.Cameras {
+		if value, ok := os.LookupEnv(fmt.Sprintf("CAMERA_DEVICE%d_PATH", i)); ok {
+			cfg.Cameras[i].Device = value
+		}
+	}
+}
+
+func (cfg *Config) Validate() error {
+	problems := []string{}
+	addProblem := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if cfg.Serial.Port == "" {
+		addProblem("serial.port must not be empty")
+	}
+	if cfg.Serial.BaudRate <= 0 {
+		addProblem("serial.baud_rate must be positive, got %d", cfg.Serial.BaudRate)
+	}
+	if cfg.Serial.DataBits < 5 || cfg.Serial.DataBits > 8 {
+		addProblem("serial.data_bits must be between 5 and 8, got %d", cfg.Serial.DataBits)
+	}
+	if !slices.Contains(validParities, cfg.Serial.Parity) {
+		addProblem("serial.parity must be one of [%s], got %q", strings.Join(validParities, ", "), cfg.Serial.Parity)
+	}
+	if !slices.Contains(validStopBits, cfg.Serial.StopBits) {
+		addProblem("serial.stop_bits must be one of [%s], got %q", strings.Join(validStopBits, ", "), cfg.Serial.StopBits)
+	}
+
+	names := map[string]bool{}
+	for i, camera := range cfg.Cameras {
+		if camera.Name == "" {
+			addProblem("cameras[%d].name must not be empty", i)
+		} else if names[camera.Name] {
+			addProblem("cameras[%d].name %q is used by another camera", i, camera.Name)
+		}
+		names[camera.Name] = true
+
+		if camera.Device == "" {
+			addProblem("cameras[%d].device must not be empty", i)
+		}
+		if camera.Width <= 0 || camera.Height <= 0 {
+			addProblem("cameras[%d] resolution must be positive, got %dx%d", i, camera.Width, camera.Height)
+		}
+		if camera.Framerate <= 0 {
+			addProblem("cameras[%d].framerate must be positive, got %d", i, camera.Framerate)
+		}
+		if !slices.Contains(validInputFormats, camera.InputFormat) {
+			addProblem("cameras[%d].input_format must be one of [%s], got %q", i, strings.Join(validInputFormats, ", "), camera.InputFormat)
+		}
+		if !strings.HasPrefix(camera.StreamAddress, "rtsp://") {
+			addProblem("cameras[%d].stream_address must be an rtsp:// URL, got %q", i, camera.StreamAddress)
+		}
+	}
+
+	if cfg.Stream.LogDir == "" {
+		addProblem("stream.log_dir must not be empty")
+	}
+
+	if cfg.Server.Port == "" {
+		addProblem("server.port must not be empty")
+	}
+	if !cfg.Server.WebSocket && !cfg.Server.WebTransport.Enabled {
+		addProblem("at least one of server.websocket and server.webtransport.enabled must be set")
+	}
+	if cfg.Server.TLS.Enabled {
+		if cfg.Server.TLS.CertFile == "" || cfg.Server.TLS.KeyFile == "" {
+			addProblem("server.tls.cert_file and key_file are required, set self_signed to have them generated")
+		}
+		if !slices.Contains(validPlainConnections, cfg.Server.TLS.PlainConnections) {
+			addProblem("server.tls.plain_connections must be one of [%s], got %q", strings.Join(validPlainConnections, ", "), cfg.Server.TLS.PlainConnections)
+		}
+	}
+	if cfg.Server.WebTransport.Enabled {
+		if cfg.Server.WebTransport.Port == "" {
+			addProblem("server.webtransport.port must not be empty")
+		}
+		if cfg.Server.WebTransport.CertFile == "" || cfg.Server.WebTransport.KeyFile == "" {
+			addProblem("server.webtransport.cert_file and key_file are required, WebTransport only runs over TLS")
+		}
+	}
+
+	if cfg.Server.LeaseTimeout <= 0 {
+		addProblem("server.lease_timeout must be positive, got %s", cfg.Server.LeaseTimeout)
+	}
+
+	tokenNames := map[string]bool{}
+	for i, token := range cfg.Auth.Tokens {
+		if token.Name == "" {
+			addProblem("auth.tokens[%d].name must not be empty", i)
+		} else if tokenNames[token.Name] {
+			addProblem("auth.tokens[%d].name %q is used by another token", i, token.Name)
+		}
+		tokenNames[token.Name] = true
+
+		if token.Token == "" {
+			addProblem("auth.tokens[%d].token must not be empty", i)
+		}
+		_, err := auth.ParseRole(token.Role)
+		if err != nil {
+			addProblem("auth.tokens[%d].role must be one of [observer, operator, admin], got %q", i, token.Role)
+		}
+	}
+	if cfg.Auth.Enabled && len(cfg.Auth.Tokens) == 0 && cfg.Auth.TokenStore == "" {
+		addProblem("auth.enabled requires auth.tokens or auth.token_store, nobody could connect otherwise")
+	}
+
+	if cfg.Limits.MinSpeed <= 0 {
+		addProblem("limits.min_speed must be positive, got %g", cfg.Limits.MinSpeed)
+	}
+	if cfg.Limits.MaxSpeed < cfg.Limits.MinSpeed {
+		addProblem("limits.max_speed (%g) must not be lower than limits.min_speed (%g)", cfg.Limits.MaxSpeed, cfg.Limits.MinSpeed)
+	}
+	pose := cfg.Shutdown.ParkPose
+	for _, joint := range []struct {
+		name   string
+		limits JointLimits
+		park   float32
+	}{
+		{"x", cfg.Limits.X, pose.X}, {"y", cfg.Limits.Y, pose.Y}, {"z", cfg.Limits.Z, pose.Z}, {"v", cfg.Limits.V, pose.V}, {"w", cfg.Limits.W, pose.W},
+	} {
+		if joint.limits.Max < joint.limits.Min {
+			addProblem("limits.%s.max (%g) must not be lower than limits.%s.min (%g)", joint.name, joint.limits.Max, joint.name, joint.limits.Min)
+		}
+		if cfg.Shutdown.Park && (joint.park < joint.limits.Min || joint.park > joint.limits.Max) {
+			addProblem("shutdown.park_pose.%s (%g) must be within limits.%s [%g, %g]", joint.name, joint.park, joint.name, joint.limits.Min, joint.limits.Max)
+		}
+	}
+
+	if cfg.Recording.Enabled && cfg.Recording.Dir == "" {
+		addProblem("recording.dir must not be empty when recording is enabled")
+	}
+	if cfg.Programs.Dir == "" {
+		addProblem("programs.dir must not be empty")
+	}
+	if cfg.Poses.File == "" {
+		addProblem("poses.file must not be empty")
+	}
+	if cfg.Scripts.Dir == "" {
+		addProblem("scripts.dir must not be empty")
+	}
+	if cfg.Scripts.Timeout <= 0 {
+		addProblem("scripts.timeout must be positive, got %s", cfg.Scripts.Timeout)
+	}
+	if cfg.Scripts.MaxSteps == 0 {
+		addProblem("scripts.max_steps must be positive")
+	}
+	if cfg.Kinematics.Enabled {
+		kinematics := cfg.Kinematics
+		if kinematics.BaseHeight < 0 {
+			addProblem("kinematics.base_height must not be negative, got %g", kinematics.BaseHeight)
+		}
+		if kinematics.Tool < 0 {
+			addProblem("kinematics.tool must not be negative, got %g", kinematics.Tool)
+		}
+		for _, link := range []struct {
+			name   string
+			length float64
+		}{{"upper_arm", kinematics.UpperArm}, {"forearm", kinematics.Forearm}} {
+			if link.length <= 0 {
+				addProblem("kinematics.%s must be positive, got %g", link.name, link.length)
+			}
+		}
+		for _, axis := range []struct {
+			name string
+			axis AxisConfig
+		}{{"base", kinematics.Base}, {"shoulder", kinematics.Shoulder}, {"elbow", kinematics.Elbow}, {"wrist", kinematics.Wrist}} {
+			if axis.axis.Direction != 1 && axis.axis.Direction != -1 {
+				addProblem("kinematics.%s.direction must be 1 or -1, got %g", axis.name, axis.axis.Direction)
+			}
+		}
+	}
+
+	if cfg.Shutdown.Timeout <= 0 {
+		addProblem("shutdown.timeout must be positive, got %s", cfg.Shutdown.Timeout)
+	}
+
+	_, err := logging.ParseLevel(cfg.Logging.Level)
+	if err != nil {
+		addProblem("logging.level must be one of [debug, info, warn, error], got %q", cfg.Logging.Level)
+	}
+	if !slices.Contains(validLogFormats, cfg.Logging.Format) {
+		addProblem("logging.format must be one of [%s], got %q", strings.Join(validLogFormats, ", "), cfg.Logging.Format)
+	}
+	for pkg, level := range cfg.Logging.Packages {
+		if !slices.Contains(logging.PACKAGES, pkg) {
+			addProblem("logging.packages has unknown package %q, expected one of [%s]", pkg, strings.Join(logging.PACKAGES, ", "))
+		}
+		_, err := logging.ParseLevel(level)
+		if err != nil {
+			addProblem("logging.packages.%s must be one of [debug, info, warn, error], got %q", pkg, level)
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+func (s *SerialConfig) parity() serial.Parity {
+	switch s.Parity {
+	case "odd":
+		return serial.OddParity
+	case "even":
+		return serial.EvenParity
+	case "mark":
+		return serial.MarkParity
+	case "space":
+		return serial.SpaceParity
+	default:
+		return serial.NoParity
+	}
+}
+
+func (s *SerialConfig) stopBits() serial.StopBits {
+	switch s.StopBits {
+	case "1.5":
+		return serial.OnePointFiveStopBits
+	case "2":
+		return serial.TwoStopBits
+	default:
+		return serial.OneStopBit
+	}
+}
+
+func (s *SerialConfig) UartConfig() robot.UartConfig {
+	return robot.UartConfig{
+		PortName: s.Port,
+		Parity:   s.parity(),
+		StopBits: s.stopBits(),
+		BaudRate: s.BaudRate,
+		DataBits: s.DataBits,
+	}
+}
+
+func (l *LimitsConfig) RobotLimits() robot.Limits {
+	return robot.Limits{
+		MinSpeed: l.MinSpeed,
+		MaxSpeed: l.MaxSpeed,
+		X:        robot.JointLimits(l.X),
+		Y:        robot.JointLimits(l.Y),
+		Z:        robot.JointLimits(l.Z),
+		V:        robot.JointLimits(l.V),
+		W:        robot.JointLimits(l.W),
+	}
+}
+
+func (s *ScriptsConfig) Limits() script.Limits {
+	return script.Limits{Timeout: s.Timeout, MaxSteps: s.MaxSteps}
+}
+
+// Geometry is nil when kinematics are disabled.
+func (k *KinematicsConfig) Geometry() *kinematics.Geometry {
+	if !k.Enabled {
+		return nil
+	}
+	return &kinematics.Geometry{
+		BaseHeight: k.BaseHeight,
+		UpperArm:   k.UpperArm,
+		Forearm:    k.Forearm,
+		Tool:       k.Tool,
+		Base:       kinematics.Axis(k.Base),
+		Shoulder:   kinematics.Axis(k.Shoulder),
+		Elbow:      kinematics.Axis(k.Elbow),
+		Wrist:      kinematics.Axis(k.Wrist),
+	}
+}
+
+// Directory is where sessions are recorded, empty when recording is off.
+func (r *RecordingConfig) Directory() string {
+	if !r.Enabled {
+		return ""
+	}
+	return r.Dir
+}
+
+func (a *AuthConfig) Principals() map[string]auth.Principal {
+	principals := map[string]auth.Principal{}
+	for _, token := range a.Tokens {
+		role, _ := auth.ParseRole(token.Role)
+		principals[token.Token] = auth.Principal{Name: token.Name, Role: role}
+	}
+	return principals
+}
@@ -0,0 +1,110 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, data string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Serial.BaudRate != 115200 || cfg.Serial.Parity != "even" {
+		t.Errorf("serial defaults: got %+v", cfg.Serial)
+	}
+}
+
+func TestLoadExample(t *testing.T) {
+	cfg, err := Load("../config.example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Cameras) == 0 || cfg.Cameras[0].Name != "feed0" {
+		t.Errorf("cameras: got %+v", cfg.Cameras)
+	}
+}
+
+func TestLoadAppliesCameraDefaultsAndEnvironment(t *testing.T) {
+	t.Setenv("PORT", "9090")
+	t.Setenv("CAMERA_DEVICE0_PATH", "/dev/video7")
+	cfg, err := Load(writeConfig(t, "cameras:\n  - device: /dev/video0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Port != "9090" {
+		t.Errorf("server.port = %q, want the PORT variable", cfg.Server.Port)
+	}
+	camera := cfg.Cameras[0]
+	if camera.Name != "feed0" || camera.Device != "/dev/video7" || camera.Width != 1280 || camera.Height != 720 || camera.Framerate != 30 {
+		t.Errorf("camera: got %+v", camera)
+	}
+	if camera.StreamAddress != "rtsp://localhost:8554/video/feed0" {
+		t.Errorf("camera.stream_address = %q", camera.StreamAddress)
+	}
+}
+
+func TestLoadRefusesUnknownFields(t *testing.T) {
+	_, err := Load(writeConfig(t, "serial:\n  baud: 9600\n"))
+	if err == nil || !strings.Contains(err.Error(), "field baud not found") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	path := writeConfig(t, strings.Join([]string{
+		"serial:",
+		"  parity: sometimes",
+		"cameras:",
+		"  - name: left",
+		"    device: /dev/video0",
+		"  - name: left",
+		"limits:",
+		"  min_speed: 10",
+		"  max_speed: 5",
+		"logging:",
+		"  level: loud",
+	}, "\n"))
+	_, err := Load(path)
+	var validation *ValidationError
+	if !errors.As(err, &validation) {
+		t.Fatalf("got %v, want a ValidationError", err)
+	}
+	if validation.Path != path {
+		t.Errorf("path = %q, want %q", validation.Path, path)
+	}
+	for _, want := range []string{
+		`serial.parity must be one of [none, odd, even, mark, space], got "sometimes"`,
+		`cameras[1].name "left" is used by another camera`,
+		"cameras[1].device must not be empty",
+		"limits.max_speed (5) must not be lower than limits.min_speed (10)",
+		`logging.level must be one of [debug, info, warn, error], got "loud"`,
+	} {
+		if !slices.Contains(validation.Problems, want) {
+			t.Errorf("missing problem %q in %q", want, validation.Problems)
+		}
+	}
+}
+
+func TestValidateParkPoseWithinLimits(t *testing.T) {
+	cfg := Default()
+	cfg.Shutdown.Park = true
+	cfg.Shutdown.ParkPose.V = cfg.Limits.V.Max + 1
+	err := cfg.Validate()
+	var validation *ValidationError
+	if !errors.As(err, &validation) || len(validation.Problems) != 1 || !strings.HasPrefix(validation.Problems[0], "shutdown.park_pose.v") {
+		t.Errorf("got %v", err)
+	}
+}
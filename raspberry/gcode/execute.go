@@ -0,0 +1,194 @@
+package gcode
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/xTaube/vr-controlled-robot-arm/kinematics"
+	"github.com/xTaube/vr-controlled-robot-arm/robot"
+)
+
+type KinematicsMissingError struct{}
+
+func (err *KinematicsMissingError) Error() string {
+	return "Cartesian moves need the arm's geometry, configure kinematics or use joint moves (G53)."
+}
+
+// CheckKinematics finds Cartesian moves a nil geometry cannot run.
+func (p *Program) CheckKinematics(geometry *kinematics.Geometry) error {
+	if geometry != nil {
+		return nil
+	}
+	for _, block := range p.Blocks {
+		if block.Space == SPACE_CARTESIAN && block.Motion != MOTION_NONE {
+			return &LineError{block.Line, block.Text, &KinematicsMissingError{}}
+		}
+	}
+	return nil
+}
+
+// G1 in Cartesian space is split into steps no longer than these, in
+// millimetres and degrees of pitch, so the tool tip keeps close to the line.
+const (
+	CARTESIAN_STEP_LENGTH = 5.0
+	CARTESIAN_STEP_PITCH  = 5.0
+)
+
+// Executor runs the blocks of a program in order, each move waits for the arm
+// to stop. G0 and G1 in joint space are joint-interpolated, as is G0 in
+// Cartesian space, G1 in Cartesian space is a linear move of the tool tip made
+// of short joint moves. G0 runs at the highest speed allowed and G1 at the
+// feed rate, which is passed to SetSpeed as it is.
+type Executor struct {
+	robot    *robot.Robot
+	geometry *kinematics.Geometry
+	// Target of the last move, read from the arm before the first block.
+	position *robot.JointsAngles
+	feed     float32
+	// Last speed set, zero until a move set one.
+	speed float32
+}
+
+// Execute returns the joints the arm stands at after block.
+func (e *Executor) Execute(ctx context.Context, block *Block) (*robot.JointsAngles, error) {
+	joints, err := e.execute(ctx, block)
+	if err != nil {
+		return nil, &LineError{block.Line, block.Text, err}
+	}
+	return joints, nil
+}
+
+func (e *Executor) execute(ctx context.Context, block *Block) (*robot.JointsAngles, error) {
+	if e.position == nil {
+		position, err := e.robot.GetCurrentPosition()
+		if err != nil {
+			return nil, err
+		}
+		e.position = position
+	}
+
+	if block.Feed != 0 {
+		e.feed = block.Feed
+	}
+	err := e.setGripper(block.Gripper)
+	if err != nil {
+		return nil, err
+	}
+	if block.Dwell > 0 {
+		err = dwell(ctx, block.Dwell)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if block.Motion == MOTION_NONE {
+		joints := *e.position
+		return &joints, nil
+	}
+
+	path, err := e.path(block)
+	if err != nil {
+		return nil, err
+	}
+	speed := e.feed
+	if block.Motion == MOTION_RAPID {
+		speed = e.robot.Limits().MaxSpeed
+	}
+	if speed != e.speed {
+		err = e.robot.SetSpeed(speed)
+		if err != nil {
+			return nil, err
+		}
+		e.speed = speed
+	}
+	for _, target := range path {
+		err = e.robot.MoveAndWait(ctx, target)
+		if err != nil {
+			return nil, err
+		}
+		e.position = &target
+	}
+	joints := *e.position
+	return &joints, nil
+}
+
+func (e *Executor) setGripper(state robot.GripperState) error {
+	switch state {
+	case robot.GRIPPER_OPEN:
+		return e.robot.OpenGripper()
+	case robot.GRIPPER_CLOSED:
+		return e.robot.CloseGripper()
+	default:
+		return nil
+	}
+}
+
+func dwell(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// path returns the joints the arm passes through for a move, every one is
+// solved before the arm moves so a line leaving the arm's reach is refused
+// whole. Axes missing from the block keep their value from the previous move.
+func (e *Executor) path(block *Block) ([]robot.JointsAngles, error) {
+	current := *e.position
+	if block.Space == SPACE_JOINT {
+		joints := current
+		angles := map[byte]*float32{'Z': &joints.Z, 'Y': &joints.Y, 'X': &joints.X, 'V': &joints.V, 'W': &joints.W}
+		for letter, value := range block.Axes {
+			if block.Relative {
+				*angles[letter] += float32(value)
+			} else {
+				*angles[letter] = float32(value)
+			}
+		}
+		return []robot.JointsAngles{joints}, nil
+	}
+
+	if e.geometry == nil {
+		return nil, &KinematicsMissingError{}
+	}
+	from := e.geometry.Forward(current)
+	to := from
+	coordinates := map[byte]*float64{'X': &to.X, 'Y': &to.Y, 'Z': &to.Z, 'A': &to.Pitch}
+	for letter, value := range block.Axes {
+		if block.Relative {
+			*coordinates[letter] += value
+		} else {
+			*coordinates[letter] = value
+		}
+	}
+
+	steps := 1
+	if block.Motion == MOTION_FEED {
+		length := math.Sqrt((to.X-from.X)*(to.X-from.X) + (to.Y-from.Y)*(to.Y-from.Y) + (to.Z-from.Z)*(to.Z-from.Z))
+		steps = max(steps, int(math.Ceil(length/CARTESIAN_STEP_LENGTH)), int(math.Ceil(math.Abs(to.Pitch-from.Pitch)/CARTESIAN_STEP_PITCH)))
+	}
+	path := make([]robot.JointsAngles, 0, steps)
+	for step := 1; step <= steps; step++ {
+		progress := float64(step) / float64(steps)
+		joints, err := e.geometry.Inverse(kinematics.Position{
+			X:     from.X + (to.X-from.X)*progress,
+			Y:     from.Y + (to.Y-from.Y)*progress,
+			Z:     from.Z + (to.Z-from.Z)*progress,
+			Pitch: from.Pitch + (to.Pitch-from.Pitch)*progress,
+		}, current.W)
+		if err != nil {
+			return nil, err
+		}
+		path = append(path, joints)
+	}
+	return path, nil
+}
+
+// A nil geometry limits the executor to joint moves.
+func InitExecutor(robot *robot.Robot, geometry *kinematics.Geometry) *Executor {
+	return &Executor{robot: robot, geometry: geometry}
+}
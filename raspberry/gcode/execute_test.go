@@ -0,0 +1,92 @@
+package gcode
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/xTaube/vr-controlled-robot-arm/kinematics"
+	"github.com/xTaube/vr-controlled-robot-arm/robot"
+)
+
+var testGeometry = &kinematics.Geometry{
+	BaseHeight: 100,
+	UpperArm:   200,
+	Forearm:    150,
+	Tool:       50,
+	Base:       kinematics.Axis{Direction: 1},
+	Shoulder:   kinematics.Axis{Zero: -90, Direction: 1},
+	Elbow:      kinematics.Axis{Direction: -1},
+	Wrist:      kinematics.Axis{Direction: 1},
+}
+
+// The path of the only move of source, starting from joints.
+func testPath(t *testing.T, source string, joints robot.JointsAngles) ([]robot.JointsAngles, error) {
+	t.Helper()
+	program, err := Parse("path", source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	executor := InitExecutor(nil, testGeometry)
+	executor.position = &joints
+	return executor.path(program.Blocks[len(program.Blocks)-1])
+}
+
+func TestCartesianFeedMovesFollowTheLine(t *testing.T) {
+	start := robot.JointsAngles{Z: 0, Y: -45, X: 90, V: -45, W: 20}
+	from := testGeometry.Forward(start)
+	path, err := testPath(t, "G54 G91 G1 F100 X-60 Y80 Z-20 A10", start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 101.98 mm in steps of at most 5 mm.
+	if len(path) != 21 {
+		t.Fatalf("got %d steps, want 21", len(path))
+	}
+
+	direction := kinematics.Position{X: -60, Y: 80, Z: -20}
+	length := math.Sqrt(direction.X*direction.X + direction.Y*direction.Y + direction.Z*direction.Z)
+	previous := from
+	for i, joints := range path {
+		if joints.W != start.W {
+			t.Errorf("step %d: roll changed to %g", i, joints.W)
+		}
+		tip := testGeometry.Forward(joints)
+		step := math.Sqrt((tip.X-previous.X)*(tip.X-previous.X) + (tip.Y-previous.Y)*(tip.Y-previous.Y) + (tip.Z-previous.Z)*(tip.Z-previous.Z))
+		if step > CARTESIAN_STEP_LENGTH+1e-3 {
+			t.Errorf("step %d is %.3f mm long", i, step)
+		}
+		// Distance of the tip from the line, through the cross product with its direction.
+		offset := kinematics.Position{X: tip.X - from.X, Y: tip.Y - from.Y, Z: tip.Z - from.Z}
+		cross := math.Sqrt(math.Pow(offset.Y*direction.Z-offset.Z*direction.Y, 2) + math.Pow(offset.Z*direction.X-offset.X*direction.Z, 2) + math.Pow(offset.X*direction.Y-offset.Y*direction.X, 2))
+		if cross/length > 1e-3 {
+			t.Errorf("step %d is %.4f mm off the line", i, cross/length)
+		}
+		previous = tip
+	}
+	end := testGeometry.Forward(path[len(path)-1])
+	if math.Abs(end.X-from.X+60) > 1e-3 || math.Abs(end.Y-from.Y-80) > 1e-3 || math.Abs(end.Z-from.Z+20) > 1e-3 || math.Abs(end.Pitch-from.Pitch-10) > 1e-3 {
+		t.Errorf("ends at %+v, started at %+v", end, from)
+	}
+}
+
+func TestCartesianRapidAndJointMovesTakeOneStep(t *testing.T) {
+	start := robot.JointsAngles{Y: -45, X: 90, V: -45}
+	for _, source := range []string{"G54 G91 G0 X-60 Y80", "G53 G1 F100 Z90 Y-10"} {
+		path, err := testPath(t, source, start)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(path) != 1 {
+			t.Errorf("%s: got %d steps, want 1", source, len(path))
+		}
+	}
+}
+
+func TestCartesianMovesOutOfReachAreRefusedWhole(t *testing.T) {
+	path, err := testPath(t, "G54 G1 F100 X450 Y0 Z100", robot.JointsAngles{Y: -45, X: 90, V: -45})
+	var unreachable *kinematics.UnreachableError
+	if !errors.As(err, &unreachable) || path != nil {
+		t.Errorf("got %d steps and %v, want an UnreachableError", len(path), err)
+	}
+}
@@ -0,0 +1,364 @@
+package gcode
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xTaube/vr-controlled-robot-arm/robot"
+	"github.com/xTaube/vr-controlled-robot-arm/storage"
+)
+
+const kind = "G-code program"
+
+const FILE_EXTENSION = ".gcode"
+
+// Also the longest string a binary frame can carry.
+const MAX_SOURCE_SIZE = 1<<16 - 1
+
+type Space byte
+
+const (
+	// Axis words are the arm's joint angles in degrees, selected with G53.
+	SPACE_JOINT Space = iota
+	// Axis words are millimetres and the tool pitch in degrees, selected with G54.
+	SPACE_CARTESIAN
+)
+
+type Motion byte
+
+const (
+	MOTION_NONE Motion = iota
+	MOTION_RAPID
+	// G1 moves the joints at the feed rate, the tool does not follow a line.
+	MOTION_FEED
+)
+
+var SPACE_AXES = map[Space]string{
+	SPACE_JOINT:     "ZYXVW",
+	SPACE_CARTESIAN: "XYZA",
+}
+
+type SourceTooLargeError struct {
+	size int
+}
+
+func (err *SourceTooLargeError) Error() string {
+	return fmt.Sprintf("G-code program is %d bytes long, the limit is %d.", err.size, MAX_SOURCE_SIZE)
+}
+
+// LineError cites the line of the program that could not be parsed or executed.
+type LineError struct {
+	Line int
+	Text string
+	Err  error
+}
+
+func (err *LineError) Error() string {
+	return fmt.Sprintf("Line %d %q: %s", err.Line, err.Text, err.Err)
+}
+
+func (err *LineError) Unwrap() error {
+	return err.Err
+}
+
+// Block is one line with something to do, modal state already resolved.
+// A block runs in the order a CNC controller uses: feed, gripper, dwell, move.
+type Block struct {
+	Line     int
+	Text     string
+	Space    Space
+	Relative bool
+	Motion   Motion
+	Axes     map[byte]float64
+	// Zero keeps the feed of the previous blocks.
+	Feed    float32
+	Gripper robot.GripperState
+	Dwell   time.Duration
+}
+
+type Program struct {
+	Name   string
+	Source string
+	Blocks []*Block
+}
+
+type word struct {
+	letter byte
+	value  float64
+}
+
+// Comments run from ';' to the end of the line or between parentheses.
+func stripComments(line string) (string, error) {
+	line, _, _ = strings.Cut(line, ";")
+	var code strings.Builder
+	for {
+		before, after, found := strings.Cut(line, "(")
+		code.WriteString(before)
+		if !found {
+			return code.String(), nil
+		}
+		_, rest, closed := strings.Cut(after, ")")
+		if !closed {
+			return "", errors.New("comment is not closed with ')'")
+		}
+		line = rest
+	}
+}
+
+func splitWords(line string) ([]word, error) {
+	words := []word{}
+	line = strings.ToUpper(line)
+	for i := 0; i < len(line); {
+		if line[i] == ' ' || line[i] == '\t' {
+			i++
+			continue
+		}
+		letter := line[i]
+		if letter < 'A' || letter > 'Z' {
+			return nil, fmt.Errorf("expected a letter, got %q", line[i])
+		}
+		start := i + 1
+		end := start
+		for end < len(line) && strings.IndexByte("+-.0123456789", line[end]) >= 0 {
+			end++
+		}
+		value, err := strconv.ParseFloat(line[start:end], 64)
+		if err != nil || math.IsInf(value, 0) {
+			return nil, fmt.Errorf("%c needs a number", letter)
+		}
+		words = append(words, word{letter, value})
+		i = end
+	}
+	return words, nil
+}
+
+func integerCode(value float64) (int, bool) {
+	if value != math.Trunc(value) || value < 0 {
+		return 0, false
+	}
+	return int(value), true
+}
+
+// modal is what carries over from one line to the next.
+type modal struct {
+	space    Space
+	relative bool
+	motion   Motion
+	feed     bool
+}
+
+// parseBlock returns a nil block for lines with nothing to do, end is set by M2 and M30.
+func (m *modal) parseBlock(words []word) (block *Block, end bool, err error) {
+	block = &Block{Axes: map[byte]float64{}}
+	var motion, space, distance, gripper string
+	dwell := false
+	var dwellWords []word
+	axisWords := []word{}
+
+	for _, w := range words {
+		switch w.letter {
+		case 'N':
+		case 'G':
+			number, ok := integerCode(w.value)
+			if !ok {
+				return nil, false, fmt.Errorf("G%g is not supported", w.value)
+			}
+			group := &motion
+			switch number {
+			case 0:
+				block.Motion = MOTION_RAPID
+			case 1:
+				block.Motion = MOTION_FEED
+			case 4:
+				dwell = true
+			case 21:
+				continue
+			case 53:
+				group, block.Space = &space, SPACE_JOINT
+			case 54:
+				group, block.Space = &space, SPACE_CARTESIAN
+			case 90:
+				group, block.Relative = &distance, false
+			case 91:
+				group, block.Relative = &distance, true
+			default:
+				return nil, false, fmt.Errorf("G%d is not supported", number)
+			}
+			current := fmt.Sprintf("G%d", number)
+			if *group != "" {
+				return nil, false, fmt.Errorf("%s and %s cannot share a line", *group, current)
+			}
+			*group = current
+		case 'M':
+			number, ok := integerCode(w.value)
+			if !ok {
+				return nil, false, fmt.Errorf("M%g is not supported", w.value)
+			}
+			switch number {
+			case 2, 30:
+				end = true
+				continue
+			case 3:
+				block.Gripper = robot.GRIPPER_CLOSED
+			case 5:
+				block.Gripper = robot.GRIPPER_OPEN
+			default:
+				return nil, false, fmt.Errorf("M%d is not supported", number)
+			}
+			if gripper != "" {
+				return nil, false, fmt.Errorf("%s and M%d cannot share a line", gripper, number)
+			}
+			gripper = fmt.Sprintf("M%d", number)
+		case 'F':
+			if w.value <= 0 {
+				return nil, false, fmt.Errorf("F must be positive, got %g", w.value)
+			}
+			block.Feed = float32(w.value)
+		case 'P', 'S':
+			dwellWords = append(dwellWords, w)
+		default:
+			if strings.IndexByte("XYZVWA", w.letter) < 0 {
+				return nil, false, fmt.Errorf("%c is not supported", w.letter)
+			}
+			axisWords = append(axisWords, w)
+		}
+	}
+
+	if space != "" {
+		m.space = block.Space
+	}
+	if distance != "" {
+		m.relative = block.Relative
+	}
+	if block.Feed != 0 {
+		m.feed = true
+	}
+	block.Space, block.Relative = m.space, m.relative
+
+	if dwell {
+		if len(dwellWords) != 1 || len(axisWords) > 0 {
+			return nil, false, errors.New("G4 takes exactly one of P (milliseconds) or S (seconds)")
+		}
+		unit := time.Millisecond
+		if dwellWords[0].letter == 'S' {
+			unit = time.Second
+		}
+		if dwellWords[0].value < 0 {
+			return nil, false, fmt.Errorf("G4 needs a positive time, got %c%g", dwellWords[0].letter, dwellWords[0].value)
+		}
+		block.Dwell = time.Duration(dwellWords[0].value * float64(unit))
+	} else if len(dwellWords) > 0 {
+		return nil, false, fmt.Errorf("%c is only used with G4", dwellWords[0].letter)
+	}
+
+	// G0 and G1 stay in effect, so later lines may hold axis words alone.
+	if block.Motion != MOTION_NONE {
+		m.motion = block.Motion
+	}
+	block.Motion = MOTION_NONE
+	if len(axisWords) > 0 {
+		block.Motion = m.motion
+	}
+	if len(axisWords) > 0 && block.Motion == MOTION_NONE {
+		return nil, false, errors.New("axis words need G0 or G1 first")
+	}
+	for _, w := range axisWords {
+		if strings.IndexByte(SPACE_AXES[block.Space], w.letter) < 0 {
+			if block.Space == SPACE_JOINT {
+				return nil, false, fmt.Errorf("%c is not a joint, use Z, Y, X, V and W or switch to Cartesian moves with G54", w.letter)
+			}
+			return nil, false, fmt.Errorf("%c is not a Cartesian axis, use X, Y, Z and A or switch to joint moves with G53", w.letter)
+		}
+		if _, ok := block.Axes[w.letter]; ok {
+			return nil, false, fmt.Errorf("%c appears twice", w.letter)
+		}
+		block.Axes[w.letter] = w.value
+	}
+	if block.Motion == MOTION_FEED && !m.feed {
+		return nil, false, errors.New("G1 needs a feed rate, set F first")
+	}
+
+	if block.Motion == MOTION_NONE && !dwell && block.Feed == 0 && block.Gripper == robot.GRIPPER_UNKNOWN {
+		return nil, end, nil
+	}
+	return block, end, nil
+}
+
+// Parse checks the whole program before anything runs, lines after M2 or M30 are ignored.
+func Parse(name string, source string) (*Program, error) {
+	if len(source) > MAX_SOURCE_SIZE {
+		return nil, &SourceTooLargeError{len(source)}
+	}
+	program := &Program{Name: name, Source: source}
+	state := &modal{}
+	for i, text := range strings.Split(source, "\n") {
+		text = strings.TrimSpace(text)
+		line, err := stripComments(text)
+		var words []word
+		if err == nil {
+			line = strings.TrimSpace(line)
+			if line == "" || line == "%" {
+				continue
+			}
+			words, err = splitWords(line)
+		}
+		var block *Block
+		end := false
+		if err == nil {
+			block, end, err = state.parseBlock(words)
+		}
+		if err != nil {
+			return nil, &LineError{i + 1, text, err}
+		}
+		if block != nil {
+			block.Line, block.Text = i+1, text
+			program.Blocks = append(program.Blocks, block)
+		}
+		if end {
+			break
+		}
+	}
+	return program, nil
+}
+
+// Store keeps one file per G-code program in a directory, uploading a name again replaces the program.
+type Store struct {
+	dir string
+}
+
+func (s *Store) path(name string) string {
+	return filepath.Join(s.dir, name+FILE_EXTENSION)
+}
+
+func (s *Store) Save(program *Program) error {
+	err := storage.ValidateName(kind, program.Name)
+	if err != nil {
+		return err
+	}
+	return storage.WriteFile(s.path(program.Name), []byte(program.Source), 0644)
+}
+
+func (s *Store) Load(name string) (*Program, error) {
+	err := storage.ValidateName(kind, name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(s.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, &storage.NotFoundError{Kind: kind, Name: name}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read G-code program %q: %w", name, err)
+	}
+	return Parse(name, string(data))
+}
+
+func InitStore(dir string) *Store {
+	return &Store{dir: dir}
+}
@@ -0,0 +1,91 @@
+package gcode
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xTaube/vr-controlled-robot-arm/robot"
+)
+
+func TestParse(t *testing.T) {
+	source := strings.Join([]string{
+		"%",
+		"G21 G53 G90        (joint moves, absolute)",
+		"G0 Z0 Y-90 X30     ; rapid move",
+		"G1 F300 Z45",
+		"Y-45",
+		"M3",
+		"G4 P500",
+		"G91 G54 G0 X10 A-5",
+		"M30",
+		"G0 Z90             ; after the end, ignored",
+	}, "\n")
+
+	program, err := Parse("demo", source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(program.Blocks) != 6 {
+		t.Fatalf("got %d blocks, want 6", len(program.Blocks))
+	}
+
+	feed := program.Blocks[1]
+	if feed.Line != 4 || feed.Motion != MOTION_FEED || feed.Feed != 300 || feed.Axes['Z'] != 45 {
+		t.Errorf("line 4 parsed as %+v", feed)
+	}
+	// G1 stays in effect for axis words alone.
+	if modal := program.Blocks[2]; modal.Line != 5 || modal.Motion != MOTION_FEED || modal.Axes['Y'] != -45 {
+		t.Errorf("line 5 parsed as %+v", modal)
+	}
+	if gripper := program.Blocks[3]; gripper.Gripper != robot.GRIPPER_CLOSED {
+		t.Errorf("line 6 parsed as %+v", gripper)
+	}
+	if dwell := program.Blocks[4]; dwell.Dwell != 500*time.Millisecond {
+		t.Errorf("line 7 parsed as %+v", dwell)
+	}
+	cartesian := program.Blocks[5]
+	if cartesian.Space != SPACE_CARTESIAN || !cartesian.Relative || cartesian.Motion != MOTION_RAPID || cartesian.Axes['A'] != -5 {
+		t.Errorf("line 8 parsed as %+v", cartesian)
+	}
+}
+
+func TestParseErrorsCiteTheLine(t *testing.T) {
+	for _, test := range []struct {
+		source string
+		line   int
+		err    string
+	}{
+		{"G0 X1\nG2 X1", 2, "G2 is not supported"},
+		{"G0 X1\n\nG1 Z5", 3, "G1 needs a feed rate, set F first"},
+		{"X10", 1, "axis words need G0 or G1 first"},
+		{"G0 X1 (open comment", 1, "comment is not closed with ')'"},
+		{"G0 X1 X2", 1, "X appears twice"},
+		{"G0 G1 X1", 1, "G0 and G1 cannot share a line"},
+		{"G0\nG54\nG0 V10", 3, "V is not a Cartesian axis, use X, Y, Z and A or switch to joint moves with G53"},
+		{"G4 P100 S1", 1, "G4 takes exactly one of P (milliseconds) or S (seconds)"},
+		{"G0 X1\nM7", 2, "M7 is not supported"},
+		{"G0 X1 #", 1, "expected a letter, got '#'"},
+		{"G0\nX", 2, "X needs a number"},
+		{"F-10", 1, "F must be positive, got -10"},
+	} {
+		_, err := Parse("broken", test.source)
+		var lineError *LineError
+		if !errors.As(err, &lineError) {
+			t.Errorf("%q: got %v, want a LineError", test.source, err)
+			continue
+		}
+		if lineError.Line != test.line || lineError.Err.Error() != test.err {
+			t.Errorf("%q: got line %d %q, want line %d %q", test.source, lineError.Line, lineError.Err, test.line, test.err)
+		}
+	}
+}
+
+func TestParseRefusesLargeSources(t *testing.T) {
+	_, err := Parse("large", strings.Repeat("G4 P1\n", MAX_SOURCE_SIZE/6+1))
+	var tooLarge *SourceTooLargeError
+	if !errors.As(err, &tooLarge) {
+		t.Fatalf("got %v, want a SourceTooLargeError", err)
+	}
+}
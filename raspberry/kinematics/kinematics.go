@@ -0,0 +1,106 @@
+package kinematics
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/xTaube/vr-controlled-robot-arm/robot"
+)
+
+// Axis maps a joint of the model onto the arm, the arm angle is Zero + Direction * model angle.
+type Axis struct {
+	Zero      float64
+	Direction float64
+}
+
+func (a Axis) toArm(model float64) float32 {
+	return float32(a.Zero + a.Direction*degrees(model))
+}
+
+func (a Axis) toModel(arm float32) float64 {
+	return radians((float64(arm) - a.Zero) / a.Direction)
+}
+
+// Geometry models the arm as a base turning around the vertical axis (Z) that
+// carries a planar chain of upper arm (Y), forearm (X) and tool (V), the
+// wrist roll (W) does not move the tool tip. In the model the upper arm is
+// at 0 when horizontal, the forearm and the tool when in line with the
+// previous link, and angles grow upwards. Lengths are in millimetres.
+type Geometry struct {
+	BaseHeight float64
+	UpperArm   float64
+	Forearm    float64
+	Tool       float64
+
+	Base     Axis
+	Shoulder Axis
+	Elbow    Axis
+	Wrist    Axis
+}
+
+// Position is where the tool tip is, in millimetres from the foot of the
+// base, and the tool's pitch in degrees above the horizontal.
+type Position struct {
+	X     float64
+	Y     float64
+	Z     float64
+	Pitch float64
+}
+
+type UnreachableError struct {
+	position Position
+}
+
+func (err *UnreachableError) Error() string {
+	return fmt.Sprintf("X%.1f Y%.1f Z%.1f with pitch %.1f is out of the arm's reach.", err.position.X, err.position.Y, err.position.Z, err.position.Pitch)
+}
+
+func radians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
+
+func degrees(radians float64) float64 {
+	return radians * 180 / math.Pi
+}
+
+func (g *Geometry) Forward(joints robot.JointsAngles) Position {
+	base := g.Base.toModel(joints.Z)
+	shoulder := g.Shoulder.toModel(joints.Y)
+	elbow := shoulder + g.Elbow.toModel(joints.X)
+	tool := elbow + g.Wrist.toModel(joints.V)
+
+	reach := g.UpperArm*math.Cos(shoulder) + g.Forearm*math.Cos(elbow) + g.Tool*math.Cos(tool)
+	height := g.BaseHeight + g.UpperArm*math.Sin(shoulder) + g.Forearm*math.Sin(elbow) + g.Tool*math.Sin(tool)
+	return Position{
+		X:     reach * math.Cos(base),
+		Y:     reach * math.Sin(base),
+		Z:     height,
+		Pitch: degrees(tool),
+	}
+}
+
+// Inverse returns the joints placing the tool tip at position with the elbow
+// above the line from shoulder to wrist, roll is passed through as W.
+// Joint limits are left to the robot.
+func (g *Geometry) Inverse(position Position, roll float32) (robot.JointsAngles, error) {
+	pitch := radians(position.Pitch)
+	base := math.Atan2(position.Y, position.X)
+	reach := math.Hypot(position.X, position.Y) - g.Tool*math.Cos(pitch)
+	height := position.Z - g.BaseHeight - g.Tool*math.Sin(pitch)
+
+	cosine := (reach*reach + height*height - g.UpperArm*g.UpperArm - g.Forearm*g.Forearm) / (2 * g.UpperArm * g.Forearm)
+	if cosine < -1 || cosine > 1 {
+		return robot.JointsAngles{}, &UnreachableError{position}
+	}
+	elbow := -math.Acos(cosine)
+	shoulder := math.Atan2(height, reach) - math.Atan2(g.Forearm*math.Sin(elbow), g.UpperArm+g.Forearm*math.Cos(elbow))
+	wrist := pitch - shoulder - elbow
+
+	return robot.JointsAngles{
+		Z: g.Base.toArm(base),
+		Y: g.Shoulder.toArm(shoulder),
+		X: g.Elbow.toArm(elbow),
+		V: g.Wrist.toArm(wrist),
+		W: roll,
+	}, nil
+}
@@ -0,0 +1,68 @@
+package kinematics
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/xTaube/vr-controlled-robot-arm/robot"
+)
+
+var testGeometry = Geometry{
+	BaseHeight: 100,
+	UpperArm:   200,
+	Forearm:    150,
+	Tool:       50,
+	Base:       Axis{Direction: 1},
+	Shoulder:   Axis{Zero: -90, Direction: 1},
+	Elbow:      Axis{Direction: -1},
+	Wrist:      Axis{Direction: 1},
+}
+
+func near(a float64, b float64) bool {
+	return math.Abs(a-b) < 1e-3
+}
+
+func TestForward(t *testing.T) {
+	for _, test := range []struct {
+		joints robot.JointsAngles
+		want   Position
+	}{
+		// Shoulder at its zero, every link in line and horizontal.
+		{robot.JointsAngles{Y: -90}, Position{X: 400, Z: 100}},
+		{robot.JointsAngles{Z: 90, Y: -90}, Position{Y: 400, Z: 100}},
+		// Upper arm upright, forearm and tool bent down to horizontal.
+		{robot.JointsAngles{Y: 0, X: 90}, Position{X: 200, Z: 300}},
+	} {
+		got := testGeometry.Forward(test.joints)
+		if !near(got.X, test.want.X) || !near(got.Y, test.want.Y) || !near(got.Z, test.want.Z) || !near(got.Pitch, test.want.Pitch) {
+			t.Errorf("%+v: got %+v, want %+v", test.joints, got, test.want)
+		}
+	}
+}
+
+func TestInverseUndoesForward(t *testing.T) {
+	for _, joints := range []robot.JointsAngles{
+		{Z: 30, Y: -50, X: 60, V: -10, W: 12},
+		{Z: -120, Y: -20, X: 100, V: 45, W: -80},
+	} {
+		got, err := testGeometry.Inverse(testGeometry.Forward(joints), joints.W)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, pair := range [][2]float32{{got.Z, joints.Z}, {got.Y, joints.Y}, {got.X, joints.X}, {got.V, joints.V}, {got.W, joints.W}} {
+			if !near(float64(pair[0]), float64(pair[1])) {
+				t.Errorf("%+v: got %+v", joints, got)
+				break
+			}
+		}
+	}
+}
+
+func TestInverseRefusesPositionsOutOfReach(t *testing.T) {
+	_, err := testGeometry.Inverse(Position{X: 500, Z: 100}, 0)
+	var unreachable *UnreachableError
+	if !errors.As(err, &unreachable) {
+		t.Errorf("got %v, want an UnreachableError", err)
+	}
+}
@@ -0,0 +1,172 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	FORMAT_TEXT = "text"
+	FORMAT_JSON = "json"
+)
+
+const (
+	TEXT_TIME_FORMAT              = "2006/01/02 15:04:05"
+	TEXT_TIME_FORMAT_MICROSECONDS = "2006/01/02 15:04:05.000000"
+)
+
+// Names accepted in the per-package levels, one for each package that logs.
+var PACKAGES = []string{"main", "cli", "server", "robot", "video", "auth"}
+
+var LEVEL_NAMES = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+type UnknownLevelError struct {
+	name string
+}
+
+func (err *UnknownLevelError) Error() string {
+	return fmt.Sprintf("Unknown log level %q, expected debug, info, warn or error.", err.name)
+}
+
+func ParseLevel(name string) (slog.Level, error) {
+	level, ok := LEVEL_NAMES[strings.ToLower(name)]
+	if !ok {
+		return 0, &UnknownLevelError{name}
+	}
+	return level, nil
+}
+
+type Options struct {
+	Output       io.Writer
+	Format       string
+	Level        slog.Level
+	Packages     map[string]slog.Level
+	Microseconds bool
+}
+
+type settings struct {
+	handler  slog.Handler
+	level    slog.Level
+	packages map[string]slog.Level
+}
+
+func (s *settings) levelFor(pkg string) slog.Level {
+	level, ok := s.packages[pkg]
+	if !ok {
+		return s.level
+	}
+	return level
+}
+
+var (
+	mu      sync.RWMutex
+	current = &settings{handler: slog.NewTextHandler(os.Stderr, nil), level: slog.LevelInfo}
+)
+
+func currentSettings() *settings {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// Configure replaces the output of every logger, including the ones created
+// before it was called, and of the standard log package.
+func Configure(options Options) {
+	output := options.Output
+	if output == nil {
+		output = os.Stderr
+	}
+
+	handlerOptions := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var handler slog.Handler
+	if options.Format == FORMAT_JSON {
+		handler = slog.NewJSONHandler(output, handlerOptions)
+	} else {
+		timeFormat := TEXT_TIME_FORMAT
+		if options.Microseconds {
+			timeFormat = TEXT_TIME_FORMAT_MICROSECONDS
+		}
+		handlerOptions.ReplaceAttr = func(groups []string, attr slog.Attr) slog.Attr {
+			if len(groups) == 0 && attr.Key == slog.TimeKey {
+				return slog.String(slog.TimeKey, attr.Value.Time().Format(timeFormat))
+			}
+			return attr
+		}
+		handler = slog.NewTextHandler(output, handlerOptions)
+	}
+
+	mu.Lock()
+	current = &settings{handler: handler, level: options.Level, packages: options.Packages}
+	mu.Unlock()
+
+	slog.SetDefault(Logger("main"))
+	log.SetFlags(0)
+}
+
+// Logger is meant to be created once per package, its level follows Configure.
+func Logger(pkg string) *slog.Logger {
+	return slog.New(&packageHandler{pkg: pkg}).With("package", pkg)
+}
+
+type contextKey struct{}
+
+// WithAttrs attaches attributes to every line logged with the returned context.
+func WithAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	existing, _ := ctx.Value(contextKey{}).([]slog.Attr)
+	return context.WithValue(ctx, contextKey{}, append(existing[:len(existing):len(existing)], attrs...))
+}
+
+// packageHandler resolves the configured handler on every record, so loggers
+// kept in package variables pick up the configuration loaded later.
+type packageHandler struct {
+	pkg     string
+	derived []func(slog.Handler) slog.Handler
+}
+
+func (h *packageHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= currentSettings().levelFor(h.pkg)
+}
+
+func (h *packageHandler) Handle(ctx context.Context, record slog.Record) error {
+	handler := currentSettings().handler
+	for _, derive := range h.derived {
+		handler = derive(handler)
+	}
+	if attrs, ok := ctx.Value(contextKey{}).([]slog.Attr); ok {
+		record.AddAttrs(attrs...)
+	}
+	return handler.Handle(ctx, record)
+}
+
+func (h *packageHandler) derive(derive func(slog.Handler) slog.Handler) *packageHandler {
+	return &packageHandler{pkg: h.pkg, derived: append(h.derived[:len(h.derived):len(h.derived)], derive)}
+}
+
+func (h *packageHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.derive(func(handler slog.Handler) slog.Handler {
+		return handler.WithAttrs(attrs)
+	})
+}
+
+func (h *packageHandler) WithGroup(name string) slog.Handler {
+	return h.derive(func(handler slog.Handler) slog.Handler {
+		return handler.WithGroup(name)
+	})
+}
+
+// Elapsed keeps durations readable in text output and numeric in JSON.
+func Elapsed(start time.Time) slog.Attr {
+	return slog.Duration("elapsed", time.Since(start))
+}
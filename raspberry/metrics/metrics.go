@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const NAMESPACE = "varm"
+
+// Control loop latencies are a few milliseconds, calibration and long moves take seconds.
+var LATENCY_BUCKETS = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+var Registry = prometheus.NewRegistry()
+
+var (
+	CommandRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "command_requests_total",
+		Help:      "Control commands handled, by command and response code name.",
+	}, []string{"command", "code"})
+
+	CommandDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "command_duration_seconds",
+		Help:      "Time from receiving a control command to having its response.",
+		Buckets:   LATENCY_BUCKETS,
+	}, []string{"command"})
+
+	UARTRoundTrip = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "uart_round_trip_seconds",
+		Help:      "Time between sending an action to the firmware and reading its answer.",
+		Buckets:   LATENCY_BUCKETS,
+	}, []string{"action"})
+
+	FirmwareErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "firmware_errors_total",
+		Help:      "Error codes answered by the firmware and failed exchanges with it, by action and error.",
+	}, []string{"action", "error"})
+
+	ActiveSessions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Name:      "active_sessions",
+		Help:      "Connected control sessions by transport.",
+	}, []string{"transport"})
+
+	FFmpegRestarts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "ffmpeg_restarts_total",
+		Help:      "ffmpeg processes started for a camera after its first one.",
+	}, []string{"device"})
+
+	FFmpegExits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "ffmpeg_unexpected_exits_total",
+		Help:      "ffmpeg processes that ended without being stopped.",
+	}, []string{"device"})
+
+	StreamStarted = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Name:      "stream_started_timestamp_seconds",
+		Help:      "Unix time the running stream of a camera started, 0 when stopped. Uptime is time() minus this.",
+	}, []string{"device"})
+
+	JointAngles = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Name:      "joint_angle_degrees",
+		Help:      "Last joint angles reported by the firmware.",
+	}, []string{"joint"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		CommandRequests,
+		CommandDuration,
+		UARTRoundTrip,
+		FirmwareErrors,
+		ActiveSessions,
+		FFmpegRestarts,
+		FFmpegExits,
+		StreamStarted,
+		JointAngles,
+	)
+}
+
+func ObserveCommand(command string, code string, duration time.Duration) {
+	CommandRequests.WithLabelValues(command, code).Inc()
+	CommandDuration.WithLabelValues(command).Observe(duration.Seconds())
+}
+
+func SetJointAngles(z float32, y float32, x float32, v float32, w float32) {
+	JointAngles.WithLabelValues("z").Set(float64(z))
+	JointAngles.WithLabelValues("y").Set(float64(y))
+	JointAngles.WithLabelValues("x").Set(float64(x))
+	JointAngles.WithLabelValues("v").Set(float64(v))
+	JointAngles.WithLabelValues("w").Set(float64(w))
+}
+
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
@@ -0,0 +1,163 @@
+package pose
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/xTaube/vr-controlled-robot-arm/robot"
+	"github.com/xTaube/vr-controlled-robot-arm/storage"
+	"gopkg.in/yaml.v3"
+)
+
+const kind = "pose"
+
+type NameTakenError struct {
+	name string
+}
+
+func (err *NameTakenError) Error() string {
+	return fmt.Sprintf("A pose named %q already exists.", err.name)
+}
+
+// Library is the set of named poses shared by every client, each change is
+// written to its file before it becomes visible.
+type Library struct {
+	mu    sync.Mutex
+	path  string
+	Poses map[string]robot.JointsAngles `yaml:"poses"`
+}
+
+func (l *Library) Get(name string) (robot.JointsAngles, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	joints, ok := l.Poses[name]
+	if !ok {
+		return robot.JointsAngles{}, &storage.NotFoundError{Kind: kind, Name: name}
+	}
+	return joints, nil
+}
+
+func (l *Library) Names() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	names := make([]string, 0, len(l.Poses))
+	for name := range l.Poses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (l *Library) update(change func(poses map[string]robot.JointsAngles) error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	poses := maps.Clone(l.Poses)
+	err := change(poses)
+	if err != nil {
+		return err
+	}
+	err = l.save(poses)
+	if err != nil {
+		return err
+	}
+	l.Poses = poses
+	return nil
+}
+
+func (l *Library) Add(name string, joints robot.JointsAngles) error {
+	err := storage.ValidateName(kind, name)
+	if err != nil {
+		return err
+	}
+	return l.update(func(poses map[string]robot.JointsAngles) error {
+		if _, ok := poses[name]; ok {
+			return &NameTakenError{name}
+		}
+		poses[name] = joints
+		return nil
+	})
+}
+
+// Set adds the pose or replaces the one of the same name.
+func (l *Library) Set(name string, joints robot.JointsAngles) error {
+	err := storage.ValidateName(kind, name)
+	if err != nil {
+		return err
+	}
+	return l.update(func(poses map[string]robot.JointsAngles) error {
+		poses[name] = joints
+		return nil
+	})
+}
+
+func (l *Library) Rename(from string, to string) error {
+	err := storage.ValidateName(kind, to)
+	if err != nil {
+		return err
+	}
+	return l.update(func(poses map[string]robot.JointsAngles) error {
+		joints, ok := poses[from]
+		if !ok {
+			return &storage.NotFoundError{Kind: kind, Name: from}
+		}
+		if _, ok := poses[to]; ok {
+			return &NameTakenError{to}
+		}
+		delete(poses, from)
+		poses[to] = joints
+		return nil
+	})
+}
+
+func (l *Library) Delete(name string) error {
+	return l.update(func(poses map[string]robot.JointsAngles) error {
+		if _, ok := poses[name]; !ok {
+			return &storage.NotFoundError{Kind: kind, Name: name}
+		}
+		delete(poses, name)
+		return nil
+	})
+}
+
+func (l *Library) save(poses map[string]robot.JointsAngles) error {
+	data, err := yaml.Marshal(map[string]any{"poses": poses})
+	if err != nil {
+		return err
+	}
+	return storage.WriteFile(l.path, data, 0644)
+}
+
+// A missing file is an empty library, it is created on the first change.
+func LoadLibrary(path string) (*Library, error) {
+	library := &Library{path: path, Poses: map[string]robot.JointsAngles{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return library, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read pose library: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err = decoder.Decode(library)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("Cannot parse pose library %s: %w", path, err)
+	}
+	if library.Poses == nil {
+		library.Poses = map[string]robot.JointsAngles{}
+	}
+	for name := range library.Poses {
+		err := storage.ValidateName(kind, name)
+		if err != nil {
+			return nil, fmt.Errorf("Pose library %s: %w", path, err)
+		}
+	}
+	return library, nil
+}
@@ -0,0 +1,122 @@
+package program
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xTaube/vr-controlled-robot-arm/robot"
+	"github.com/xTaube/vr-controlled-robot-arm/storage"
+	"gopkg.in/yaml.v3"
+)
+
+const kind = "program"
+
+const FILE_EXTENSION = ".yaml"
+
+// Waypoint is a taught position, the gripper is set after the arm reached it.
+type Waypoint struct {
+	Joints  robot.JointsAngles `yaml:"joints"`
+	Gripper string             `yaml:"gripper"`
+}
+
+func (w *Waypoint) GripperState() robot.GripperState {
+	state, _ := robot.GripperStateByName(w.Gripper)
+	return state
+}
+
+type Program struct {
+	Name      string     `yaml:"name"`
+	Created   time.Time  `yaml:"created"`
+	Waypoints []Waypoint `yaml:"waypoints"`
+}
+
+func InitProgram(name string) *Program {
+	return &Program{Name: name, Created: time.Now().UTC()}
+}
+
+func (p *Program) AddWaypoint(joints robot.JointsAngles, gripper robot.GripperState) {
+	p.Waypoints = append(p.Waypoints, Waypoint{Joints: joints, Gripper: gripper.String()})
+}
+
+// Store keeps one file per program in a directory, saving a name again replaces the program.
+type Store struct {
+	dir string
+}
+
+func (s *Store) path(name string) string {
+	return filepath.Join(s.dir, name+FILE_EXTENSION)
+}
+
+func (s *Store) Save(program *Program) error {
+	err := storage.ValidateName(kind, program.Name)
+	if err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(program)
+	if err != nil {
+		return err
+	}
+	return storage.WriteFile(s.path(program.Name), data, 0644)
+}
+
+func (s *Store) Load(name string) (*Program, error) {
+	err := storage.ValidateName(kind, name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(s.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, &storage.NotFoundError{Kind: kind, Name: name}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read program %q: %w", name, err)
+	}
+
+	program := &Program{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err = decoder.Decode(program)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("Cannot parse program %s: %w", s.path(name), err)
+	}
+	for i, waypoint := range program.Waypoints {
+		_, ok := robot.GripperStateByName(waypoint.Gripper)
+		if !ok {
+			return nil, fmt.Errorf("Waypoint %d of program %q has unknown gripper state %q.", i+1, name, waypoint.Gripper)
+		}
+	}
+	program.Name = name
+	return program, nil
+}
+
+// A missing directory holds no programs.
+func (s *Store) List() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), FILE_EXTENSION)
+		if ok && !entry.IsDir() && storage.NAME_PATTERN.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func InitStore(dir string) *Store {
+	return &Store{dir: dir}
+}
@@ -1,13 +1,22 @@
 package robot
 
 import (
+	"context"
 	"encoding/binary"
-	"log"
+	"fmt"
 	"math"
+	"sync"
+	"time"
 
+	"github.com/xTaube/vr-controlled-robot-arm/logging"
+	"github.com/xTaube/vr-controlled-robot-arm/metrics"
 	"go.bug.st/serial"
 )
 
+var logger = logging.Logger("robot")
+
+const MOVE_POLL_INTERVAL = 100 * time.Millisecond
+
 type RobotErrorCode uint8
 
 const (
@@ -18,9 +27,30 @@ const (
 	ROBOT_SPEED_TO_SLOW_ERROR
 	ROBOT_IS_IN_MOVE_ERROR
 	ROBOT_NOT_IN_CALIBRATION_MODE
+	ROBOT_INVALID_MOVE_RANGE_ERROR
 	ROBOT_COMMUNICATION_ERROR
 )
 
+var ROBOT_ERROR_NAMES = map[RobotErrorCode]string{
+	ROBOT_INVALID_NUMBER_OF_PARAMETERS_ERROR: "INVALID_NUMBER_OF_PARAMETERS",
+	ROBOT_UNKNOWN_ACTION_ERROR:               "UNKNOWN_ACTION",
+	ROBOT_NOT_CALIBRATED_ERROR:               "NOT_CALIBRATED",
+	ROBOT_SPEED_BEYOND_LIMIT_ERROR:           "SPEED_BEYOND_LIMIT",
+	ROBOT_SPEED_TO_SLOW_ERROR:                "SPEED_TOO_SLOW",
+	ROBOT_IS_IN_MOVE_ERROR:                   "IS_IN_MOVE",
+	ROBOT_NOT_IN_CALIBRATION_MODE:            "NOT_IN_CALIBRATION_MODE",
+	ROBOT_INVALID_MOVE_RANGE_ERROR:           "INVALID_MOVE_RANGE",
+	ROBOT_COMMUNICATION_ERROR:                "COMMUNICATION",
+}
+
+func (c RobotErrorCode) String() string {
+	name, ok := ROBOT_ERROR_NAMES[c]
+	if !ok {
+		return fmt.Sprintf("ROBOT_ERROR_%d", uint8(c))
+	}
+	return name
+}
+
 type RobotError struct {
 	Code RobotErrorCode
 	Err  error
@@ -42,6 +72,11 @@ func (err *RobotError) Error() string {
 		return "Cannot perform action while robot is moving."
 	case ROBOT_NOT_IN_CALIBRATION_MODE:
 		return "Robot is not in calibration mode."
+	case ROBOT_INVALID_MOVE_RANGE_ERROR:
+		if err.Err != nil {
+			return fmt.Sprintf("Requested position is beyond joint limits: %s.", err.Err)
+		}
+		return "Requested position is beyond joint limits."
 	default:
 		if err.Err != nil {
 			return err.Err.Error()
@@ -65,6 +100,27 @@ const (
 	ACTION_CLOSE_GRIPPER
 )
 
+var ACTION_NAMES = map[ActionId]string{
+	ACTION_MOVE:                  "MOVE",
+	ACTION_SET_SPEED:             "SET_SPEED",
+	ACTION_GET_CURRENT_POSITION:  "GET_CURRENT_POSITION",
+	ACTION_CHECK_ARM_CALIBRATION: "CHECK_ARM_CALIBRATION",
+	ACTION_START_CALIBARATION:    "START_CALIBRATION",
+	ACTION_FINISH_CALIBRATION:    "FINISH_CALIBRATION",
+	ACTION_ABORT_CALIBRATION:     "ABORT_CALIBRATION",
+	ACTION_CHECK_IDLE:            "CHECK_IDLE",
+	ACTION_OPEN_GRIPPER:          "OPEN_GRIPPER",
+	ACTION_CLOSE_GRIPPER:         "CLOSE_GRIPPER",
+}
+
+func (a ActionId) String() string {
+	name, ok := ACTION_NAMES[a]
+	if !ok {
+		return fmt.Sprintf("ACTION_%d", uint8(a))
+	}
+	return name
+}
+
 const (
 	ACTION_ID_OFFSET     uint8 = 0
 	ACTION_ID_SIZE       uint8 = ACTION_ID_OFFSET + 1
@@ -82,6 +138,38 @@ const (
 	SPEED_VALUE_SIZE     uint8 = 4
 )
 
+// The firmware cannot report the gripper, so its state is the last command that succeeded.
+type GripperState byte
+
+const (
+	GRIPPER_UNKNOWN GripperState = iota
+	GRIPPER_OPEN
+	GRIPPER_CLOSED
+)
+
+var GRIPPER_STATE_NAMES = map[GripperState]string{
+	GRIPPER_UNKNOWN: "unknown",
+	GRIPPER_OPEN:    "open",
+	GRIPPER_CLOSED:  "closed",
+}
+
+func (s GripperState) String() string {
+	name, ok := GRIPPER_STATE_NAMES[s]
+	if !ok {
+		return fmt.Sprintf("GRIPPER_%d", uint8(s))
+	}
+	return name
+}
+
+func GripperStateByName(name string) (GripperState, bool) {
+	for state, stateName := range GRIPPER_STATE_NAMES {
+		if stateName == name {
+			return state, true
+		}
+	}
+	return GRIPPER_UNKNOWN, false
+}
+
 type JointsAngles struct {
 	X float32
 	Y float32
@@ -90,22 +178,83 @@ type JointsAngles struct {
 	W float32
 }
 
+type JointLimits struct {
+	Min float32
+	Max float32
+}
+
+type Limits struct {
+	MinSpeed float32
+	MaxSpeed float32
+	X        JointLimits
+	Y        JointLimits
+	Z        JointLimits
+	V        JointLimits
+	W        JointLimits
+}
+
+func (l *Limits) checkJoints(joints JointsAngles) error {
+	for _, joint := range []struct {
+		name   string
+		value  float32
+		limits JointLimits
+	}{
+		{"X", joints.X, l.X}, {"Y", joints.Y, l.Y}, {"Z", joints.Z, l.Z}, {"V", joints.V, l.V}, {"W", joints.W, l.W},
+	} {
+		if joint.value < joint.limits.Min || joint.value > joint.limits.Max {
+			return fmt.Errorf("%s=%g outside [%g, %g]", joint.name, joint.value, joint.limits.Min, joint.limits.Max)
+		}
+	}
+	return nil
+}
+
+type Transport interface {
+	Send(data []byte) error
+	Get() ([]byte, error)
+	Close() error
+}
+
 type Robot struct {
-	uart *Uart
+	mu        sync.Mutex
+	transport Transport
+	limits    Limits
+	gripper   GripperState
+	// The wrist servos cannot report where they are, only the angle last
+	// written to them, so the angles last commanded are kept here.
+	servos *JointsAngles
 }
 
-func (r *Robot) executeSimpleAction(action ActionId) error {
-	data := make([]byte, ACTION_ID_SIZE)
-	data[0] = byte(action)
+func (r *Robot) exchange(data []byte) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	action := ActionId(data[ACTION_ID_OFFSET]).String()
+	started := time.Now()
+	err := r.transport.Send(data)
+	if err != nil {
+		metrics.FirmwareErrors.WithLabelValues(action, ROBOT_COMMUNICATION_ERROR.String()).Inc()
+		return nil, &RobotError{ROBOT_COMMUNICATION_ERROR, err}
+	}
 
-	err := r.uart.Send(data)
+	result, err := r.transport.Get()
 	if err != nil {
-		return &RobotError{ROBOT_COMMUNICATION_ERROR, err}
+		metrics.FirmwareErrors.WithLabelValues(action, ROBOT_COMMUNICATION_ERROR.String()).Inc()
+		return nil, &RobotError{ROBOT_COMMUNICATION_ERROR, err}
+	}
+	metrics.UARTRoundTrip.WithLabelValues(action).Observe(time.Since(started).Seconds())
+	if len(result) > 0 && result[0] >= 10 {
+		metrics.FirmwareErrors.WithLabelValues(action, RobotErrorCode(result[0]).String()).Inc()
 	}
+	return result, nil
+}
+
+func (r *Robot) executeSimpleAction(action ActionId) error {
+	data := make([]byte, ACTION_ID_SIZE)
+	data[0] = byte(action)
 
-	result, err := r.uart.Get()
+	result, err := r.exchange(data)
 	if err != nil {
-		return &RobotError{ROBOT_COMMUNICATION_ERROR, err}
+		return err
 	}
 
 	resultCode := RobotErrorCode(result[0])
@@ -115,7 +264,25 @@ func (r *Robot) executeSimpleAction(action ActionId) error {
 	return nil
 }
 
+func (r *Robot) Limits() Limits {
+	return r.limits
+}
+
+// CheckLimits tells whether Move would accept joints without sending them to the arm.
+func (r *Robot) CheckLimits(joints JointsAngles) error {
+	err := r.limits.checkJoints(joints)
+	if err != nil {
+		return &RobotError{ROBOT_INVALID_MOVE_RANGE_ERROR, err}
+	}
+	return nil
+}
+
 func (r *Robot) Move(translations JointsAngles) (*JointsAngles, error) {
+	err := r.CheckLimits(translations)
+	if err != nil {
+		return nil, err
+	}
+
 	data := make([]byte, W_JOINT_VALUE_OFFSET+W_JOINT_VALUE_SIZE)
 
 	data[ACTION_ID_OFFSET] = byte(ACTION_MOVE)
@@ -140,20 +307,12 @@ func (r *Robot) Move(translations JointsAngles) (*JointsAngles, error) {
 		math.Float32bits(translations.W),
 	)
 
-	err := r.uart.Send(data)
-	if err != nil {
-		return nil, &RobotError{ROBOT_COMMUNICATION_ERROR, err}
-	}
-
-	result, err := r.uart.Get()
+	result, err := r.exchange(data)
 	if err != nil {
-		return nil, &RobotError{ROBOT_COMMUNICATION_ERROR, err}
+		return nil, err
 	}
 
 	resultCode := RobotErrorCode(result[0])
-	log.Printf("Bytes received: %v\n", result)
-	log.Println("UART received data: ")
-	log.Printf("Code: %d\n", resultCode)
 
 	if resultCode >= 10 {
 		return nil, &RobotError{resultCode, nil}
@@ -166,15 +325,22 @@ func (r *Robot) Move(translations JointsAngles) (*JointsAngles, error) {
 		V: math.Float32frombits(binary.LittleEndian.Uint32(result[V_JOINT_VALUE_OFFSET : V_JOINT_VALUE_OFFSET+V_JOINT_VALUE_SIZE])),
 		W: math.Float32frombits(binary.LittleEndian.Uint32(result[W_JOINT_VALUE_OFFSET : W_JOINT_VALUE_OFFSET+W_JOINT_VALUE_SIZE])),
 	}
-	log.Printf("X: %f\n", fallback.X)
-	log.Printf("Y: %f\n", fallback.Y)
-	log.Printf("Z: %f\n", fallback.Z)
-	log.Printf("V: %f\n", fallback.V)
-	log.Printf("W: %f\n", fallback.W)
+	metrics.SetJointAngles(fallback.Z, fallback.Y, fallback.X, fallback.V, fallback.W)
+
+	r.mu.Lock()
+	r.servos = &JointsAngles{V: fallback.V, W: fallback.W}
+	r.mu.Unlock()
 	return &fallback, nil
 }
 
 func (r *Robot) SetSpeed(speed float32) error {
+	if speed > r.limits.MaxSpeed {
+		return &RobotError{ROBOT_SPEED_BEYOND_LIMIT_ERROR, nil}
+	}
+	if speed < r.limits.MinSpeed {
+		return &RobotError{ROBOT_SPEED_TO_SLOW_ERROR, nil}
+	}
+
 	data := make([]byte, SPEED_VALUE_OFFSET+SPEED_VALUE_SIZE)
 	data[0] = byte(ACTION_SET_SPEED)
 	binary.LittleEndian.PutUint32(
@@ -182,14 +348,9 @@ func (r *Robot) SetSpeed(speed float32) error {
 		math.Float32bits(speed),
 	)
 
-	err := r.uart.Send(data)
+	result, err := r.exchange(data)
 	if err != nil {
-		return &RobotError{ROBOT_COMMUNICATION_ERROR, err}
-	}
-
-	result, err := r.uart.Get()
-	if err != nil {
-		return &RobotError{ROBOT_COMMUNICATION_ERROR, err}
+		return err
 	}
 
 	resultCode := RobotErrorCode(result[0])
@@ -204,16 +365,10 @@ func (r *Robot) GetCurrentPosition() (*JointsAngles, error) {
 	data := make([]byte, ACTION_ID_OFFSET+ACTION_ID_SIZE)
 	data[0] = byte(ACTION_GET_CURRENT_POSITION)
 
-	err := r.uart.Send(data)
-	if err != nil {
-		return nil, &RobotError{ROBOT_COMMUNICATION_ERROR, err}
-	}
-
-	result, err := r.uart.Get()
+	result, err := r.exchange(data)
 	if err != nil {
-		return nil, &RobotError{ROBOT_COMMUNICATION_ERROR, err}
+		return nil, err
 	}
-	log.Printf("Bytes received: %v\n", result)
 
 	resultCode := RobotErrorCode(result[0])
 	if resultCode >= 10 {
@@ -224,19 +379,34 @@ func (r *Robot) GetCurrentPosition() (*JointsAngles, error) {
 		X: math.Float32frombits(binary.LittleEndian.Uint32(result[X_JOINT_VALUE_OFFSET : X_JOINT_VALUE_OFFSET+X_JOINT_VALUE_SIZE])),
 		Y: math.Float32frombits(binary.LittleEndian.Uint32(result[Y_JOINT_VALUE_OFFSET : Y_JOINT_VALUE_OFFSET+Y_JOINT_VALUE_SIZE])),
 		Z: math.Float32frombits(binary.LittleEndian.Uint32(result[Z_JOINT_VALUE_OFFSET : Z_JOINT_VALUE_OFFSET+Z_JOINT_VALUE_SIZE])),
-		V: math.Float32frombits(binary.LittleEndian.Uint32(result[V_JOINT_VALUE_OFFSET : V_JOINT_VALUE_OFFSET+V_JOINT_VALUE_SIZE])),
-		W: math.Float32frombits(binary.LittleEndian.Uint32(result[W_JOINT_VALUE_OFFSET : W_JOINT_VALUE_OFFSET+W_JOINT_VALUE_SIZE])),
 	}
-	log.Println("Current position:")
-	log.Printf("X: %f\n", currentPosition.X)
-	log.Printf("Y: %f\n", currentPosition.Y)
-	log.Printf("Z: %f\n", currentPosition.Z)
-	log.Printf("V: %f\n", currentPosition.V)
-	log.Printf("W: %f\n", currentPosition.W)
+	currentPosition.V, currentPosition.W = r.servoAngles(
+		math.Float32frombits(binary.LittleEndian.Uint32(result[V_JOINT_VALUE_OFFSET:V_JOINT_VALUE_OFFSET+V_JOINT_VALUE_SIZE])),
+		math.Float32frombits(binary.LittleEndian.Uint32(result[W_JOINT_VALUE_OFFSET:W_JOINT_VALUE_OFFSET+W_JOINT_VALUE_SIZE])),
+	)
+	metrics.SetJointAngles(currentPosition.Z, currentPosition.Y, currentPosition.X, currentPosition.V, currentPosition.W)
 
 	return &currentPosition, nil
 }
 
+// The arm reports the wrist servos as written to them, V shifted by 90 and W
+// mirrored around 90, so they are turned back into joint angles here. Once
+// this process moved the arm the commanded angles are used instead, as W is
+// clamped when written. The firmware deployed so far reports the V servo in
+// place of W, so a W equal to V tells nothing and W is taken as 0, where the
+// servo stands after power-on, until this process moves it.
+func (r *Robot) servoAngles(v float32, w float32) (float32, float32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.servos != nil {
+		return r.servos.V, r.servos.W
+	}
+	if w == v {
+		return v - 90, 0
+	}
+	return v - 90, 90 - w
+}
+
 func (r *Robot) StartCalibration() error {
 	return r.executeSimpleAction(ACTION_START_CALIBARATION)
 }
@@ -249,25 +419,92 @@ func (r *Robot) AbortCalibration() error {
 	return r.executeSimpleAction(ACTION_ABORT_CALIBRATION)
 }
 
+func (r *Robot) IsCalibrated() (bool, error) {
+	err := r.executeSimpleAction(ACTION_CHECK_ARM_CALIBRATION)
+	robotErr, ok := err.(*RobotError)
+	if ok && robotErr.Code == ROBOT_NOT_CALIBRATED_ERROR {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 func (r *Robot) IsIdle() bool {
 	err := r.executeSimpleAction(ACTION_CHECK_IDLE)
 	return err == nil
 }
 
+func (r *Robot) IsMoving() (bool, error) {
+	err := r.executeSimpleAction(ACTION_CHECK_IDLE)
+	robotErr, ok := err.(*RobotError)
+	if ok && robotErr.Code == ROBOT_IS_IN_MOVE_ERROR {
+		return true, nil
+	}
+	return false, err
+}
+
+func (r *Robot) setGripper(action ActionId, state GripperState) error {
+	err := r.executeSimpleAction(action)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.gripper = state
+	return nil
+}
+
 func (r *Robot) OpenGripper() error {
-	return r.executeSimpleAction(ACTION_OPEN_GRIPPER)
+	return r.setGripper(ACTION_OPEN_GRIPPER, GRIPPER_OPEN)
 }
 
 func (r *Robot) CloseGripper() error {
-	return r.executeSimpleAction(ACTION_CLOSE_GRIPPER)
+	return r.setGripper(ACTION_CLOSE_GRIPPER, GRIPPER_CLOSED)
+}
+
+func (r *Robot) GripperState() GripperState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.gripper
+}
+
+// MoveAndWait moves the arm to joints and waits for it to stop, or for ctx to end.
+// The firmware cannot stop a move halfway, ending ctx only stops the waiting.
+func (r *Robot) MoveAndWait(ctx context.Context, joints JointsAngles) error {
+	_, err := r.Move(joints)
+	if err != nil {
+		return err
+	}
+	return r.WaitIdle(ctx)
+}
+
+// WaitIdle polls the arm until it stopped moving, or until ctx ends.
+func (r *Robot) WaitIdle(ctx context.Context) error {
+	ticker := time.NewTicker(MOVE_POLL_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			moving, err := r.IsMoving()
+			if err != nil {
+				return err
+			}
+			if !moving {
+				return nil
+			}
+		}
+	}
 }
 
 func (r *Robot) ShutDown() {
-	r.uart.Close()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.transport.Close()
 }
 
-func InitRobot(uartConfig UartConfig) (*Robot, error) {
-	log.Println("Initializing UART...")
+func InitRobot(uartConfig UartConfig, limits Limits) (*Robot, error) {
+	logger.Info("Initializing UART", "port", uartConfig.PortName, "baud_rate", uartConfig.BaudRate)
 	uart, err := initUart(
 		uartConfig.PortName,
 		&serial.Mode{
@@ -281,7 +518,12 @@ func InitRobot(uartConfig UartConfig) (*Robot, error) {
 	if err != nil {
 		return nil, err
 	}
-	log.Println("UART initialized.")
+	logger.Info("UART initialized", "port", uartConfig.PortName)
+
+	return &Robot{transport: uart, limits: limits}, nil
+}
 
-	return &Robot{uart: uart}, nil
+func InitSimulatedRobot(limits Limits) *Robot {
+	logger.Info("Robot arm runs in simulation mode, no UART is used")
+	return &Robot{transport: initSimulator(), limits: limits}
 }
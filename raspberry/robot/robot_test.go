@@ -0,0 +1,61 @@
+package robot
+
+import "testing"
+
+var testLimits = Limits{
+	MinSpeed: 1,
+	MaxSpeed: 100,
+	X:        JointLimits{Min: -180, Max: 180},
+	Y:        JointLimits{Min: -180, Max: 180},
+	Z:        JointLimits{Min: -180, Max: 180},
+	V:        JointLimits{Min: -90, Max: 90},
+	W:        JointLimits{Min: -90, Max: 90},
+}
+
+func TestGetCurrentPositionReportsWristAsJointAngles(t *testing.T) {
+	simulator := initSimulator()
+	arm := &Robot{transport: simulator, limits: testLimits}
+	if err := arm.StartCalibration(); err != nil {
+		t.Fatal(err)
+	}
+
+	position, err := arm.GetCurrentPosition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if position.V != 0 || position.W != 0 {
+		t.Fatalf("wrist before any move = V%g W%g, want V0 W0", position.V, position.W)
+	}
+
+	if _, err := arm.Move(JointsAngles{Y: -90, V: 30, W: -20}); err != nil {
+		t.Fatal(err)
+	}
+	position, err = arm.GetCurrentPosition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if position.V != 30 || position.W != -20 {
+		t.Fatalf("wrist after move = V%g W%g, want V30 W-20", position.V, position.W)
+	}
+
+	// A new process knows nothing of earlier moves and reads the servos back,
+	// the deployed firmware reports V in place of W.
+	restarted := &Robot{transport: simulator, limits: testLimits}
+	position, err = restarted.GetCurrentPosition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if position.V != 30 || position.W != 0 {
+		t.Fatalf("wrist read back = V%g W%g, want V30 W0", position.V, position.W)
+	}
+}
+
+func TestServoAnglesReadWFromFirmwareReportingIt(t *testing.T) {
+	arm := &Robot{limits: testLimits}
+	if v, w := arm.servoAngles(120, 110); v != 30 || w != -20 {
+		t.Errorf("got V%g W%g, want V30 W-20", v, w)
+	}
+	if v, w := arm.servoAngles(120, 120); v != 30 || w != 0 {
+		t.Errorf("W reported as V: got V%g W%g, want V30 W0", v, w)
+	}
+}
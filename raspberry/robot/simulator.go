@@ -0,0 +1,226 @@
+package robot
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	SIMULATOR_RESULT_OK     byte    = 1
+	SIMULATOR_DEG_PER_STEP  float32 = 0.1125
+	SIMULATOR_DEFAULT_SPEED float32 = 50
+)
+
+type Simulator struct {
+	mu            sync.Mutex
+	isCalibrated  bool
+	inCalibration bool
+	speed         float32
+	from          JointsAngles
+	to            JointsAngles
+	moveStarted   time.Time
+	moveDuration  time.Duration
+	pending       []byte
+	closed        bool
+}
+
+func initSimulator() *Simulator {
+	return &Simulator{
+		speed: SIMULATOR_DEFAULT_SPEED,
+		from:  JointsAngles{Y: -90},
+		to:    JointsAngles{Y: -90},
+	}
+}
+
+func (s *Simulator) position() JointsAngles {
+	if s.moveDuration == 0 {
+		return s.to
+	}
+	progress := float32(time.Since(s.moveStarted)) / float32(s.moveDuration)
+	if progress >= 1 {
+		return s.to
+	}
+	interpolate := func(from float32, to float32) float32 {
+		return from + (to-from)*progress
+	}
+	return JointsAngles{
+		X: interpolate(s.from.X, s.to.X),
+		Y: interpolate(s.from.Y, s.to.Y),
+		Z: interpolate(s.from.Z, s.to.Z),
+		V: s.to.V,
+		W: s.to.W,
+	}
+}
+
+func (s *Simulator) isInMove() bool {
+	return time.Since(s.moveStarted) < s.moveDuration
+}
+
+func (s *Simulator) canOperate() bool {
+	return s.isCalibrated || s.inCalibration
+}
+
+func (s *Simulator) move(target JointsAngles) {
+	current := s.position()
+	distance := math.Max(
+		math.Abs(float64(target.X-current.X)),
+		math.Max(math.Abs(float64(target.Y-current.Y)), math.Abs(float64(target.Z-current.Z))),
+	)
+	degreesPerSecond := float64(s.speed * SIMULATOR_DEG_PER_STEP)
+
+	s.from = current
+	s.to = JointsAngles{
+		X: target.X,
+		Y: target.Y,
+		Z: target.Z,
+		V: float32(math.Round(float64(target.V))),
+		W: float32(math.Round(float64(target.W))),
+	}
+	s.moveStarted = time.Now()
+	s.moveDuration = time.Duration(distance / degreesPerSecond * float64(time.Second))
+}
+
+func readJointsAngles(data []byte) JointsAngles {
+	return JointsAngles{
+		X: math.Float32frombits(binary.LittleEndian.Uint32(data[X_JOINT_VALUE_OFFSET : X_JOINT_VALUE_OFFSET+X_JOINT_VALUE_SIZE])),
+		Y: math.Float32frombits(binary.LittleEndian.Uint32(data[Y_JOINT_VALUE_OFFSET : Y_JOINT_VALUE_OFFSET+Y_JOINT_VALUE_SIZE])),
+		Z: math.Float32frombits(binary.LittleEndian.Uint32(data[Z_JOINT_VALUE_OFFSET : Z_JOINT_VALUE_OFFSET+Z_JOINT_VALUE_SIZE])),
+		V: math.Float32frombits(binary.LittleEndian.Uint32(data[V_JOINT_VALUE_OFFSET : V_JOINT_VALUE_OFFSET+V_JOINT_VALUE_SIZE])),
+		W: math.Float32frombits(binary.LittleEndian.Uint32(data[W_JOINT_VALUE_OFFSET : W_JOINT_VALUE_OFFSET+W_JOINT_VALUE_SIZE])),
+	}
+}
+
+func writeJointsAngles(code byte, joints JointsAngles) []byte {
+	data := make([]byte, W_JOINT_VALUE_OFFSET+W_JOINT_VALUE_SIZE)
+	data[0] = code
+	binary.LittleEndian.PutUint32(data[X_JOINT_VALUE_OFFSET:X_JOINT_VALUE_OFFSET+X_JOINT_VALUE_SIZE], math.Float32bits(joints.X))
+	binary.LittleEndian.PutUint32(data[Y_JOINT_VALUE_OFFSET:Y_JOINT_VALUE_OFFSET+Y_JOINT_VALUE_SIZE], math.Float32bits(joints.Y))
+	binary.LittleEndian.PutUint32(data[Z_JOINT_VALUE_OFFSET:Z_JOINT_VALUE_OFFSET+Z_JOINT_VALUE_SIZE], math.Float32bits(joints.Z))
+	binary.LittleEndian.PutUint32(data[V_JOINT_VALUE_OFFSET:V_JOINT_VALUE_OFFSET+V_JOINT_VALUE_SIZE], math.Float32bits(joints.V))
+	binary.LittleEndian.PutUint32(data[W_JOINT_VALUE_OFFSET:W_JOINT_VALUE_OFFSET+W_JOINT_VALUE_SIZE], math.Float32bits(joints.W))
+	return data
+}
+
+func (s *Simulator) execute(data []byte) []byte {
+	result := func(code RobotErrorCode) []byte {
+		return []byte{byte(code)}
+	}
+	ok := []byte{SIMULATOR_RESULT_OK}
+
+	switch ActionId(data[ACTION_ID_OFFSET]) {
+	case ACTION_MOVE:
+		if len(data) < int(W_JOINT_VALUE_OFFSET+W_JOINT_VALUE_SIZE) {
+			return result(ROBOT_INVALID_NUMBER_OF_PARAMETERS_ERROR)
+		}
+		if !s.canOperate() {
+			return result(ROBOT_NOT_CALIBRATED_ERROR)
+		}
+		s.move(readJointsAngles(data))
+		return writeJointsAngles(SIMULATOR_RESULT_OK, s.to)
+
+	case ACTION_SET_SPEED:
+		if s.isInMove() {
+			return result(ROBOT_IS_IN_MOVE_ERROR)
+		}
+		s.speed = math.Float32frombits(binary.LittleEndian.Uint32(data[SPEED_VALUE_OFFSET : SPEED_VALUE_OFFSET+SPEED_VALUE_SIZE]))
+		return ok
+
+	case ACTION_GET_CURRENT_POSITION:
+		if !s.canOperate() {
+			return result(ROBOT_NOT_CALIBRATED_ERROR)
+		}
+		// Like the deployed firmware, the wrist is reported as the V servo was
+		// written, in place of W too.
+		position := s.position()
+		position.V = position.V + 90
+		position.W = position.V
+		return writeJointsAngles(SIMULATOR_RESULT_OK, position)
+
+	case ACTION_CHECK_ARM_CALIBRATION:
+		if !s.isCalibrated {
+			return result(ROBOT_NOT_CALIBRATED_ERROR)
+		}
+		return ok
+
+	case ACTION_START_CALIBARATION:
+		if s.isInMove() {
+			return result(ROBOT_IS_IN_MOVE_ERROR)
+		}
+		s.inCalibration = true
+		s.isCalibrated = false
+		return ok
+
+	case ACTION_FINISH_CALIBRATION:
+		if !s.inCalibration {
+			return result(ROBOT_NOT_IN_CALIBRATION_MODE)
+		}
+		if s.isInMove() {
+			return result(ROBOT_IS_IN_MOVE_ERROR)
+		}
+		s.from = JointsAngles{Y: -90, V: s.to.V, W: s.to.W}
+		s.to = s.from
+		s.inCalibration = false
+		s.isCalibrated = true
+		return ok
+
+	case ACTION_ABORT_CALIBRATION:
+		if !s.inCalibration {
+			return result(ROBOT_NOT_IN_CALIBRATION_MODE)
+		}
+		s.inCalibration = false
+		return ok
+
+	case ACTION_CHECK_IDLE:
+		if s.isInMove() {
+			return result(ROBOT_IS_IN_MOVE_ERROR)
+		}
+		return ok
+
+	case ACTION_OPEN_GRIPPER, ACTION_CLOSE_GRIPPER:
+		if !s.isCalibrated {
+			return result(ROBOT_NOT_CALIBRATED_ERROR)
+		}
+		return ok
+
+	default:
+		return result(ROBOT_UNKNOWN_ACTION_ERROR)
+	}
+}
+
+func (s *Simulator) Send(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return errors.New("simulator is shut down")
+	}
+	if len(data) == 0 {
+		s.pending = []byte{byte(ROBOT_INVALID_NUMBER_OF_PARAMETERS_ERROR)}
+		return nil
+	}
+	s.pending = s.execute(data)
+	return nil
+}
+
+func (s *Simulator) Get() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pending == nil {
+		return nil, errors.New("simulator has no pending response")
+	}
+	result := s.pending
+	s.pending = nil
+	return result, nil
+}
+
+func (s *Simulator) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	return nil
+}
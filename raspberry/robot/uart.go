@@ -1,7 +1,6 @@
 package robot
 
 import (
-	"log"
 	"slices"
 
 	"go.bug.st/serial"
@@ -81,7 +80,7 @@ func initUart(
 func (u *Uart) Close() error {
 	err := u.port.Close()
 	if err != nil {
-		log.Printf("Failed to close UART port %s\n", err)
+		logger.Error("Cannot close UART port", "port", u.portName, "error", err)
 		return err
 	}
 	return nil
@@ -92,7 +91,7 @@ func (u *Uart) Send(data []byte) error {
 
 	_, err := u.port.Write(data)
 	if err != nil {
-		log.Printf("UART: writing data resulted in error: %s\n", err)
+		logger.Error("Cannot write to UART", "port", u.portName, "error", err)
 		return err
 	}
 
@@ -0,0 +1,264 @@
+package script
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/xTaube/vr-controlled-robot-arm/pose"
+	"github.com/xTaube/vr-controlled-robot-arm/robot"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// Routines are written as flat sequences, so loops and reassignment are allowed at the top level.
+var FILE_OPTIONS = &syntax.FileOptions{While: true, TopLevelControl: true, GlobalReassign: true}
+
+var JOINT_NAMES = []string{"z", "y", "x", "v", "w"}
+
+// Limits bound a run. Timeout includes waiting for the arm and sleeping, MaxSteps counts only the interpreter's own work.
+type Limits struct {
+	Timeout  time.Duration
+	MaxSteps uint64
+}
+
+type SourceTooLargeError struct {
+	size int
+}
+
+func (err *SourceTooLargeError) Error() string {
+	return fmt.Sprintf("Script is %d bytes long, the limit is %d.", err.size, MAX_SOURCE_SIZE)
+}
+
+// RunError is a script failing while it runs, Position is the script line it failed at.
+type RunError struct {
+	Position string
+	Message  string
+	cause    error
+}
+
+func (err *RunError) Error() string {
+	if err.Position == "" {
+		return err.Message
+	}
+	return fmt.Sprintf("%s: %s", err.Position, err.Message)
+}
+
+func (err *RunError) Unwrap() error {
+	return err.cause
+}
+
+// Compile checks a script without running it, errors cite the offending line.
+// Scripts are self-contained, load() statements are refused here rather than when they run.
+func Compile(script *Script) error {
+	if len(script.Source) > MAX_SOURCE_SIZE {
+		return &SourceTooLargeError{len(script.Source)}
+	}
+	builtins := (&robotAPI{}).builtins()
+	_, program, err := starlark.SourceProgramOptions(FILE_OPTIONS, script.Name+FILE_EXTENSION, script.Source, builtins.Has)
+	if err != nil {
+		return err
+	}
+	if program.NumLoads() > 0 {
+		module, position := program.Load(0)
+		return fmt.Errorf("%s: cannot load %q, scripts have no access to other files", position, module)
+	}
+	return nil
+}
+
+// Run executes a script against the arm and hands every printed line to output.
+// The script can only reach the arm through its builtins, load() is not available.
+// Ending ctx stops it at the next step or once the builtin it is in returns.
+func Run(ctx context.Context, arm *robot.Robot, poses *pose.Library, script *Script, limits Limits, output func(line string)) error {
+	ctx, cancel := context.WithTimeout(ctx, limits.Timeout)
+	defer cancel()
+
+	thread := &starlark.Thread{
+		Name:  script.Name,
+		Print: func(_ *starlark.Thread, message string) { output(message) },
+	}
+	thread.SetMaxExecutionSteps(limits.MaxSteps)
+	stop := context.AfterFunc(ctx, func() { thread.Cancel(cancelReason(ctx, limits)) })
+	defer stop()
+
+	api := &robotAPI{ctx: ctx, robot: arm, poses: poses}
+	_, err := starlark.ExecFileOptions(FILE_OPTIONS, thread, script.Name+FILE_EXTENSION, script.Source, api.builtins())
+	if err == nil {
+		return nil
+	}
+	return runError(ctx, limits, err)
+}
+
+func cancelReason(ctx context.Context, limits Limits) string {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Sprintf("time limit of %s exceeded", limits.Timeout)
+	}
+	return "stopped"
+}
+
+// A failure is reported at the innermost script frame, builtins have no line of their own.
+func runError(ctx context.Context, limits Limits, err error) error {
+	runErr := &RunError{Message: err.Error(), cause: err}
+	var evalErr *starlark.EvalError
+	if errors.As(err, &evalErr) {
+		runErr.Message = evalErr.Msg
+		for i := len(evalErr.CallStack) - 1; i >= 0; i-- {
+			position := evalErr.CallStack[i].Pos
+			if position.Filename() != "<builtin>" {
+				runErr.Position = position.String()
+				break
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		runErr.Message = cancelReason(ctx, limits)
+		runErr.cause = ctx.Err()
+	}
+	return runErr
+}
+
+type robotAPI struct {
+	ctx   context.Context
+	robot *robot.Robot
+	poses *pose.Library
+}
+
+func (a *robotAPI) builtins() starlark.StringDict {
+	return starlark.StringDict{
+		"move":         starlark.NewBuiltin("move", a.move),
+		"move_to":      starlark.NewBuiltin("move_to", a.moveTo),
+		"set_speed":    starlark.NewBuiltin("set_speed", a.setSpeed),
+		"gripper":      starlark.NewBuiltin("gripper", a.gripper),
+		"wait_idle":    starlark.NewBuiltin("wait_idle", a.waitIdle),
+		"get_position": starlark.NewBuiltin("get_position", a.getPosition),
+		"sleep":        starlark.NewBuiltin("sleep", a.sleep),
+	}
+}
+
+func builtinError(fn *starlark.Builtin, err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%s: %w", fn.Name(), err)
+}
+
+// Starlark keeps ints and floats apart, builtins accept either.
+func unpackNumbers(fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple, names ...string) ([]float32, error) {
+	values := make([]starlark.Value, len(names))
+	pairs := make([]any, 0, 2*len(names))
+	for i, name := range names {
+		pairs = append(pairs, name, &values[i])
+	}
+	err := starlark.UnpackArgs(fn.Name(), args, kwargs, pairs...)
+	if err != nil {
+		return nil, err
+	}
+
+	numbers := make([]float32, len(values))
+	for i, value := range values {
+		number, ok := starlark.AsFloat(value)
+		if !ok {
+			return nil, fmt.Errorf("%s: %s must be a number, got %s", fn.Name(), names[i], value.Type())
+		}
+		numbers[i] = float32(number)
+	}
+	return numbers, nil
+}
+
+// move(z, y, x, v, w) starts a move to the joint angles and returns without waiting for it.
+func (a *robotAPI) move(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	angles, err := unpackNumbers(fn, args, kwargs, JOINT_NAMES...)
+	if err != nil {
+		return nil, err
+	}
+	_, err = a.robot.Move(robot.JointsAngles{Z: angles[0], Y: angles[1], X: angles[2], V: angles[3], W: angles[4]})
+	return starlark.None, builtinError(fn, err)
+}
+
+// move_to(pose) starts a move to a stored pose.
+func (a *robotAPI) moveTo(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	err := starlark.UnpackArgs(fn.Name(), args, kwargs, "pose", &name)
+	if err != nil {
+		return nil, err
+	}
+	joints, err := a.poses.Get(name)
+	if err != nil {
+		return nil, builtinError(fn, err)
+	}
+	_, err = a.robot.Move(joints)
+	return starlark.None, builtinError(fn, err)
+}
+
+func (a *robotAPI) setSpeed(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	speed, err := unpackNumbers(fn, args, kwargs, "speed")
+	if err != nil {
+		return nil, err
+	}
+	return starlark.None, builtinError(fn, a.robot.SetSpeed(speed[0]))
+}
+
+// gripper(state) opens or closes the gripper, state is "open" or "close".
+func (a *robotAPI) gripper(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var state string
+	err := starlark.UnpackArgs(fn.Name(), args, kwargs, "state", &state)
+	if err != nil {
+		return nil, err
+	}
+	switch state {
+	case "open":
+		err = a.robot.OpenGripper()
+	case "close":
+		err = a.robot.CloseGripper()
+	default:
+		return nil, fmt.Errorf("%s: state must be \"open\" or \"close\", got %q", fn.Name(), state)
+	}
+	return starlark.None, builtinError(fn, err)
+}
+
+func (a *robotAPI) waitIdle(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	err := starlark.UnpackArgs(fn.Name(), args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.None, builtinError(fn, a.robot.WaitIdle(a.ctx))
+}
+
+// get_position() returns the joint angles as a dict keyed by joint name.
+func (a *robotAPI) getPosition(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	err := starlark.UnpackArgs(fn.Name(), args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	joints, err := a.robot.GetCurrentPosition()
+	if err != nil {
+		return nil, builtinError(fn, err)
+	}
+
+	position := starlark.NewDict(len(JOINT_NAMES))
+	for i, angle := range []float32{joints.Z, joints.Y, joints.X, joints.V, joints.W} {
+		position.SetKey(starlark.String(JOINT_NAMES[i]), starlark.Float(angle))
+	}
+	return position, nil
+}
+
+// sleep(seconds) waits without moving the arm.
+func (a *robotAPI) sleep(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	seconds, err := unpackNumbers(fn, args, kwargs, "seconds")
+	if err != nil {
+		return nil, err
+	}
+	if seconds[0] < 0 {
+		return nil, fmt.Errorf("%s: seconds must not be negative, got %g", fn.Name(), seconds[0])
+	}
+
+	timer := time.NewTimer(time.Duration(float64(seconds[0]) * float64(time.Second)))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return starlark.None, nil
+	case <-a.ctx.Done():
+		return nil, builtinError(fn, a.ctx.Err())
+	}
+}
@@ -0,0 +1,99 @@
+package script
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xTaube/vr-controlled-robot-arm/robot"
+)
+
+var testLimits = Limits{Timeout: time.Second, MaxSteps: 10000}
+
+func runTestScript(ctx context.Context, source string, limits Limits) ([]string, error) {
+	output := []string{}
+	err := Run(ctx, nil, nil, &Script{Name: "test", Source: source}, limits, func(line string) {
+		output = append(output, line)
+	})
+	return output, err
+}
+
+func TestCompile(t *testing.T) {
+	if err := Compile(&Script{Name: "square", Source: "for i in range(4):\n    move(i, -90, 0, 0, 0)\nwait_idle()\n"}); err != nil {
+		t.Fatal(err)
+	}
+	for source, want := range map[string]string{
+		`load("other.star", "f")`:         `square.star:1:6: cannot load "other.star"`,
+		"move(0, -90, 0, 0, 0)\nfly()\n":  "square.star:2:1: undefined: fly",
+		"x = 1 +\n":                       "square.star:2:1: got newline",
+		strings.Repeat("#", 1<<16) + "\n": "the limit is 65535",
+	} {
+		err := Compile(&Script{Name: "square", Source: source})
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%.20q: got %v, want %q", source, err, want)
+		}
+	}
+}
+
+func TestRunPrintsAndCitesTheFailingLine(t *testing.T) {
+	output, err := runTestScript(context.Background(), "print('one')\nprint('two')\nsleep(-1)\n", testLimits)
+	if len(output) != 2 || output[0] != "one" || output[1] != "two" {
+		t.Errorf("output = %q", output)
+	}
+	var runErr *RunError
+	if !errors.As(err, &runErr) || runErr.Position != "test.star:3:6" || !strings.Contains(runErr.Message, "must not be negative") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestRunLimits(t *testing.T) {
+	_, err := runTestScript(context.Background(), "while True:\n    pass\n", Limits{Timeout: time.Minute, MaxSteps: 1000})
+	if err == nil || !strings.Contains(err.Error(), "too many steps") {
+		t.Errorf("endless loop: got %v, want the step limit", err)
+	}
+
+	started := time.Now()
+	_, err = runTestScript(context.Background(), "sleep(60)\n", Limits{Timeout: 50 * time.Millisecond, MaxSteps: 1000})
+	var runErr *RunError
+	if !errors.As(err, &runErr) || runErr.Message != "time limit of 50ms exceeded" || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("long sleep: got %v, want the time limit", err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("stopped after %s", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err = runTestScript(ctx, "while True:\n    pass\n", Limits{Timeout: time.Minute, MaxSteps: 1 << 40})
+	if !errors.As(err, &runErr) || runErr.Message != "stopped" || !errors.Is(err, context.Canceled) {
+		t.Errorf("stopped script: got %v", err)
+	}
+}
+
+func TestRunMovesTheArm(t *testing.T) {
+	arm := robot.InitSimulatedRobot(robot.Limits{
+		MinSpeed: 1,
+		MaxSpeed: 100,
+		X:        robot.JointLimits{Min: -180, Max: 180},
+		Y:        robot.JointLimits{Min: -180, Max: 180},
+		Z:        robot.JointLimits{Min: -180, Max: 180},
+		V:        robot.JointLimits{Min: -90, Max: 90},
+		W:        robot.JointLimits{Min: -90, Max: 90},
+	})
+	arm.StartCalibration()
+	arm.FinishCalibration()
+
+	output := []string{}
+	source := "move(1, -90, 0, 10, -20)\nwait_idle()\nposition = get_position()\nprint(position['z'], position['v'], position['w'])\n"
+	err := Run(context.Background(), arm, nil, &Script{Name: "test", Source: source}, Limits{Timeout: 5 * time.Second, MaxSteps: 1000}, func(line string) {
+		output = append(output, line)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(output) != 1 || output[0] != "1.0 10.0 -20.0" {
+		t.Errorf("output = %q", output)
+	}
+}
@@ -0,0 +1,58 @@
+package script
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/xTaube/vr-controlled-robot-arm/storage"
+)
+
+const kind = "script"
+
+const FILE_EXTENSION = ".star"
+
+// Also the longest string a binary frame can carry.
+const MAX_SOURCE_SIZE = 1<<16 - 1
+
+type Script struct {
+	Name   string
+	Source string
+}
+
+// Store keeps one Starlark file per script in a directory, uploading a name again replaces the script.
+type Store struct {
+	dir string
+}
+
+func (s *Store) path(name string) string {
+	return filepath.Join(s.dir, name+FILE_EXTENSION)
+}
+
+func (s *Store) Save(script *Script) error {
+	err := storage.ValidateName(kind, script.Name)
+	if err != nil {
+		return err
+	}
+	return storage.WriteFile(s.path(script.Name), []byte(script.Source), 0644)
+}
+
+func (s *Store) Load(name string) (*Script, error) {
+	err := storage.ValidateName(kind, name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(s.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, &storage.NotFoundError{Kind: kind, Name: name}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read script %q: %w", name, err)
+	}
+	return &Script{Name: name, Source: string(data)}, nil
+}
+
+func InitStore(dir string) *Store {
+	return &Store{dir: dir}
+}
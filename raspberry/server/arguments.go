@@ -0,0 +1,158 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/xTaube/vr-controlled-robot-arm/storage"
+)
+
+type ArgumentType byte
+
+const (
+	ARGUMENT_FLOAT32 ArgumentType = iota
+	ARGUMENT_STRING
+)
+
+func (t ArgumentType) String() string {
+	switch t {
+	case ARGUMENT_FLOAT32:
+		return "float32"
+	case ARGUMENT_STRING:
+		return "string"
+	default:
+		return fmt.Sprintf("ARGUMENT_TYPE_%d", t)
+	}
+}
+
+type ArgumentSpec struct {
+	Name string
+	Type ArgumentType
+	Unit string
+	// Range of a number, any number is accepted when both are 0.
+	Min     float64
+	Max     float64
+	Values  []string
+	Pattern *regexp.Regexp
+}
+
+// Numbers whose range depends on the configuration, like the speed, are left to the robot.
+func (spec ArgumentSpec) bounded() bool {
+	return spec.Min != 0 || spec.Max != 0
+}
+
+type CommandSchema []ArgumentSpec
+
+func (s CommandSchema) Names() []string {
+	names := make([]string, 0, len(s))
+	for _, spec := range s {
+		names = append(names, spec.Name)
+	}
+	return names
+}
+
+// A full turn either way only rules out garbage, the firmware checks no range
+// itself. The configured limits are checked by the robot before every move.
+var jointsAnglesSchema = CommandSchema{
+	{Name: "z", Type: ARGUMENT_FLOAT32, Unit: "deg", Min: -360, Max: 360},
+	{Name: "y", Type: ARGUMENT_FLOAT32, Unit: "deg", Min: -360, Max: 360},
+	{Name: "x", Type: ARGUMENT_FLOAT32, Unit: "deg", Min: -360, Max: 360},
+	{Name: "v", Type: ARGUMENT_FLOAT32, Unit: "deg", Min: -360, Max: 360},
+	{Name: "w", Type: ARGUMENT_FLOAT32, Unit: "deg", Min: -360, Max: 360},
+}
+
+// Programs, poses and scripts are all named the same way.
+var nameSpec = ArgumentSpec{Name: "name", Type: ARGUMENT_STRING, Pattern: storage.NAME_PATTERN}
+
+type ArgumentsCountError struct {
+	command  string
+	expected int
+	got      int
+}
+
+func (err *ArgumentsCountError) Error() string {
+	return fmt.Sprintf("%s expects %d arguments, got %d.", err.command, err.expected, err.got)
+}
+
+type InvalidArgumentError struct {
+	command  string
+	Argument string
+	reason   string
+}
+
+func (err *InvalidArgumentError) Error() string {
+	return fmt.Sprintf("Invalid argument %q of %s: %s.", err.Argument, err.command, err.reason)
+}
+
+type Arguments map[string]any
+
+func (a Arguments) Float32(name string) float32 {
+	value, _ := a[name].(float32)
+	return value
+}
+
+func (a Arguments) String(name string) string {
+	value, _ := a[name].(string)
+	return value
+}
+
+func parseArgument(spec ArgumentSpec, value string) (any, string) {
+	switch spec.Type {
+	case ARGUMENT_FLOAT32:
+		number, err := strconv.ParseFloat(value, 32)
+		if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+			return nil, fmt.Sprintf("%q is not a number", value)
+		}
+		if spec.bounded() && (number < spec.Min || number > spec.Max) {
+			return nil, fmt.Sprintf("%g %s is out of range [%g, %g]", number, spec.Unit, spec.Min, spec.Max)
+		}
+		return float32(number), ""
+	case ARGUMENT_STRING:
+		if len(spec.Values) > 0 && !slices.Contains(spec.Values, value) {
+			return nil, fmt.Sprintf("%q is not one of %s", value, strings.Join(spec.Values, ", "))
+		}
+		if spec.Pattern != nil && !spec.Pattern.MatchString(value) {
+			return nil, fmt.Sprintf("%q does not match %s", value, spec.Pattern)
+		}
+		return value, ""
+	default:
+		return nil, fmt.Sprintf("unsupported argument type %s", spec.Type)
+	}
+}
+
+func (s CommandSchema) Validate(command string, args []string) (Arguments, error) {
+	if len(args) != len(s) {
+		return nil, &ArgumentsCountError{command, len(s), len(args)}
+	}
+
+	arguments := Arguments{}
+	for i, spec := range s {
+		value, reason := parseArgument(spec, args[i])
+		if reason != "" {
+			return nil, &InvalidArgumentError{command, spec.Name, reason}
+		}
+		arguments[spec.Name] = value
+	}
+	return arguments, nil
+}
+
+// Sets call.Args for the middleware and handler that follow.
+func ValidateMiddleware(call *CommandCall, next CommandFunc) Response {
+	args, err := call.Definition.Args.Validate(call.Definition.Name, call.Request.Args)
+	if err != nil {
+		return validationErrorResponse(err)
+	}
+	call.Args = args
+	return next(call)
+}
+
+func validationErrorResponse(err error) Response {
+	if _, ok := err.(*ArgumentsCountError); ok {
+		return &ErrorResponse{Code: RESPONSE_INVALID_PARAMETERS_NUMBER_ERROR, Err: err}
+	}
+	return &ErrorResponse{Code: RESPONSE_INVALID_ARGUMENT_ERROR, Err: err}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCommandSchemaValidate(t *testing.T) {
+	schema := CommandSchema{
+		nameSpec,
+		{Name: "state", Type: ARGUMENT_STRING, Values: []string{"open", "closed"}},
+		{Name: "speed", Type: ARGUMENT_FLOAT32, Unit: "deg/s", Min: 1, Max: 100},
+	}
+
+	args, err := schema.Validate("TEST", []string{"pick-1", "open", "12.5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args.String("name") != "pick-1" || args.String("state") != "open" || args.Float32("speed") != 12.5 {
+		t.Fatalf("got %v", args)
+	}
+
+	for _, test := range []struct {
+		args     []string
+		argument string
+	}{
+		{[]string{"../pick", "open", "1"}, "name"},
+		{[]string{"pick", "ajar", "1"}, "state"},
+		{[]string{"pick", "open", "fast"}, "speed"},
+		{[]string{"pick", "open", "NaN"}, "speed"},
+		{[]string{"pick", "open", "Inf"}, "speed"},
+		{[]string{"pick", "open", "0.5"}, "speed"},
+		{[]string{"pick", "open", "100.5"}, "speed"},
+	} {
+		_, err := schema.Validate("TEST", test.args)
+		var invalid *InvalidArgumentError
+		if !errors.As(err, &invalid) || invalid.Argument != test.argument {
+			t.Errorf("%v: got %v, want %s to be invalid", test.args, err, test.argument)
+		}
+	}
+
+	for _, args := range [][]string{{}, {"pick", "open"}, {"pick", "open", "1", "2"}} {
+		_, err := schema.Validate("TEST", args)
+		var count *ArgumentsCountError
+		if !errors.As(err, &count) || count.expected != 3 || count.got != len(args) {
+			t.Errorf("%v: got %v, want an ArgumentsCountError", args, err)
+		}
+	}
+}
+
+func TestValidationErrorResponseCodes(t *testing.T) {
+	_, err := jointsAnglesSchema.Validate("MOVE_ROBOT", []string{"0", "0", "0", "0"})
+	if response := validationErrorResponse(err).(*ErrorResponse); response.Code != RESPONSE_INVALID_PARAMETERS_NUMBER_ERROR {
+		t.Errorf("missing argument answered with %v", response.Code)
+	}
+	_, err = jointsAnglesSchema.Validate("MOVE_ROBOT", []string{"0", "0", "0", "0", "361"})
+	if response := validationErrorResponse(err).(*ErrorResponse); response.Code != RESPONSE_INVALID_ARGUMENT_ERROR {
+		t.Errorf("out of range argument answered with %v", response.Code)
+	}
+}
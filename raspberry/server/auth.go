@@ -0,0 +1,60 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/xTaube/vr-controlled-robot-arm/auth"
+)
+
+// Used for every session when authentication is disabled.
+var ANONYMOUS_PRINCIPAL = auth.Principal{Name: "anonymous", Role: auth.ROLE_ADMIN}
+
+type ForbiddenCommandError struct {
+	command CommandIdentifier
+	role    auth.Role
+}
+
+func (err *ForbiddenCommandError) Error() string {
+	return fmt.Sprintf("Role %s may not issue %s.", err.role, err.command)
+}
+
+func AuthorizeMiddleware(call *CommandCall, next CommandFunc) Response {
+	role := call.Session.Identity().Principal.Role
+	if !role.Includes(call.Definition.Role) {
+		return &ErrorResponse{Code: RESPONSE_FORBIDDEN_ERROR, Err: &ForbiddenCommandError{call.Command, role}}
+	}
+	return next(call)
+}
+
+// Browsers cannot set headers on websocket or WebTransport requests, so the token may come as a query parameter too.
+func requestToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return r.URL.Query().Get("token")
+}
+
+func (s *ControlServer) authenticate(r *http.Request) (auth.Principal, error) {
+	if s.authenticator == nil {
+		return ANONYMOUS_PRINCIPAL, nil
+	}
+	principal, err := s.authenticator.Authenticate(requestToken(r))
+	if err != nil {
+		return auth.Principal{}, err
+	}
+	return *principal, nil
+}
+
+func (s *ControlServer) authorizeRequest(w http.ResponseWriter, r *http.Request) (auth.Principal, bool) {
+	principal, err := s.authenticate(r)
+	if err != nil {
+		logger.Warn("Rejected control request", "remote", r.RemoteAddr, "error", err)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="v-arm"`)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return auth.Principal{}, false
+	}
+	return principal, true
+}
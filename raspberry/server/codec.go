@@ -0,0 +1,257 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/xTaube/vr-controlled-robot-arm/codec"
+)
+
+var SUPPORTED_SUBPROTOCOLS = []string{codec.BINARY_SUBPROTOCOL, codec.JSON_SUBPROTOCOL, codec.TEXT_SUBPROTOCOL}
+
+type MalformedRequestError struct {
+	reason string
+}
+
+func (err *MalformedRequestError) Error() string {
+	return fmt.Sprintf("Malformed request: %s.", err.reason)
+}
+
+type Request struct {
+	ID      string
+	Command CommandIdentifier
+	Args    []string
+}
+
+type Codec interface {
+	DecodeRequest(data []byte) (*Request, error)
+	EncodeResponse(response Response, requestID string) []byte
+	IsBinary() bool
+}
+
+func CodecForSubprotocol(subprotocol string) Codec {
+	switch subprotocol {
+	case codec.JSON_SUBPROTOCOL:
+		return &JSONCodec{}
+	case codec.BINARY_SUBPROTOCOL:
+		return &BinaryCodec{}
+	default:
+		return &TextCodec{}
+	}
+}
+
+type TextCodec struct{}
+
+func (c *TextCodec) DecodeRequest(data []byte) (*Request, error) {
+	message := codec.DecodeText(data)
+	command, err := parseCommandIdentifier(message.Head)
+	if err != nil {
+		return &Request{ID: message.ID}, err
+	}
+	return &Request{ID: message.ID, Command: command, Args: message.Args}, nil
+}
+
+func (c *TextCodec) EncodeResponse(response Response, requestID string) []byte {
+	data := response.Parse()
+	if requestID == "" {
+		return data
+	}
+
+	message := codec.DecodeText(data)
+	message.ID = requestID
+	return codec.EncodeText(message)
+}
+
+func (c *TextCodec) IsBinary() bool {
+	return false
+}
+
+type JSONCodec struct{}
+
+type jsonRequest struct {
+	ID      json.RawMessage `json:"id"`
+	Command json.RawMessage `json:"command"`
+	Params  map[string]any  `json:"params"`
+	Args    []any           `json:"args"`
+}
+
+func jsonValueToArgument(value any) (string, error) {
+	switch value := value.(type) {
+	case json.Number:
+		return value.String(), nil
+	case string:
+		return value, nil
+	case bool:
+		if value {
+			return "1", nil
+		}
+		return "0", nil
+	default:
+		return "", fmt.Errorf("unsupported value %v, only numbers, strings and booleans are allowed", value)
+	}
+}
+
+func decodeJSONCommand(raw json.RawMessage) (CommandIdentifier, error) {
+	var number json.Number
+	err := json.Unmarshal(raw, &number)
+	if err == nil {
+		command, err := strconv.ParseUint(number.String(), 10, 8)
+		if err != nil {
+			return 0, &MalformedRequestError{fmt.Sprintf("invalid command identifier %s", number)}
+		}
+		return CommandIdentifier(command), nil
+	}
+
+	var name string
+	err = json.Unmarshal(raw, &name)
+	if err != nil {
+		return 0, &MalformedRequestError{"command must be a number or a command name"}
+	}
+	command, ok := CommandIdentifierByName(name)
+	if !ok {
+		return 0, &MalformedRequestError{fmt.Sprintf("unknown command name %q", name)}
+	}
+	return command, nil
+}
+
+func (c *JSONCodec) DecodeRequest(data []byte) (*Request, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	decoder.DisallowUnknownFields()
+
+	envelope := jsonRequest{}
+	err := decoder.Decode(&envelope)
+	if err != nil {
+		return nil, &MalformedRequestError{err.Error()}
+	}
+
+	request := &Request{}
+	if envelope.ID != nil {
+		if envelope.ID[0] != '"' && (envelope.ID[0] < '0' || envelope.ID[0] > '9') {
+			return nil, &MalformedRequestError{"id must be a string or a non-negative number"}
+		}
+		request.ID = string(envelope.ID)
+	}
+
+	if envelope.Command == nil {
+		return request, &MalformedRequestError{"missing command"}
+	}
+	if envelope.Params != nil && envelope.Args != nil {
+		return request, &MalformedRequestError{"use either params or args, not both"}
+	}
+
+	request.Command, err = decodeJSONCommand(envelope.Command)
+	if err != nil {
+		return request, err
+	}
+	command := request.Command
+
+	args := []string{}
+	for i, value := range envelope.Args {
+		arg, err := jsonValueToArgument(value)
+		if err != nil {
+			return request, &MalformedRequestError{fmt.Sprintf("args[%d]: %s", i, err)}
+		}
+		args = append(args, arg)
+	}
+
+	if envelope.Params != nil {
+		args, err = jsonParamsToArguments(command, envelope.Params)
+		if err != nil {
+			return request, err
+		}
+	}
+
+	request.Args = args
+	return request, nil
+}
+
+// Named params are put in the positional order of the command schema.
+func jsonParamsToArguments(command CommandIdentifier, params map[string]any) ([]string, error) {
+	var names []string
+	if definition, ok := COMMAND_REGISTRY[command]; ok {
+		names = definition.Args.Names()
+	}
+	if len(names) == 0 && len(params) > 0 {
+		return nil, &MalformedRequestError{fmt.Sprintf("command %s takes no params", command)}
+	}
+
+	args := []string{}
+	for _, name := range names {
+		value, ok := params[name]
+		if !ok {
+			return nil, &MalformedRequestError{fmt.Sprintf("missing param %q", name)}
+		}
+		arg, err := jsonValueToArgument(value)
+		if err != nil {
+			return nil, &MalformedRequestError{fmt.Sprintf("param %q: %s", name, err)}
+		}
+		args = append(args, arg)
+	}
+	for name := range params {
+		if !slices.Contains(names, name) {
+			return nil, &MalformedRequestError{fmt.Sprintf("unknown param %q", name)}
+		}
+	}
+	return args, nil
+}
+
+func (c *JSONCodec) EncodeResponse(response Response, requestID string) []byte {
+	data := response.ParseJSON()
+	if requestID == "" {
+		return data
+	}
+	return append([]byte(`{"id":`+requestID+`,`), data[1:]...)
+}
+
+func (c *JSONCodec) IsBinary() bool {
+	return false
+}
+
+type BinaryCodec struct{}
+
+func (c *BinaryCodec) DecodeRequest(data []byte) (*Request, error) {
+	frame := codec.Frame{}
+	err := frame.UnmarshalBinary(data)
+	if err != nil {
+		return nil, &MalformedRequestError{err.Error()}
+	}
+
+	request := &Request{Command: CommandIdentifier(frame.Code)}
+	if frame.ID != 0 {
+		request.ID = strconv.FormatUint(uint64(frame.ID), 10)
+	}
+	if frame.Type != codec.FRAME_REQUEST {
+		return request, &MalformedRequestError{fmt.Sprintf("unexpected frame type %d", frame.Type)}
+	}
+
+	request.Args = make([]string, 0, len(frame.Floats)+len(frame.Strings))
+	for _, value := range frame.Floats {
+		request.Args = append(request.Args, strconv.FormatFloat(float64(value), 'g', -1, 32))
+	}
+	request.Args = append(request.Args, frame.Strings...)
+	return request, nil
+}
+
+func (c *BinaryCodec) EncodeResponse(response Response, requestID string) []byte {
+	frame := response.ParseBinary()
+	if requestID != "" {
+		id, _ := strconv.ParseUint(requestID, 10, 32)
+		frame.ID = uint32(id)
+	}
+
+	data, err := frame.MarshalBinary()
+	if err != nil {
+		logger.Error("Cannot encode binary response", "error", err)
+		fallback := codec.Frame{Type: codec.FRAME_RESPONSE, Code: byte(RESPONSE_UNKNOWN_ERROR), ID: frame.ID}
+		data, _ = fallback.MarshalBinary()
+	}
+	return data
+}
+
+func (c *BinaryCodec) IsBinary() bool {
+	return true
+}
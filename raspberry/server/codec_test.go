@@ -0,0 +1,200 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xTaube/vr-controlled-robot-arm/codec"
+	"github.com/xTaube/vr-controlled-robot-arm/robot"
+)
+
+var testJoints = robot.JointsAngles{Z: 12.5, Y: -90, X: 33.25, V: -4.5, W: 71}
+
+// The same MOVE_ROBOT request in every subprotocol.
+func encodedMoveRequests(t testing.TB) map[string][]byte {
+	frame := codec.Frame{Type: codec.FRAME_REQUEST, Code: byte(MOVE_ROBOT), ID: 42, Floats: []float32{12.5, -90, 33.25, -4.5, 71}}
+	binary, err := frame.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string][]byte{
+		codec.TEXT_SUBPROTOCOL:   []byte(fmt.Sprintf("%d#42$12.5$-90$33.25$-4.5$71", MOVE_ROBOT)),
+		codec.JSON_SUBPROTOCOL:   []byte(`{"id":42,"command":"MOVE_ROBOT","params":{"w":71,"v":-4.5,"x":33.25,"y":-90,"z":12.5}}`),
+		codec.BINARY_SUBPROTOCOL: binary,
+	}
+}
+
+func TestCodecsDecodeTheSameRequest(t *testing.T) {
+	want := &Request{ID: "42", Command: MOVE_ROBOT, Args: []string{"12.5", "-90", "33.25", "-4.5", "71"}}
+	for subprotocol, data := range encodedMoveRequests(t) {
+		request, err := CodecForSubprotocol(subprotocol).DecodeRequest(data)
+		if err != nil {
+			t.Errorf("%s: %v", subprotocol, err)
+			continue
+		}
+		if !reflect.DeepEqual(request, want) {
+			t.Errorf("%s: got %+v, want %+v", subprotocol, request, want)
+		}
+	}
+}
+
+func TestCodecsEncodeResponses(t *testing.T) {
+	response := &JointsAnglesResponse{Code: RESPONSE_OK, Joints: testJoints}
+
+	text := codec.DecodeText(CodecForSubprotocol(codec.TEXT_SUBPROTOCOL).EncodeResponse(response, "42"))
+	want := codec.TextMessage{Head: "0", ID: "42", Args: []string{"12.500000", "-90.000000", "33.250000", "-4.500000", "71.000000"}}
+	if !reflect.DeepEqual(text, want) {
+		t.Errorf("text: got %+v, want %+v", text, want)
+	}
+
+	var decoded struct {
+		ID   int                `json:"id"`
+		Code int                `json:"code"`
+		Data map[string]float32 `json:"data"`
+	}
+	err := json.Unmarshal(CodecForSubprotocol(codec.JSON_SUBPROTOCOL).EncodeResponse(response, "42"), &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.ID != 42 || decoded.Code != 0 || decoded.Data["x"] != testJoints.X || decoded.Data["w"] != testJoints.W {
+		t.Errorf("json: got %+v", decoded)
+	}
+
+	frame := codec.Frame{}
+	err = frame.UnmarshalBinary(CodecForSubprotocol(codec.BINARY_SUBPROTOCOL).EncodeResponse(response, "42"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.ID != 42 || frame.Type != codec.FRAME_RESPONSE || !reflect.DeepEqual(frame.Floats, []float32{12.5, -90, 33.25, -4.5, 71}) {
+		t.Errorf("binary: got %+v", frame)
+	}
+}
+
+func TestJSONCodecRefusesMalformedRequests(t *testing.T) {
+	for _, data := range []string{
+		`{"id":1}`,
+		`{"command":"NO_SUCH_COMMAND"}`,
+		`{"command":256}`,
+		`{"command":"MOVE_ROBOT","args":[1,2,3,4,5],"params":{}}`,
+		`{"command":"MOVE_ROBOT","params":{"z":1}}`,
+		`{"command":"MOVE_ROBOT","params":{"z":1,"y":2,"x":3,"v":4,"w":5,"u":6}}`,
+		`{"command":"MOVE_ROBOT","args":[[1]]}`,
+		`{"command":"MOVE_ROBOT","extra":true}`,
+		`{"id":{},"command":"MOVE_ROBOT"}`,
+	} {
+		_, err := CodecForSubprotocol(codec.JSON_SUBPROTOCOL).DecodeRequest([]byte(data))
+		var malformed *MalformedRequestError
+		if !errors.As(err, &malformed) {
+			t.Errorf("%s: got %v, want a MalformedRequestError", data, err)
+		}
+	}
+}
+
+func TestBinaryCodecRefusesOtherFrameTypes(t *testing.T) {
+	data, _ := (&codec.Frame{Type: codec.FRAME_EVENT, Code: byte(MOVE_ROBOT)}).MarshalBinary()
+	_, err := CodecForSubprotocol(codec.BINARY_SUBPROTOCOL).DecodeRequest(data)
+	if err == nil || !strings.Contains(err.Error(), "unexpected frame type") {
+		t.Errorf("got %v", err)
+	}
+}
+
+// Calibration commands once shared ids with START_VIDEO_STREAM, STOP_VIDEO_STREAM and MOVE_ROBOT.
+func TestCalibrationCommandsHaveTheirOwnIds(t *testing.T) {
+	for _, name := range []string{"CALIBRATION_CONFIRM", "CALIBRATION_ABORT", "CALIBRATION_MOVE"} {
+		command, ok := CommandIdentifierByName(name)
+		if !ok {
+			t.Fatalf("%s is unknown", name)
+		}
+		if command.String() != name {
+			t.Errorf("%s resolves to %s", name, command)
+		}
+	}
+
+	request, err := CodecForSubprotocol(codec.JSON_SUBPROTOCOL).DecodeRequest([]byte(`{"command":"CALIBRATION_MOVE","params":{"z":1,"y":2,"x":3,"v":4,"w":5}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.Command != CALIBRATION_MOVE || !reflect.DeepEqual(request.Args, []string{"1", "2", "3", "4", "5"}) {
+		t.Errorf("got %+v", request)
+	}
+}
+
+func TestPromptIsWordedPerProtocol(t *testing.T) {
+	prompt := &PromptResponse{
+		Code:    RESPONSE_OK,
+		Message: "You're calibrating XYZ axis.",
+		Actions: []PromptAction{{CALIBRATION_CONFIRM, "confirm"}, {CALIBRATION_MOVE, "move"}},
+	}
+
+	text := string(CodecForSubprotocol(codec.TEXT_SUBPROTOCOL).EncodeResponse(prompt, "7"))
+	// Text clients keep the ids they sent before the calibration commands had their own.
+	want := "0#7$You're calibrating XYZ axis. Send '1' to confirm, '3${z}${y}${x}${v}${w}' to move."
+	if text != want {
+		t.Errorf("text: got %q, want %q", text, want)
+	}
+
+	var decoded struct {
+		Type string `json:"type"`
+		Data struct {
+			Prompt  string `json:"prompt"`
+			Actions []struct {
+				Command string   `json:"command"`
+				Action  string   `json:"action"`
+				Params  []string `json:"params"`
+			} `json:"actions"`
+		} `json:"data"`
+	}
+	err := json.Unmarshal(CodecForSubprotocol(codec.JSON_SUBPROTOCOL).EncodeResponse(prompt, "7"), &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = `You're calibrating XYZ axis. Send "CALIBRATION_CONFIRM" to confirm, "CALIBRATION_MOVE" with params z, y, x, v, w to move.`
+	if decoded.Type != "prompt" || decoded.Data.Prompt != want {
+		t.Errorf("json: got %+v", decoded)
+	}
+	if len(decoded.Data.Actions) != 2 || decoded.Data.Actions[1].Command != "CALIBRATION_MOVE" || len(decoded.Data.Actions[1].Params) != 5 {
+		t.Errorf("json actions: got %+v", decoded.Data.Actions)
+	}
+
+	frame := codec.Frame{}
+	err = frame.UnmarshalBinary(CodecForSubprotocol(codec.BINARY_SUBPROTOCOL).EncodeResponse(prompt, "7"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = fmt.Sprintf("You're calibrating XYZ axis. Send code %d to confirm, code %d with floats z, y, x, v, w to move.", CALIBRATION_CONFIRM, CALIBRATION_MOVE)
+	if frame.Type != codec.FRAME_PROMPT || frame.ID != 7 || !reflect.DeepEqual(frame.Strings, []string{want}) {
+		t.Errorf("binary: got %+v", frame)
+	}
+}
+
+func BenchmarkDecodeRequest(b *testing.B) {
+	requests := encodedMoveRequests(b)
+	for _, subprotocol := range SUPPORTED_SUBPROTOCOLS {
+		serverCodec, data := CodecForSubprotocol(subprotocol), requests[subprotocol]
+		b.Run(subprotocol, func(b *testing.B) {
+			b.ReportAllocs()
+			b.ReportMetric(float64(len(data)), "B/msg")
+			for range b.N {
+				serverCodec.DecodeRequest(data)
+			}
+		})
+	}
+}
+
+func BenchmarkEncodeResponse(b *testing.B) {
+	response := &JointsAnglesResponse{Code: RESPONSE_OK, Joints: testJoints}
+	for _, subprotocol := range SUPPORTED_SUBPROTOCOLS {
+		serverCodec := CodecForSubprotocol(subprotocol)
+		b.Run(subprotocol, func(b *testing.B) {
+			b.ReportAllocs()
+			b.ReportMetric(float64(len(serverCodec.EncodeResponse(response, "42"))), "B/msg")
+			for range b.N {
+				serverCodec.EncodeResponse(response, "42")
+			}
+		})
+	}
+}
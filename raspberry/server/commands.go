@@ -1,10 +1,16 @@
 package server
 
 import (
+	"context"
 	"fmt"
-	"log"
-	"strings"
+	"log/slog"
+	"strconv"
+	"sync"
+	"sync/atomic"
 
+	"github.com/xTaube/vr-controlled-robot-arm/logging"
+	"github.com/xTaube/vr-controlled-robot-arm/pose"
+	"github.com/xTaube/vr-controlled-robot-arm/program"
 	"github.com/xTaube/vr-controlled-robot-arm/robot"
 	"github.com/xTaube/vr-controlled-robot-arm/video"
 )
@@ -19,145 +25,261 @@ func (err *CommandNotFound) Error() string {
 	return fmt.Sprintf("Command with identifier: %d not found.", err.code)
 }
 
-const (
-	START_VIDEO_STREAM CommandIdentifier = iota + 1
-	STOP_VIDEO_STREAM
-	MOVE_ROBOT
-	SET_ROBOT_SPEED
-	GET_ROBOT_CURRENT_POSITION
-	CALIBRATE_ROBOT
-	OPEN_GRIPPER
-	CLOSE_GRIPPER
-)
+type WorkflowCommandError struct {
+	command  CommandIdentifier
+	workflow CommandIdentifier
+}
+
+func (err *WorkflowCommandError) Error() string {
+	return fmt.Sprintf("%s is only accepted during %s.", err.command, err.workflow)
+}
+
+func (c CommandIdentifier) String() string {
+	definition, ok := COMMAND_REGISTRY[c]
+	if !ok {
+		return fmt.Sprintf("COMMAND_%d", c)
+	}
+	return definition.Name
+}
+
+func CommandIdentifierByName(name string) (CommandIdentifier, bool) {
+	for command, definition := range COMMAND_REGISTRY {
+		if definition.Name == name {
+			return command, true
+		}
+	}
+	return 0, false
+}
 
 type CommandHandler struct {
-	video0                   *video.VideoStream
-	video1                   *video.VideoStream
+	session                  Session
+	videos                   []*video.VideoStream
 	robot                    *robot.Robot
 	robotCalibrationWorkflow *RobotCalibrationWorkflow
+	events                   *EventBus
+	subscription             *Subscription
+	lease                    *ControlLease
+	programs                 *ProgramRunner
+	scripts                  *ScriptRunner
+	poses                    *pose.Library
+	// Program being taught in this session, nil outside TEACH_START and TEACH_SAVE.
+	teaching *program.Program
+	handled  atomic.Uint64
+	pipeline CommandFunc
+	// Held by datagram moves while they run and by CALIBRATE_ROBOT while it starts and ends,
+	// so no datagram move reaches the robot during the workflow.
+	workflowMu sync.Mutex
+	inWorkflow bool
 }
 
-func (ch *CommandHandler) Handle(command_id CommandIdentifier, args []string) Response {
-	log.Printf("Incoming command identitfier: %d\n", command_id)
-	switch command_id {
-	case START_VIDEO_STREAM:
-		return ch.startVideoStreamCommandHandler()
-
-	case STOP_VIDEO_STREAM:
-		return ch.stopVideoStreamCommandHandler()
+// Every line logged while handling a command carries the session, the command
+// and a sequence number within the session, plus the client's request id if it sent one.
+func (ch *CommandHandler) commandLogContext(request *Request) context.Context {
+	attrs := []slog.Attr{
+		slog.String("command", request.Command.String()),
+		slog.String("command_id", strconv.FormatUint(ch.handled.Add(1), 10)),
+	}
+	if request.ID != "" {
+		attrs = append(attrs, slog.String("request_id", request.ID))
+	}
+	return logging.WithAttrs(ch.session.Context(), attrs...)
+}
 
-	case MOVE_ROBOT:
-		return ch.moveArmCommandHandler(args)
+// Handle runs a request through the middleware chain, the command's handler comes last.
+func (ch *CommandHandler) Handle(request *Request) Response {
+	ch.lease.Touch(ch.session.Identity().ID)
+	return ch.pipeline(&CommandCall{
+		Context: ch.commandLogContext(request),
+		Session: ch.session,
+		Handler: ch,
+		Command: request.Command,
+		Request: request,
+	})
+}
 
-	case SET_ROBOT_SPEED:
-		return ch.setRobotSpeedCommandHandler(args)
+// OutsideWorkflow runs handle unless a workflow such as CALIBRATE_ROBOT is
+// running, then it returns false. No workflow starts while handle runs.
+func (ch *CommandHandler) OutsideWorkflow(handle func()) bool {
+	ch.workflowMu.Lock()
+	defer ch.workflowMu.Unlock()
+	if ch.inWorkflow {
+		return false
+	}
+	handle()
+	return true
+}
 
-	case GET_ROBOT_CURRENT_POSITION:
-		return ch.getRobotCurrentPositionCommandHandler()
+func (ch *CommandHandler) setInWorkflow(inWorkflow bool) {
+	ch.workflowMu.Lock()
+	defer ch.workflowMu.Unlock()
+	ch.inWorkflow = inWorkflow
+}
 
-	case CALIBRATE_ROBOT:
-		return ch.calibrateRobotCommandHandler()
+func (ch *CommandHandler) dispatch(call *CommandCall) Response {
+	return call.Definition.Handle(ch, call)
+}
 
-	case OPEN_GRIPPER:
-		return ch.openGripperCommandHandler()
+func jointsAnglesFromArguments(args Arguments) robot.JointsAngles {
+	return robot.JointsAngles{
+		Z: args.Float32("z"),
+		Y: args.Float32("y"),
+		X: args.Float32("x"),
+		V: args.Float32("v"),
+		W: args.Float32("w"),
+	}
+}
 
-	case CLOSE_GRIPPER:
-		return ch.closeGripperCommandHandler()
+func (ch *CommandHandler) moveArmCommandHandler(ctx context.Context, command_args Arguments) Response {
+	joints := jointsAnglesFromArguments(command_args)
+	logger.DebugContext(ctx, "Moving robot", "z", joints.Z, "y", joints.Y, "x", joints.X, "v", joints.V, "w", joints.W)
+	result, err := ch.robot.Move(joints)
+	if err != nil {
+		return ch.robotErrorResponse(err)
+	}
+	return &JointsAnglesResponse{Code: RESPONSE_OK, Joints: *result}
+}
 
-	default:
-		return &ErrorResponse{Code: RESPONSE_UNKNOWN_COMMAND_ERROR, Err: &CommandNotFound{command_id}}
+func (ch *CommandHandler) startVideoStreamCommandHandler(ctx context.Context) Response {
+	rtspServerAddresses := make([]string, 0, len(ch.videos))
+	for _, videoStream := range ch.videos {
+		rtspServerAddress, err := videoStream.Start()
+		if err != nil {
+			logger.ErrorContext(ctx, "Cannot start video stream", "device", videoStream.Device(), "error", err)
+			ch.events.PublishFault("video", err)
+			return &ErrorResponse{Code: RESPONSE_STREAM_ERROR, Err: err}
+		}
+		rtspServerAddresses = append(rtspServerAddresses, rtspServerAddress)
 	}
+
+	logger.InfoContext(ctx, "Video streams started", "addresses", rtspServerAddresses)
+	ch.events.Publish(&Event{Topic: TOPIC_VIDEO, Name: "started", Details: rtspServerAddresses})
+	return &StreamsResponse{Code: RESPONSE_OK, Addresses: rtspServerAddresses}
 }
 
-func (ch *CommandHandler) moveArmCommandHandler(command_args []string) Response {
-	if len(command_args) < 5 {
-		return &ErrorResponse{Code: RESPONSE_INVALID_PARAMETERS_NUMBER_ERROR}
+func (ch *CommandHandler) stopVideoStreamCommandHandler(ctx context.Context) Response {
+	for _, videoStream := range ch.videos {
+		err := videoStream.Stop()
+		if err != nil {
+			logger.ErrorContext(ctx, "Cannot stop video stream", "device", videoStream.Device(), "error", err)
+			ch.events.PublishFault("video", err)
+			return &ErrorResponse{Code: RESPONSE_STREAM_ERROR, Err: err}
+		}
 	}
-	log.Printf("Attempt to move robot by translation: [%s].\n", strings.Join(command_args, ", "))
-	result, err := ch.robot.Move(
-		robot.JointsAngles{
-			Z: readFloat32(command_args[0]),
-			Y: readFloat32(command_args[1]),
-			X: readFloat32(command_args[2]),
-			V: readFloat32(command_args[3]),
-			W: readFloat32(command_args[4]),
-		},
-	)
+
+	logger.InfoContext(ctx, "Video streams stopped")
+	ch.events.Publish(&Event{Topic: TOPIC_VIDEO, Name: "stopped"})
+	return &BaseResponse{Code: RESPONSE_OK}
+}
+
+func (ch *CommandHandler) setRobotSpeedCommandHandler(ctx context.Context, command_args Arguments) Response {
+	speed := command_args.Float32("speed")
+	err := ch.robot.SetSpeed(speed)
 	if err != nil {
-		return &ErrorResponse{Code: RESPONSE_ROBOT_CANNOT_EXECUTE_COMMAND_ERROR, Err: err}
-	}
-	log.Println("Attempt finished.")
-	return &ResponseWithFloat32Arguments{
-		Code: RESPONSE_OK,
-		Args: []float32{result.Z, result.Y, result.X, result.V, result.W},
+		return ch.robotErrorResponse(err)
 	}
+
+	logger.InfoContext(ctx, "Robot speed set", "speed", speed)
+	return &BaseResponse{Code: RESPONSE_OK}
 }
 
-func (ch *CommandHandler) startVideoStreamCommandHandler() Response {
-	log.Println("Turning stream on...")
-	rtspServerAddress0, err := ch.video0.Start()
+func (ch *CommandHandler) getRobotCurrentPositionCommandHandler() Response {
+	currentPosition, err := ch.robot.GetCurrentPosition()
 	if err != nil {
-		log.Printf("Error occured during turning stream 0 on: %s\n", err)
-		return &ErrorResponse{Code: RESPONSE_STREAM_ERROR, Err: err}
+		return ch.robotErrorResponse(err)
 	}
+	return &JointsAnglesResponse{Code: RESPONSE_OK, Joints: *currentPosition}
+}
 
-	rtspServerAddress1, err := ch.video1.Start()
+// Positions are only recorded once the arm stopped, not halfway to a target.
+func (ch *CommandHandler) stoppedPosition() (*robot.JointsAngles, Response) {
+	moving, err := ch.robot.IsMoving()
 	if err != nil {
-		log.Printf("Error occured during turning stream 1 on: %s\n", err)
-		return &ErrorResponse{Code: RESPONSE_STREAM_ERROR, Err: err}
+		return nil, ch.robotErrorResponse(err)
 	}
+	if moving {
+		return nil, &ErrorResponse{Code: RESPONSE_ROBOT_CANNOT_EXECUTE_COMMAND_ERROR, Err: &robot.RobotError{Code: robot.ROBOT_IS_IN_MOVE_ERROR}}
+	}
+	position, err := ch.robot.GetCurrentPosition()
+	if err != nil {
+		return nil, ch.robotErrorResponse(err)
+	}
+	return position, nil
+}
+
+func (ch *CommandHandler) robotErrorResponse(err error) Response {
+	ch.events.PublishFault("robot", err)
+	return &ErrorResponse{Code: RESPONSE_ROBOT_CANNOT_EXECUTE_COMMAND_ERROR, Err: err}
+}
+
+func (ch *CommandHandler) subscribeCommandHandler(command_args Arguments) Response {
+	topic, _ := EventTopicByName(command_args.String("topic"))
+	ch.subscription.Add(topic)
+	return &ResponseWithStringArguments{Code: RESPONSE_OK, Args: ch.subscription.Topics()}
+}
 
-	log.Printf("Streaming to %s, %s.\n", rtspServerAddress0, rtspServerAddress1)
-	return &ResponseWithStringArguments{RESPONSE_OK, []string{rtspServerAddress0, rtspServerAddress1}}
+func (ch *CommandHandler) unsubscribeCommandHandler(command_args Arguments) Response {
+	topic, _ := EventTopicByName(command_args.String("topic"))
+	ch.subscription.Remove(topic)
+	return &ResponseWithStringArguments{Code: RESPONSE_OK, Args: ch.subscription.Topics()}
 }
 
-func (ch *CommandHandler) stopVideoStreamCommandHandler() Response {
-	log.Println("Shutting off the stream...")
-	err := ch.video0.Stop()
+func (ch *CommandHandler) acquireControlCommandHandler() Response {
+	session_id := ch.session.Identity().ID
+	err := ch.lease.Acquire(session_id)
 	if err != nil {
-		log.Printf("Error occured during turning stream 0 off: %s\n", err)
-		return &ErrorResponse{Code: RESPONSE_STREAM_ERROR, Err: err}
+		return &ErrorResponse{Code: RESPONSE_CONTROL_LEASE_ERROR, Err: err}
 	}
+	return &ResponseWithStringArguments{Code: RESPONSE_OK, Args: []string{session_id}}
+}
 
-	err = ch.video1.Stop()
+func (ch *CommandHandler) releaseControlCommandHandler() Response {
+	err := ch.lease.Release(ch.session.Identity().ID)
 	if err != nil {
-		log.Printf("Error occured during turning stream 1 off: %s\n", err)
-		return &ErrorResponse{Code: RESPONSE_STREAM_ERROR, Err: err}
+		return &ErrorResponse{Code: RESPONSE_CONTROL_LEASE_ERROR, Err: err}
 	}
-
-	log.Println("Stream stopped.")
 	return &BaseResponse{Code: RESPONSE_OK}
 }
 
-func (ch *CommandHandler) setRobotSpeedCommandHandler(command_args []string) Response {
-	if len(command_args) < 1 {
-		return &ErrorResponse{Code: RESPONSE_INVALID_PARAMETERS_NUMBER_ERROR}
-	}
-	log.Printf("Attempt to set new robot speed: [%s].\n", strings.Join(command_args, ", "))
-	err := ch.robot.SetSpeed(readFloat32(command_args[0]))
+func (ch *CommandHandler) handoverControlCommandHandler(command_args Arguments) Response {
+	to := command_args.String("session")
+	err := ch.lease.Handover(ch.session.Identity().ID, to)
 	if err != nil {
-		return &ErrorResponse{Code: RESPONSE_ROBOT_CANNOT_EXECUTE_COMMAND_ERROR, Err: err}
+		return &ErrorResponse{Code: RESPONSE_CONTROL_LEASE_ERROR, Err: err}
 	}
-
-	log.Println("Attempt finished.")
-	return &BaseResponse{Code: RESPONSE_OK}
+	return &ResponseWithStringArguments{Code: RESPONSE_OK, Args: []string{to}}
 }
 
-func (ch *CommandHandler) getRobotCurrentPositionCommandHandler() Response {
-	log.Println("Attempt to get current robot position.")
-	currentPosition, err := ch.robot.GetCurrentPosition()
+func (ch *CommandHandler) heartbeatCommandHandler() Response {
+	err := ch.lease.Heartbeat(ch.session.Identity().ID)
 	if err != nil {
-		return &ErrorResponse{Code: RESPONSE_ROBOT_CANNOT_EXECUTE_COMMAND_ERROR, Err: err}
+		return &ErrorResponse{Code: RESPONSE_CONTROL_LEASE_ERROR, Err: err}
 	}
-	log.Println("Attempt finished.")
-	return &ResponseWithFloat32Arguments{
-		Code: RESPONSE_OK,
-		Args: []float32{currentPosition.Z, currentPosition.Y, currentPosition.X, currentPosition.V, currentPosition.W},
+	return &BaseResponse{Code: RESPONSE_OK}
+}
+
+func (ch *CommandHandler) getControlCommandHandler() Response {
+	return &ResponseWithStringArguments{Code: RESPONSE_OK, Args: []string{ch.session.Identity().ID, ch.lease.Holder()}}
+}
+
+func (ch *CommandHandler) getVideoStreamsCommandHandler() Response {
+	rtspServerAddresses := []string{}
+	for _, videoStream := range ch.videos {
+		rtspServerAddress, ok := videoStream.Address()
+		if ok {
+			rtspServerAddresses = append(rtspServerAddresses, rtspServerAddress)
+		}
 	}
+	return &StreamsResponse{Code: RESPONSE_OK, Addresses: rtspServerAddresses}
 }
 
-func (ch *CommandHandler) calibrateRobotCommandHandler() Response {
-	err := ch.robotCalibrationWorkflow.Start()
+func (ch *CommandHandler) calibrateRobotCommandHandler(request *Request) Response {
+	session_id := ch.session.Identity().ID
+	ch.lease.Pin(session_id)
+	defer ch.lease.Unpin(session_id)
+	ch.setInWorkflow(true)
+	defer ch.setInWorkflow(false)
+
+	err := ch.robotCalibrationWorkflow.Start(request)
 	if err != nil {
 		return &ErrorResponse{Code: RESPONSE_ROBOT_CALIBRATION_ERROR, Err: err}
 	}
@@ -167,7 +289,7 @@ func (ch *CommandHandler) calibrateRobotCommandHandler() Response {
 func (ch *CommandHandler) openGripperCommandHandler() Response {
 	err := ch.robot.OpenGripper()
 	if err != nil {
-		return &ErrorResponse{Code: RESPONSE_ROBOT_CANNOT_EXECUTE_COMMAND_ERROR, Err: err}
+		return ch.robotErrorResponse(err)
 	}
 	return &BaseResponse{Code: RESPONSE_OK}
 }
@@ -175,21 +297,36 @@ func (ch *CommandHandler) openGripperCommandHandler() Response {
 func (ch *CommandHandler) closeGripperCommandHandler() Response {
 	err := ch.robot.CloseGripper()
 	if err != nil {
-		return &ErrorResponse{Code: RESPONSE_ROBOT_CANNOT_EXECUTE_COMMAND_ERROR, Err: err}
+		return ch.robotErrorResponse(err)
 	}
 	return &BaseResponse{Code: RESPONSE_OK}
 }
 
 func InitCommandHandler(
-	video0 *video.VideoStream,
-	video1 *video.VideoStream,
+	session Session,
+	videos []*video.VideoStream,
 	robot *robot.Robot,
 	robotCalibrationWorkflow *RobotCalibrationWorkflow,
+	events *EventBus,
+	subscription *Subscription,
+	lease *ControlLease,
+	programs *ProgramRunner,
+	scripts *ScriptRunner,
+	poses *pose.Library,
+	middlewares []Middleware,
 ) *CommandHandler {
-	return &CommandHandler{
-		video0:                   video0,
-		video1:                   video1,
+	ch := &CommandHandler{
+		session:                  session,
+		videos:                   videos,
 		robot:                    robot,
 		robotCalibrationWorkflow: robotCalibrationWorkflow,
+		events:                   events,
+		subscription:             subscription,
+		lease:                    lease,
+		programs:                 programs,
+		scripts:                  scripts,
+		poses:                    poses,
 	}
+	ch.pipeline = chainMiddleware(ch.dispatch, middlewares)
+	return ch
 }
@@ -0,0 +1,241 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xTaube/vr-controlled-robot-arm/codec"
+	"github.com/xTaube/vr-controlled-robot-arm/robot"
+)
+
+type EventTopic byte
+
+const (
+	TOPIC_POSITION EventTopic = iota + 1
+	TOPIC_MOTION
+	TOPIC_CALIBRATION
+	TOPIC_VIDEO
+	TOPIC_FAULTS
+	TOPIC_CONTROL
+	TOPIC_SERVER
+	TOPIC_PROGRAM
+	TOPIC_POSES
+	TOPIC_SCRIPT
+)
+
+var EVENT_TOPIC_NAMES = map[EventTopic]string{
+	TOPIC_POSITION:    "position",
+	TOPIC_MOTION:      "motion",
+	TOPIC_CALIBRATION: "calibration",
+	TOPIC_VIDEO:       "video",
+	TOPIC_FAULTS:      "faults",
+	TOPIC_CONTROL:     "control",
+	TOPIC_SERVER:      "server",
+	TOPIC_PROGRAM:     "program",
+	TOPIC_POSES:       "poses",
+	TOPIC_SCRIPT:      "script",
+}
+
+const ROBOT_MONITOR_INTERVAL = 100 * time.Millisecond
+
+func (t EventTopic) String() string {
+	name, ok := EVENT_TOPIC_NAMES[t]
+	if !ok {
+		return fmt.Sprintf("TOPIC_%d", t)
+	}
+	return name
+}
+
+func EventTopicByName(name string) (EventTopic, bool) {
+	for topic, topicName := range EVENT_TOPIC_NAMES {
+		if topicName == name {
+			return topic, true
+		}
+	}
+	return 0, false
+}
+
+func eventTopicNames() []string {
+	names := make([]string, 0, len(EVENT_TOPIC_NAMES))
+	for _, name := range EVENT_TOPIC_NAMES {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type Event struct {
+	Topic   EventTopic
+	Name    string
+	Joints  *robot.JointsAngles
+	Details []string
+}
+
+type jsonEvent struct {
+	Type    string             `json:"type"`
+	Topic   string             `json:"topic"`
+	Event   string             `json:"event"`
+	Joints  map[string]float32 `json:"joints,omitempty"`
+	Details []string           `json:"details,omitempty"`
+}
+
+// Text events are "E$topic$name" followed by the Z,Y,X,V,W joints if any and the details.
+func (e *Event) Parse() []byte {
+	fields := []string{codec.TEXT_EVENT_HEAD, e.Topic.String(), e.Name}
+	if e.Joints != nil {
+		fields = append(fields, fmt.Sprintf("%f$%f$%f$%f$%f", e.Joints.Z, e.Joints.Y, e.Joints.X, e.Joints.V, e.Joints.W))
+	}
+	return []byte(strings.Join(append(fields, e.Details...), "$"))
+}
+
+func (e *Event) ParseJSON() []byte {
+	event := jsonEvent{Type: "event", Topic: e.Topic.String(), Event: e.Name, Details: e.Details}
+	if e.Joints != nil {
+		event.Joints = map[string]float32{"x": e.Joints.X, "y": e.Joints.Y, "z": e.Joints.Z, "v": e.Joints.V, "w": e.Joints.W}
+	}
+	return marshalJSONResponse(event)
+}
+
+func (e *Event) ParseBinary() *codec.Frame {
+	frame := &codec.Frame{Type: codec.FRAME_EVENT, Code: byte(e.Topic), Strings: append([]string{e.Name}, e.Details...)}
+	if e.Joints != nil {
+		frame.Floats = []float32{e.Joints.Z, e.Joints.Y, e.Joints.X, e.Joints.V, e.Joints.W}
+	}
+	return frame
+}
+
+type Subscription struct {
+	bus     *EventBus
+	topics  map[EventTopic]bool
+	deliver func(event *Event)
+}
+
+func (s *Subscription) Add(topic EventTopic) {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.topics[topic] = true
+	s.bus.updateMonitor()
+}
+
+func (s *Subscription) Remove(topic EventTopic) {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	delete(s.topics, topic)
+	s.bus.updateMonitor()
+}
+
+func (s *Subscription) Topics() []string {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	names := []string{}
+	for topic := range s.topics {
+		names = append(names, topic.String())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	delete(s.bus.subscriptions, s)
+	s.bus.updateMonitor()
+}
+
+type EventBus struct {
+	mu            sync.Mutex
+	robot         *robot.Robot
+	subscriptions map[*Subscription]bool
+	monitorStop   chan struct{}
+}
+
+func (b *EventBus) Subscribe(deliver func(event *Event)) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	subscription := &Subscription{bus: b, topics: map[EventTopic]bool{}, deliver: deliver}
+	b.subscriptions[subscription] = true
+	return subscription
+}
+
+func (b *EventBus) Publish(event *Event) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for subscription := range b.subscriptions {
+		if subscription.topics[event.Topic] {
+			subscription.deliver(event)
+		}
+	}
+}
+
+// Must be called with mu held.
+func (b *EventBus) updateMonitor() {
+	needed := false
+	for subscription := range b.subscriptions {
+		needed = needed || subscription.topics[TOPIC_POSITION] || subscription.topics[TOPIC_MOTION]
+	}
+
+	if needed && b.monitorStop == nil {
+		b.monitorStop = make(chan struct{})
+		go b.monitorRobot(b.monitorStop)
+	} else if !needed && b.monitorStop != nil {
+		close(b.monitorStop)
+		b.monitorStop = nil
+	}
+}
+
+func (b *EventBus) monitorRobot(stop chan struct{}) {
+	logger.Debug("Robot monitor started")
+	ticker := time.NewTicker(ROBOT_MONITOR_INTERVAL)
+	defer ticker.Stop()
+
+	var lastPosition *robot.JointsAngles
+	moving := false
+	for {
+		select {
+		case <-stop:
+			logger.Debug("Robot monitor stopped")
+			return
+		case <-ticker.C:
+		}
+
+		isMoving, err := b.robot.IsMoving()
+		if err != nil {
+			continue
+		}
+		stopped := moving && !isMoving
+		if isMoving != moving {
+			moving = isMoving
+			name := "stopped"
+			if moving {
+				name = "started"
+			}
+			b.Publish(&Event{Topic: TOPIC_MOTION, Name: name})
+		}
+
+		if !moving && !stopped && lastPosition != nil {
+			continue
+		}
+		position, err := b.robot.GetCurrentPosition()
+		if err != nil {
+			continue
+		}
+		if lastPosition == nil || *position != *lastPosition {
+			lastPosition = position
+			b.Publish(&Event{Topic: TOPIC_POSITION, Name: "changed", Joints: position})
+		}
+	}
+}
+
+func (b *EventBus) PublishFault(source string, err error) {
+	b.Publish(&Event{Topic: TOPIC_FAULTS, Name: source, Details: []string{err.Error()}})
+}
+
+func InitEventBus(robot *robot.Robot) *EventBus {
+	return &EventBus{robot: robot, subscriptions: map[*Subscription]bool{}}
+}
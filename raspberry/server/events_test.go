@@ -0,0 +1,106 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xTaube/vr-controlled-robot-arm/robot"
+)
+
+func TestSubscriptionsReceiveTheirTopics(t *testing.T) {
+	events := InitEventBus(nil)
+	calibration, faults := []string{}, []string{}
+	calibrationSubscription := events.Subscribe(func(event *Event) { calibration = append(calibration, event.Name) })
+	calibrationSubscription.Add(TOPIC_CALIBRATION)
+	faultsSubscription := events.Subscribe(func(event *Event) { faults = append(faults, event.Name) })
+	faultsSubscription.Add(TOPIC_FAULTS)
+	faultsSubscription.Add(TOPIC_CALIBRATION)
+	if topics := faultsSubscription.Topics(); !reflect.DeepEqual(topics, []string{"calibration", "faults"}) {
+		t.Errorf("topics = %v", topics)
+	}
+
+	events.Publish(&Event{Topic: TOPIC_CALIBRATION, Name: "started"})
+	faultsSubscription.Remove(TOPIC_CALIBRATION)
+	events.Publish(&Event{Topic: TOPIC_CALIBRATION, Name: "finished"})
+	events.PublishFault("robot", &robot.RobotError{})
+	calibrationSubscription.Close()
+	events.Publish(&Event{Topic: TOPIC_CALIBRATION, Name: "started"})
+
+	if !reflect.DeepEqual(calibration, []string{"started", "finished"}) {
+		t.Errorf("calibration subscription got %v", calibration)
+	}
+	if !reflect.DeepEqual(faults, []string{"started", "robot"}) {
+		t.Errorf("faults subscription got %v", faults)
+	}
+}
+
+func TestEventEncodings(t *testing.T) {
+	event := &Event{Topic: TOPIC_POSITION, Name: "changed", Joints: &robot.JointsAngles{Z: 1, Y: 2, X: 3, V: 4, W: 5}, Details: []string{"detail"}}
+	if text := string(event.Parse()); text != "E$position$changed$1.000000$2.000000$3.000000$4.000000$5.000000$detail" {
+		t.Errorf("text: %s", text)
+	}
+	if json := string(event.ParseJSON()); json != `{"type":"event","topic":"position","event":"changed","joints":{"v":4,"w":5,"x":3,"y":2,"z":1},"details":["detail"]}` {
+		t.Errorf("json: %s", json)
+	}
+	frame := event.ParseBinary()
+	if frame.Code != byte(TOPIC_POSITION) || !reflect.DeepEqual(frame.Strings, []string{"changed", "detail"}) || !reflect.DeepEqual(frame.Floats, []float32{1, 2, 3, 4, 5}) {
+		t.Errorf("binary: %+v", frame)
+	}
+}
+
+func TestMonitorPublishesMotionAndPosition(t *testing.T) {
+	arm := robot.InitSimulatedRobot(robot.Limits{
+		MinSpeed: 1,
+		MaxSpeed: 100,
+		X:        robot.JointLimits{Min: -180, Max: 180},
+		Y:        robot.JointLimits{Min: -180, Max: 180},
+		Z:        robot.JointLimits{Min: -180, Max: 180},
+		V:        robot.JointLimits{Min: -90, Max: 90},
+		W:        robot.JointLimits{Min: -90, Max: 90},
+	})
+	arm.StartCalibration()
+	arm.FinishCalibration()
+	events := InitEventBus(arm)
+	published := make(chan *Event, 64)
+	subscription := events.Subscribe(func(event *Event) { published <- event })
+	subscription.Add(TOPIC_MOTION)
+	subscription.Add(TOPIC_POSITION)
+	defer subscription.Close()
+
+	next := func() *Event {
+		t.Helper()
+		select {
+		case event := <-published:
+			return event
+		case <-time.After(2 * time.Second):
+			t.Fatal("no event")
+			return nil
+		}
+	}
+	if event := next(); event.Topic != TOPIC_POSITION || event.Name != "changed" {
+		t.Fatalf("got %s %s, want the position first", event.Topic, event.Name)
+	}
+
+	target := robot.JointsAngles{Z: 2, Y: -90, X: 0}
+	if _, err := arm.Move(target); err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	var last *robot.JointsAngles
+	for len(names) == 0 || names[len(names)-1] != "stopped" {
+		event := next()
+		if event.Topic == TOPIC_MOTION {
+			names = append(names, event.Name)
+		} else {
+			last = event.Joints
+		}
+	}
+	// The position where the arm stopped follows the stopped event.
+	if event := next(); event.Topic != TOPIC_POSITION || event.Joints.Z != target.Z {
+		t.Errorf("got %s %+v, want the target", event.Name, event.Joints)
+	}
+	if names[0] != "started" || last == nil {
+		t.Errorf("got motion %v and positions up to %+v, want positions while moving", names, last)
+	}
+}
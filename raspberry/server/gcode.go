@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/xTaube/vr-controlled-robot-arm/gcode"
+	"github.com/xTaube/vr-controlled-robot-arm/robot"
+	"github.com/xTaube/vr-controlled-robot-arm/storage"
+)
+
+// Programs are parsed on upload, so mistakes are reported with their line before anything runs.
+func (ch *CommandHandler) uploadGCodeCommandHandler(ctx context.Context, command CommandIdentifier, command_args Arguments) Response {
+	name := command_args.String("name")
+	program, err := gcode.Parse(name, command_args.String("source"))
+	if err == nil {
+		err = program.CheckKinematics(ch.programs.Geometry())
+	}
+	if err != nil {
+		return &ErrorResponse{Code: RESPONSE_INVALID_ARGUMENT_ERROR, Err: &InvalidArgumentError{command.String(), "source", err.Error()}}
+	}
+	err = ch.programs.GCodeStore().Save(program)
+	if err != nil {
+		return &ErrorResponse{Code: RESPONSE_PROGRAM_ERROR, Err: err}
+	}
+
+	logger.InfoContext(ctx, "G-code program uploaded", "program", name, "lines", len(program.Blocks))
+	return &ResponseWithStringArguments{Code: RESPONSE_OK, Args: []string{name}}
+}
+
+func (ch *CommandHandler) runGCodeCommandHandler(command CommandIdentifier, command_args Arguments) Response {
+	name := command_args.String("name")
+	program, err := ch.programs.GCodeStore().Load(name)
+	var notFound *storage.NotFoundError
+	if errors.As(err, &notFound) {
+		return &ErrorResponse{Code: RESPONSE_INVALID_ARGUMENT_ERROR, Err: &InvalidArgumentError{command.String(), "name", fmt.Sprintf("no G-code program named %q", name)}}
+	}
+	// The kinematics may have been turned off since the upload.
+	if err == nil {
+		err = program.CheckKinematics(ch.programs.Geometry())
+	}
+	if err != nil {
+		return &ErrorResponse{Code: RESPONSE_PROGRAM_ERROR, Err: err}
+	}
+	calibrated, err := ch.robot.IsCalibrated()
+	if err != nil {
+		return ch.robotErrorResponse(err)
+	}
+	if !calibrated {
+		return &ErrorResponse{Code: RESPONSE_ROBOT_CANNOT_EXECUTE_COMMAND_ERROR, Err: &robot.RobotError{Code: robot.ROBOT_NOT_CALIBRATED_ERROR}}
+	}
+
+	err = ch.programs.StartGCode(program)
+	if err != nil {
+		return &ErrorResponse{Code: RESPONSE_PROGRAM_ERROR, Err: err}
+	}
+	return &ResponseWithStringArguments{Code: RESPONSE_OK, Args: []string{name, strconv.Itoa(len(program.Blocks))}}
+}
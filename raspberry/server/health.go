@@ -0,0 +1,163 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/xTaube/vr-controlled-robot-arm/video"
+)
+
+// The robot answers one request at a time, a move in progress can hold the line for a while.
+const HEALTH_CHECK_TIMEOUT = 2 * time.Second
+
+const DEFAULT_RTSP_PORT = "554"
+
+type HealthCheck struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type StreamHealth struct {
+	Device  string            `json:"device"`
+	Address string            `json:"address"`
+	State   video.StreamState `json:"state"`
+	Process HealthCheck       `json:"process"`
+	RTSP    HealthCheck       `json:"rtsp"`
+}
+
+type HealthReport struct {
+	Status      string         `json:"status"`
+	Uptime      float64        `json:"uptime_seconds"`
+	Serial      HealthCheck    `json:"serial"`
+	Calibration HealthCheck    `json:"calibration"`
+	Streams     []StreamHealth `json:"streams"`
+}
+
+func withTimeout(check func() HealthCheck) HealthCheck {
+	result := make(chan HealthCheck, 1)
+	go func() {
+		result <- check()
+	}()
+	select {
+	case health := <-result:
+		return health
+	case <-time.After(HEALTH_CHECK_TIMEOUT):
+		return HealthCheck{Detail: fmt.Sprintf("no answer within %s", HEALTH_CHECK_TIMEOUT)}
+	}
+}
+
+func (s *ControlServer) checkSerial() HealthCheck {
+	_, err := s.robot.IsMoving()
+	if err != nil {
+		return HealthCheck{Detail: err.Error()}
+	}
+	return HealthCheck{OK: true}
+}
+
+func (s *ControlServer) checkCalibration() HealthCheck {
+	calibrated, err := s.robot.IsCalibrated()
+	if err != nil {
+		return HealthCheck{Detail: err.Error()}
+	}
+	if !calibrated {
+		return HealthCheck{Detail: "robot is not calibrated"}
+	}
+	return HealthCheck{OK: true}
+}
+
+func checkRTSPServer(address string) HealthCheck {
+	serverURL, err := url.Parse(address)
+	if err != nil {
+		return HealthCheck{Detail: err.Error()}
+	}
+	host := serverURL.Host
+	if serverURL.Port() == "" {
+		host = net.JoinHostPort(serverURL.Hostname(), DEFAULT_RTSP_PORT)
+	}
+	conn, err := net.DialTimeout("tcp", host, HEALTH_CHECK_TIMEOUT)
+	if err != nil {
+		return HealthCheck{Detail: err.Error()}
+	}
+	conn.Close()
+	return HealthCheck{OK: true}
+}
+
+func checkVideoStream(videoStream *video.VideoStream) StreamHealth {
+	health := StreamHealth{Device: videoStream.Device(), Address: videoStream.ServerAddress()}
+	state, err := videoStream.State()
+	health.State = state
+	health.Process = HealthCheck{OK: state != video.STREAM_EXITED}
+	if state == video.STREAM_EXITED {
+		health.Process.Detail = "ffmpeg exited"
+		if err != nil {
+			health.Process.Detail = fmt.Sprintf("ffmpeg exited: %s", err)
+		}
+	}
+	health.RTSP = checkRTSPServer(videoStream.ServerAddress())
+	return health
+}
+
+func (s *ControlServer) Health() *HealthReport {
+	report := &HealthReport{
+		Uptime:  time.Since(s.startedAt).Seconds(),
+		Serial:  withTimeout(s.checkSerial),
+		Streams: []StreamHealth{},
+	}
+	if report.Serial.OK {
+		report.Calibration = withTimeout(s.checkCalibration)
+	} else {
+		report.Calibration = HealthCheck{Detail: "serial link is down"}
+	}
+	for _, videoStream := range s.videos {
+		report.Streams = append(report.Streams, checkVideoStream(videoStream))
+	}
+
+	report.Status = "ok"
+	if !report.ready() {
+		report.Status = "degraded"
+	}
+	if !report.Serial.OK {
+		report.Status = "failing"
+	}
+	return report
+}
+
+// Ready means a client could drive the arm and start the cameras right now.
+func (r *HealthReport) ready() bool {
+	ready := r.Serial.OK && r.Calibration.OK
+	for _, stream := range r.Streams {
+		ready = ready && stream.Process.OK && stream.RTSP.OK
+	}
+	return ready
+}
+
+func writeHealthReport(w http.ResponseWriter, report *HealthReport, healthy bool) {
+	status := http.StatusOK
+	if !healthy {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
+
+// Liveness only needs the serial link, systemd restarting the server cannot calibrate the arm.
+func (s *ControlServer) HealthRequestHandler(w http.ResponseWriter, r *http.Request) {
+	report := s.Health()
+	writeHealthReport(w, report, report.Serial.OK)
+}
+
+func (s *ControlServer) ReadinessRequestHandler(w http.ResponseWriter, r *http.Request) {
+	report := s.Health()
+	writeHealthReport(w, report, report.ready())
+}
+
+func addHealthHandlers(mux *http.ServeMux, controlServer *ControlServer) {
+	mux.HandleFunc("GET /healthz", controlServer.HealthRequestHandler)
+	mux.HandleFunc("GET /readyz", controlServer.ReadinessRequestHandler)
+}
@@ -1,15 +1,23 @@
 package server
 
 import (
-	"log"
+	"context"
+	"fmt"
 	"net/http"
-	"os"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/quic-go/webtransport-go"
+	"github.com/xTaube/vr-controlled-robot-arm/auth"
+	"github.com/xTaube/vr-controlled-robot-arm/gcode"
+	"github.com/xTaube/vr-controlled-robot-arm/kinematics"
+	"github.com/xTaube/vr-controlled-robot-arm/metrics"
+	"github.com/xTaube/vr-controlled-robot-arm/pose"
+	"github.com/xTaube/vr-controlled-robot-arm/program"
 	"github.com/xTaube/vr-controlled-robot-arm/robot"
+	"github.com/xTaube/vr-controlled-robot-arm/script"
 	"github.com/xTaube/vr-controlled-robot-arm/video"
-	"go.bug.st/serial"
 )
 
 const BUFF_SIZE = 128
@@ -17,96 +25,257 @@ const BUFF_SIZE = 128
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  BUFF_SIZE,
 	WriteBufferSize: BUFF_SIZE,
+	Subprotocols:    SUPPORTED_SUBPROTOCOLS,
 }
 
-func WebTransportControlRequestHandler(server *webtransport.Server) func(http.ResponseWriter, *http.Request) {
-	log.Println("ControlRequestHander registered.")
+// ControlServer holds what every control session shares, whichever transport it arrives on.
+type ControlServer struct {
+	robot    *robot.Robot
+	videos   []*video.VideoStream
+	events   *EventBus
+	lease    *ControlLease
+	programs *ProgramRunner
+	scripts  *ScriptRunner
+	poses    *pose.Library
+	// The checks every command goes through, DefaultMiddleware unless the caller chose others.
+	middlewares []Middleware
 
-	return func(w http.ResponseWriter, r *http.Request) {
-		log.Println("Upgrading session...")
-		session, err := server.Upgrade(w, r)
-		if err != nil {
-			log.Printf("Failed to upgrade to WebTransport: %s", err)
-			w.WriteHeader(http.StatusInternalServerError)
+	authenticator *auth.Authenticator
+	startedAt     time.Time
+	// Sessions are recorded there when set.
+	recordingDir string
+
+	mu       sync.Mutex
+	sessions map[*TransportSession]bool
+	closing  bool
+	served   sync.WaitGroup
+}
+
+func (s *ControlServer) initCommandHandler(session Session, notify func(event *Event)) (*CommandHandler, *Subscription) {
+	subscription := s.events.Subscribe(notify)
+	robotCalibrationWorkflow := InitRobotCalibrationWorkflow(session, s.robot, s.events)
+	return InitCommandHandler(session, s.videos, s.robot, robotCalibrationWorkflow, s.events, subscription, s.lease, s.programs, s.scripts, s.poses, s.middlewares), subscription
+}
+
+func (s *ControlServer) Serve(session *TransportSession, background ...func(commandHandler *CommandHandler)) {
+	if !s.track(session) {
+		session.Close()
+		return
+	}
+	defer s.untrack(session)
+
+	identity := session.Identity()
+	logger.InfoContext(session.Context(), "Session started", "transport", identity.Transport, "remote", identity.RemoteAddr, "principal", identity.Principal.String())
+	defer logger.InfoContext(session.Context(), "Session finished")
+	defer session.Close()
+
+	sessions := metrics.ActiveSessions.WithLabelValues(identity.Transport)
+	sessions.Inc()
+	defer sessions.Dec()
+
+	s.lease.Join(identity.ID, identity.Principal.Role.Includes(COMMAND_REGISTRY[ACQUIRE_CONTROL].Role))
+	defer func() {
+		if s.lease.Leave(identity.ID) > 0 {
 			return
 		}
-		log.Println("Session upgraded to WebTransport.")
+		for _, videoStream := range s.videos {
+			videoStream.Stop()
+		}
+	}()
 
-		log.Println("Accepting stream...")
-		stream, err := session.AcceptStream(r.Context())
+	if s.recordingDir != "" {
+		recorder, err := CreateRecorder(s.recordingDir, identity)
 		if err != nil {
-			log.Printf("Failed to accept stream: %s", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+			logger.WarnContext(session.Context(), "Cannot record session", "error", err)
+		} else {
+			logger.InfoContext(session.Context(), "Recording session", "path", recorder.file.Name())
+			defer recorder.Close()
+			session.recorder = recorder
 		}
-		log.Println("Stream accepted.")
-		defer stream.Close()
-
-		log.Println("Initializing camera...")
-		videoStream := video.InitVideoStream(
-			os.Getenv("CAMERA_DEVICE_PATH"),
-			video.Resoulution{Width: 1920, Height: 1080},
-			video.FPS30,
-			video.MJPEG,
-			"rtsp://localhost:8554/video/feed",
-		)
-		defer videoStream.Stop()
-		log.Println("Camera initialized.")
-
-		log.Println("Initializing robot arm...")
-		robot, err := robot.InitRobot(
-			robot.UartConfig{
-				PortName: os.Getenv("UART_PORT"),
-				Parity:   serial.EvenParity,
-				StopBits: serial.OneStopBit,
-				BaudRate: 115200,
-				DataBits: 8,
-			},
-		)
+	}
+
+	commandHandler, subscription := s.initCommandHandler(session, session.Notify)
+	defer subscription.Close()
+	running := sync.WaitGroup{}
+	for _, run := range background {
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			run(commandHandler)
+		}()
+	}
+	s.serve(session, commandHandler)
+
+	// Background work ends with the session and may still record, so it is
+	// joined before the recorder closes.
+	session.Close()
+	running.Wait()
+}
+
+func (s *ControlServer) serve(session *TransportSession, commandHandler *CommandHandler) {
+	for {
+		request, err := session.Receive()
+		if err != nil {
+			break
+		}
+
+		response := commandHandler.Handle(request)
+		session.Send(request, response)
+		session.recordDone(request)
+	}
+}
+
+// Datagrams may be lost or reordered, so only moves are accepted and a move
+// still waiting for the robot is replaced by the newest one. Moves arriving
+// while a workflow such as CALIBRATE_ROBOT runs are dropped. It returns once
+// controlSession is closed and the last move is handled.
+func (s *ControlServer) serveDatagrams(session *webtransport.Session, controlSession *TransportSession, codec Codec, commandHandler *CommandHandler) {
+	defer logger.DebugContext(controlSession.Context(), "Datagrams closed")
+	latest := make(chan *Request, 1)
+	finished := make(chan struct{})
+	defer func() {
+		close(latest)
+		<-finished
+	}()
+
+	go func() {
+		defer close(finished)
+		for request := range latest {
+			if controlSession.Context().Err() != nil {
+				return
+			}
+			handled := commandHandler.OutsideWorkflow(func() {
+				controlSession.recordRequest(request)
+				response := commandHandler.Handle(request)
+				controlSession.recordResponse(request, response)
+				controlSession.recordDone(request)
+				if request.ID != "" {
+					session.SendDatagram(codec.EncodeResponse(response, request.ID))
+				}
+			})
+			if !handled {
+				logger.DebugContext(controlSession.Context(), "Ignoring datagram, a workflow is running", "command", request.Command.String())
+			}
+		}
+	}()
+
+	for {
+		data, err := session.ReceiveDatagram(controlSession.Context())
 		if err != nil {
-			log.Printf("Error initializing robot arm: %s.\n", err)
 			return
 		}
-		defer robot.ShutDown()
-		log.Println("Robot arm initialized.")
 
-		// PLACE TO WRITE COMMAND HANDLER
+		request, err := codec.DecodeRequest(data)
+		if err != nil {
+			logger.DebugContext(controlSession.Context(), "Ignoring malformed datagram", "error", err)
+			continue
+		}
+		if request.Command != MOVE_ROBOT {
+			logger.DebugContext(controlSession.Context(), "Ignoring datagram, only moves are accepted", "command", request.Command.String())
+			continue
+		}
 
-		log.Println("Session finished")
+		select {
+		case <-latest:
+		default:
+		}
+		latest <- request
 	}
 }
 
-func WebSocketControlRequestHandler(
-	robot *robot.Robot,
-	video0 *video.VideoStream,
-	video1 *video.VideoStream,
-) func(http.ResponseWriter, *http.Request) {
+func (s *ControlServer) WebTransportControlRequestHandler(server *webtransport.Server) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Println("Upgrading session...")
-		connection, err := upgrader.Upgrade(w, r, nil)
+		principal, ok := s.authorizeRequest(w, r)
+		if !ok {
+			return
+		}
+
+		session, err := server.Upgrade(w, r)
+		if err != nil {
+			logger.Warn("Cannot upgrade to WebTransport", "remote", r.RemoteAddr, "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		defer session.CloseWithError(0, "")
+
+		ctx, cancel := context.WithTimeout(session.Context(), WEBTRANSPORT_STREAM_TIMEOUT)
+		stream, err := session.AcceptStream(ctx)
+		cancel()
 		if err != nil {
-			log.Printf("Error upgrading to websocket:%s.\n", err)
+			logger.Warn("Cannot accept WebTransport stream", "remote", r.RemoteAddr, "error", err)
 			return
 		}
-		log.Println("Session upgraded to WebSocket.")
-		defer connection.Close()
+		subprotocol := r.URL.Query().Get("protocol")
+		codec := CodecForSubprotocol(subprotocol)
+		logger.Debug("Selected codec", "remote", r.RemoteAddr, "codec", fmt.Sprintf("%T", codec), "subprotocol", subprotocol)
 
-		defer video0.Stop()
-		defer video1.Stop()
+		controlSession := InitTransportSession(
+			session.Context(),
+			&webTransportStream{stream},
+			codec,
+			NewSessionIdentity("webtransport", r.RemoteAddr, subprotocol, principal),
+		)
+		s.Serve(controlSession, func(commandHandler *CommandHandler) {
+			s.serveDatagrams(session, controlSession, codec, commandHandler)
+		})
+	}
+}
 
-		robotCalibrationWorkflow := InitRobotCalibrationWorkflow(connection, robot)
+func (s *ControlServer) WebSocketControlRequestHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := s.authorizeRequest(w, r)
+	if !ok {
+		return
+	}
 
-		commandHandler := InitCommandHandler(video0, video1, robot, robotCalibrationWorkflow)
-		for {
-			_, request, err := connection.ReadMessage()
-			if err != nil {
-				break
-			}
+	connection, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.Warn("Cannot upgrade to WebSocket", "remote", r.RemoteAddr, "error", err)
+		return
+	}
 
-			command_id, args := ParseRequestArguments(string(request))
-			response := commandHandler.Handle(command_id, args)
-			connection.WriteMessage(websocket.TextMessage, response.Parse())
-		}
-		log.Println("Session finished")
+	codec := CodecForSubprotocol(connection.Subprotocol())
+	logger.Debug("Selected codec", "remote", r.RemoteAddr, "codec", fmt.Sprintf("%T", codec), "subprotocol", connection.Subprotocol())
+
+	s.Serve(InitTransportSession(
+		r.Context(),
+		initWebSocketTransport(connection, codec),
+		codec,
+		NewSessionIdentity("websocket", r.RemoteAddr, connection.Subprotocol(), principal),
+	))
+}
+
+// A nil authenticator lets every client in with full rights, an empty recordingDir records nothing.
+// Every command goes through middlewares in order, DefaultMiddleware gives the built-in checks.
+func InitControlServer(
+	robot *robot.Robot,
+	videos []*video.VideoStream,
+	leaseTimeout time.Duration,
+	authenticator *auth.Authenticator,
+	recordingDir string,
+	programs *program.Store,
+	gcodes *gcode.Store,
+	geometry *kinematics.Geometry,
+	scripts *script.Store,
+	scriptLimits script.Limits,
+	poses *pose.Library,
+	middlewares []Middleware,
+) *ControlServer {
+	events := InitEventBus(robot)
+	lease := InitControlLease(leaseTimeout, events)
+	programRunner := InitProgramRunner(robot, events, programs, gcodes, geometry)
+	scriptRunner := InitScriptRunner(robot, events, scripts, poses, scriptLimits)
+	return &ControlServer{
+		robot:         robot,
+		videos:        videos,
+		events:        events,
+		lease:         lease,
+		programs:      programRunner,
+		scripts:       scriptRunner,
+		poses:         poses,
+		middlewares:   middlewares,
+		authenticator: authenticator,
+		startedAt:     time.Now(),
+		recordingDir:  recordingDir,
+		sessions:      map[*TransportSession]bool{},
 	}
 }
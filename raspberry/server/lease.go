@@ -0,0 +1,244 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+type LeaseHeldError struct {
+	holder string
+}
+
+func (err *LeaseHeldError) Error() string {
+	return fmt.Sprintf("Control is held by %s.", err.holder)
+}
+
+type NotLeaseHolderError struct {
+	session string
+}
+
+func (err *NotLeaseHolderError) Error() string {
+	return fmt.Sprintf("%s does not hold control.", err.session)
+}
+
+type UnknownSessionError struct {
+	session string
+}
+
+func (err *UnknownSessionError) Error() string {
+	return fmt.Sprintf("Session %s is not connected.", err.session)
+}
+
+type ObserverSessionError struct {
+	session string
+}
+
+func (err *ObserverSessionError) Error() string {
+	return fmt.Sprintf("Session %s may only observe.", err.session)
+}
+
+// ControlLease decides which session drives the arm. The holder keeps it
+// as long as it sends requests or heartbeats within the timeout, a free
+// lease is taken implicitly by the first control command.
+type ControlLease struct {
+	mu         sync.Mutex
+	timeout    time.Duration
+	events     *EventBus
+	sessions   map[string]bool // whether the session may hold control
+	holder     string
+	pinned     bool
+	expiry     *time.Timer
+	generation uint64
+}
+
+func (l *ControlLease) Join(session string, controller bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sessions[session] = controller
+}
+
+// Leave returns how many sessions are still connected.
+func (l *ControlLease) Leave(session string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.sessions, session)
+	if l.holder == session {
+		l.expire("disconnected")
+	}
+	return len(l.sessions)
+}
+
+func (l *ControlLease) Holder() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.holder
+}
+
+func (l *ControlLease) Acquire(session string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch l.holder {
+	case session:
+		l.refresh()
+		return nil
+	case "":
+		l.grant(session)
+		return nil
+	default:
+		l.events.Publish(&Event{Topic: TOPIC_CONTROL, Name: "requested", Details: []string{session}})
+		return &LeaseHeldError{l.holder}
+	}
+}
+
+func (l *ControlLease) Release(session string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holder != session {
+		return &NotLeaseHolderError{session}
+	}
+	l.clear()
+	logger.Info("Control released", "session", session)
+	l.events.Publish(&Event{Topic: TOPIC_CONTROL, Name: "released", Details: []string{session}})
+	return nil
+}
+
+func (l *ControlLease) Handover(session string, to string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holder != session {
+		return &NotLeaseHolderError{session}
+	}
+	controller, ok := l.sessions[to]
+	if !ok {
+		return &UnknownSessionError{to}
+	}
+	if !controller {
+		return &ObserverSessionError{to}
+	}
+	l.holder = to
+	l.pinned = false
+	l.refresh()
+	logger.Info("Control handed over", "session", session, "to", to)
+	l.events.Publish(&Event{Topic: TOPIC_CONTROL, Name: "handed_over", Details: []string{session, to}})
+	return nil
+}
+
+func (l *ControlLease) Heartbeat(session string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holder != session {
+		return &NotLeaseHolderError{session}
+	}
+	l.refresh()
+	return nil
+}
+
+// Touch counts any request of the holder as a heartbeat.
+func (l *ControlLease) Touch(session string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holder == session {
+		l.refresh()
+	}
+}
+
+func (l *ControlLease) Authorize(session string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch l.holder {
+	case session:
+		l.refresh()
+		return nil
+	case "":
+		l.grant(session)
+		return nil
+	default:
+		return &LeaseHeldError{l.holder}
+	}
+}
+
+// Pin keeps the lease from expiring while a long command such as calibration
+// waits on the holder, the session still loses it when it disconnects.
+func (l *ControlLease) Pin(session string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holder == session {
+		l.pinned = true
+		l.stopExpiry()
+	}
+}
+
+func (l *ControlLease) Unpin(session string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holder == session {
+		l.pinned = false
+		l.refresh()
+	}
+}
+
+// Methods below must be called with mu held.
+func (l *ControlLease) grant(session string) {
+	l.holder = session
+	l.pinned = false
+	l.refresh()
+	logger.Info("Control acquired", "session", session)
+	l.events.Publish(&Event{Topic: TOPIC_CONTROL, Name: "acquired", Details: []string{session}})
+}
+
+func (l *ControlLease) refresh() {
+	l.stopExpiry()
+	if l.pinned {
+		return
+	}
+	generation := l.generation
+	l.expiry = time.AfterFunc(l.timeout, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.generation == generation && l.holder != "" {
+			l.expire("heartbeat timeout")
+		}
+	})
+}
+
+func (l *ControlLease) stopExpiry() {
+	l.generation++
+	if l.expiry != nil {
+		l.expiry.Stop()
+		l.expiry = nil
+	}
+}
+
+func (l *ControlLease) clear() {
+	l.stopExpiry()
+	l.holder = ""
+	l.pinned = false
+}
+
+func (l *ControlLease) expire(reason string) {
+	holder := l.holder
+	l.clear()
+	level := slog.LevelWarn
+	if reason == "disconnected" {
+		level = slog.LevelInfo
+	}
+	logger.Log(context.Background(), level, "Control expired", "session", holder, "reason", reason)
+	l.events.Publish(&Event{Topic: TOPIC_CONTROL, Name: "expired", Details: []string{holder, reason}})
+}
+
+func InitControlLease(timeout time.Duration, events *EventBus) *ControlLease {
+	return &ControlLease{timeout: timeout, events: events, sessions: map[string]bool{}}
+}
+
+func LeaseMiddleware(call *CommandCall, next CommandFunc) Response {
+	if call.Definition.Control {
+		err := call.Handler.lease.Authorize(call.Session.Identity().ID)
+		if err != nil {
+			return &ErrorResponse{Code: RESPONSE_CONTROL_LEASE_ERROR, Err: err}
+		}
+	}
+	return next(call)
+}
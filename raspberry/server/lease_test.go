@@ -0,0 +1,129 @@
+package server
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// A lease whose control events are collected in order.
+func initTestLease(timeout time.Duration) (*ControlLease, chan *Event) {
+	events := InitEventBus(nil)
+	published := make(chan *Event, 16)
+	events.Subscribe(func(event *Event) { published <- event }).Add(TOPIC_CONTROL)
+	lease := InitControlLease(timeout, events)
+	lease.Join("operator-1", true)
+	lease.Join("operator-2", true)
+	lease.Join("observer", false)
+	return lease, published
+}
+
+func expectEvent(t *testing.T, published chan *Event, name string, details ...string) {
+	t.Helper()
+	select {
+	case event := <-published:
+		if event.Name != name || !reflect.DeepEqual(event.Details, details) {
+			t.Errorf("got %s %v, want %s %v", event.Name, event.Details, name, details)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("no %s event", name)
+	}
+}
+
+func TestLeaseAcquireAndRelease(t *testing.T) {
+	lease, published := initTestLease(time.Minute)
+
+	if err := lease.Acquire("operator-1"); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, published, "acquired", "operator-1")
+
+	var held *LeaseHeldError
+	if err := lease.Acquire("operator-2"); !errors.As(err, &held) {
+		t.Errorf("second acquire: got %v, want a LeaseHeldError", err)
+	}
+	expectEvent(t, published, "requested", "operator-2")
+	if err := lease.Authorize("operator-2"); !errors.As(err, &held) {
+		t.Errorf("control command of another session: got %v, want a LeaseHeldError", err)
+	}
+
+	var notHolder *NotLeaseHolderError
+	if err := lease.Release("operator-2"); !errors.As(err, &notHolder) {
+		t.Errorf("release by another session: got %v, want a NotLeaseHolderError", err)
+	}
+	if err := lease.Release("operator-1"); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, published, "released", "operator-1")
+
+	// A free lease goes to the first control command.
+	if err := lease.Authorize("operator-2"); err != nil {
+		t.Fatal(err)
+	}
+	if holder := lease.Holder(); holder != "operator-2" {
+		t.Errorf("holder = %q, want operator-2", holder)
+	}
+}
+
+func TestLeaseHandover(t *testing.T) {
+	lease, published := initTestLease(time.Minute)
+	lease.Acquire("operator-1")
+	expectEvent(t, published, "acquired", "operator-1")
+
+	var unknown *UnknownSessionError
+	if err := lease.Handover("operator-1", "gone"); !errors.As(err, &unknown) {
+		t.Errorf("handover to an unknown session: got %v", err)
+	}
+	var observer *ObserverSessionError
+	if err := lease.Handover("operator-1", "observer"); !errors.As(err, &observer) {
+		t.Errorf("handover to an observer: got %v", err)
+	}
+	var notHolder *NotLeaseHolderError
+	if err := lease.Handover("operator-2", "operator-1"); !errors.As(err, &notHolder) {
+		t.Errorf("handover by another session: got %v", err)
+	}
+
+	if err := lease.Handover("operator-1", "operator-2"); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, published, "handed_over", "operator-1", "operator-2")
+	if holder := lease.Holder(); holder != "operator-2" {
+		t.Errorf("holder = %q, want operator-2", holder)
+	}
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	lease, published := initTestLease(100 * time.Millisecond)
+	lease.Acquire("operator-1")
+	expectEvent(t, published, "acquired", "operator-1")
+
+	// Heartbeats keep the lease past its timeout.
+	for range 4 {
+		time.Sleep(40 * time.Millisecond)
+		if err := lease.Heartbeat("operator-1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if holder := lease.Holder(); holder != "operator-1" {
+		t.Fatalf("holder = %q despite heartbeats", holder)
+	}
+
+	expectEvent(t, published, "expired", "operator-1", "heartbeat timeout")
+	if holder := lease.Holder(); holder != "" {
+		t.Errorf("holder = %q after expiry", holder)
+	}
+
+	// A pinned lease does not expire, leaving the session does.
+	lease.Acquire("operator-2")
+	expectEvent(t, published, "acquired", "operator-2")
+	lease.Pin("operator-2")
+	time.Sleep(200 * time.Millisecond)
+	if holder := lease.Holder(); holder != "operator-2" {
+		t.Fatalf("pinned lease expired, holder = %q", holder)
+	}
+	if remaining := lease.Leave("operator-2"); remaining != 2 {
+		t.Errorf("%d sessions remain, want 2", remaining)
+	}
+	expectEvent(t, published, "expired", "operator-2", "disconnected")
+}
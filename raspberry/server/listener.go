@@ -0,0 +1,101 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const PROTOCOL_SNIFF_TIMEOUT = 10 * time.Second
+
+// First byte of every TLS connection, a handshake record.
+const TLS_HANDSHAKE_RECORD = 0x16
+
+type sniffedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *sniffedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+type channelListener struct {
+	parent    net.Listener
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *channelListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *channelListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	return l.parent.Close()
+}
+
+func (l *channelListener) Addr() net.Addr {
+	return l.parent.Addr()
+}
+
+// splitTLSListener lets TLS and plain connections share a port, telling them apart by their first byte.
+func splitTLSListener(listener net.Listener) (*channelListener, *channelListener) {
+	tlsListener := &channelListener{parent: listener, conns: make(chan net.Conn), done: make(chan struct{})}
+	plainListener := &channelListener{parent: listener, conns: make(chan net.Conn), done: make(chan struct{})}
+
+	go func() {
+		defer tlsListener.Close()
+		defer plainListener.Close()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go dispatchConnection(conn, tlsListener, plainListener)
+		}
+	}()
+	return tlsListener, plainListener
+}
+
+func dispatchConnection(conn net.Conn, tlsListener *channelListener, plainListener *channelListener) {
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(PROTOCOL_SNIFF_TIMEOUT))
+	first, err := reader.Peek(1)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	target := plainListener
+	if first[0] == TLS_HANDSHAKE_RECORD {
+		target = tlsListener
+	}
+	select {
+	case target.conns <- &sniffedConn{conn, reader}:
+	case <-target.done:
+		conn.Close()
+	}
+}
+
+func plainConnectionsHandler(policy string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if policy == PLAIN_CONNECTIONS_REDIRECT {
+			http.Redirect(w, r, "https://"+r.Host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+			return
+		}
+		logger.Info("Rejected plain connection", "remote", r.RemoteAddr, "policy", policy)
+		http.Error(w, "This server only accepts TLS connections, use wss:// or https://.", http.StatusBadRequest)
+	}
+}
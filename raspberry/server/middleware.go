@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/xTaube/vr-controlled-robot-arm/logging"
+	"github.com/xTaube/vr-controlled-robot-arm/metrics"
+)
+
+// CommandCall is one command on its way through the middleware chain.
+// Definition stays nil until the command was found in the registry and Args
+// until the arguments were validated, so only middleware running after those
+// checks sees them.
+type CommandCall struct {
+	// Carries the session, the command and the request id for logging.
+	Context context.Context
+	Session Session
+	// The session's handler, with the control lease and the runners shared by the server.
+	Handler    *CommandHandler
+	Command    CommandIdentifier
+	Request    *Request
+	Definition *CommandDefinition
+	Args       Arguments
+}
+
+type CommandFunc func(call *CommandCall) Response
+
+// Middleware wraps every command a session sends. It calls next to go on, or
+// returns an ErrorResponse of its own to stop the command there.
+type Middleware func(call *CommandCall, next CommandFunc) Response
+
+// DefaultMiddleware returns the checks every command goes through, in order,
+// before its handler runs. KnownCommandMiddleware sets call.Definition and
+// ValidateMiddleware call.Args, middleware reading them has to come later.
+func DefaultMiddleware() []Middleware {
+	return []Middleware{
+		ObserveMiddleware,
+		RecoverMiddleware,
+		KnownCommandMiddleware,
+		AuthorizeMiddleware,
+		ValidateMiddleware,
+		LeaseMiddleware,
+		ExclusiveMiddleware,
+	}
+}
+
+// The first middleware is the outermost, handler runs last.
+func chainMiddleware(handler CommandFunc, middlewares []Middleware) CommandFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		middleware, next := middlewares[i], handler
+		handler = func(call *CommandCall) Response {
+			return middleware(call, next)
+		}
+	}
+	return handler
+}
+
+// Every command is counted and timed, failures are logged with their code.
+func ObserveMiddleware(call *CommandCall, next CommandFunc) Response {
+	started := time.Now()
+	response := next(call)
+
+	code := responseCodeName(response)
+	metrics.ObserveCommand(call.Command.String(), code, time.Since(started))
+	if errorResponse, ok := response.(*ErrorResponse); ok {
+		logger.WarnContext(call.Context, "Command failed", "code", code, "error", errorResponse.Err, logging.Elapsed(started))
+	} else {
+		logger.DebugContext(call.Context, "Command handled", logging.Elapsed(started))
+	}
+	return response
+}
+
+// A panicking handler fails its command rather than the session.
+func RecoverMiddleware(call *CommandCall, next CommandFunc) (response Response) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			logger.ErrorContext(call.Context, "Command panicked", "panic", recovered, "stack", string(debug.Stack()))
+			response = &ErrorResponse{Code: RESPONSE_UNKNOWN_ERROR, Err: fmt.Errorf("Command %s failed unexpectedly.", call.Command)}
+		}
+	}()
+	return next(call)
+}
+
+// The only registry lookup of the chain, sets call.Definition for what follows.
+func KnownCommandMiddleware(call *CommandCall, next CommandFunc) Response {
+	definition, ok := COMMAND_REGISTRY[call.Command]
+	if !ok {
+		return &ErrorResponse{Code: RESPONSE_UNKNOWN_COMMAND_ERROR, Err: &CommandNotFound{call.Command}}
+	}
+	// Their workflow reads them from the session itself.
+	if definition.Within != 0 {
+		return &ErrorResponse{Code: RESPONSE_UNKNOWN_COMMAND_ERROR, Err: &WorkflowCommandError{call.Command, definition.Within}}
+	}
+	call.Definition = definition
+	return next(call)
+}
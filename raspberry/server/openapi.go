@@ -0,0 +1,206 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const OPENAPI_VERSION = "3.0.3"
+
+var jointsSchema = map[string]any{
+	"type":        "object",
+	"description": "Joint angles in degrees.",
+	"properties": map[string]any{
+		"z": map[string]any{"type": "number"},
+		"y": map[string]any{"type": "number"},
+		"x": map[string]any{"type": "number"},
+		"v": map[string]any{"type": "number"},
+		"w": map[string]any{"type": "number"},
+	},
+}
+
+// Shape of "data" in successful responses, commands not listed return none.
+var RESPONSE_DATA_SCHEMAS = map[CommandIdentifier]map[string]any{
+	GET_ROBOT_CURRENT_POSITION: jointsSchema,
+	MOVE_ROBOT:                 jointsSchema,
+	GOTO_POSE:                  jointsSchema,
+	START_VIDEO_STREAM:         streamsSchema(),
+	GET_VIDEO_STREAMS:          streamsSchema(),
+	GET_CONTROL: {
+		"type":        "object",
+		"description": "args[0] is the id of the request's session, args[1] the session holding control or empty.",
+		"properties":  map[string]any{"args": map[string]any{"type": "array", "items": map[string]any{"type": "string"}}},
+	},
+	RUN_PROGRAM: {
+		"type":        "object",
+		"description": "args[0] is the program started, args[1] its number of waypoints. Progress is reported on the program event topic.",
+		"properties":  map[string]any{"args": map[string]any{"type": "array", "items": map[string]any{"type": "string"}}},
+	},
+	RUN_SCRIPT: {
+		"type":        "object",
+		"description": "args[0] is the script started. Its output is reported on the script event topic.",
+		"properties":  map[string]any{"args": map[string]any{"type": "array", "items": map[string]any{"type": "string"}}},
+	},
+	RUN_GCODE: {
+		"type":        "object",
+		"description": "args[0] is the program started, args[1] its number of lines with something to do. Progress is reported on the program event topic.",
+		"properties":  map[string]any{"args": map[string]any{"type": "array", "items": map[string]any{"type": "string"}}},
+	},
+	LIST_COMMANDS: {
+		"type":        "object",
+		"description": "Every command with its id, arguments, least role and whether it needs the control lease or is refused while a program or script runs.",
+		"properties":  map[string]any{"commands": map[string]any{"type": "array", "items": map[string]any{"type": "object"}}},
+	},
+	LIST_POSES: {
+		"type":        "object",
+		"description": "Names of the stored poses in alphabetical order.",
+		"properties":  map[string]any{"args": map[string]any{"type": "array", "items": map[string]any{"type": "string"}}},
+	},
+}
+
+func streamsSchema() map[string]any {
+	return map[string]any{
+		"type":       "object",
+		"properties": map[string]any{"streams": map[string]any{"type": "array", "items": map[string]any{"type": "string"}}},
+	}
+}
+
+func argumentSchema(spec ArgumentSpec) map[string]any {
+	switch spec.Type {
+	case ARGUMENT_FLOAT32:
+		schema := map[string]any{"type": "number", "format": "float"}
+		if spec.bounded() {
+			schema["minimum"], schema["maximum"] = spec.Min, spec.Max
+		}
+		if spec.Unit != "" {
+			schema["description"] = fmt.Sprintf("In %s.", spec.Unit)
+		}
+		return schema
+	default:
+		schema := map[string]any{"type": "string"}
+		if len(spec.Values) > 0 {
+			schema["enum"] = spec.Values
+		}
+		if spec.Pattern != nil {
+			schema["pattern"] = spec.Pattern.String()
+		}
+		return schema
+	}
+}
+
+func (r *RESTRoute) requestBodySchema() map[string]any {
+	properties := map[string]any{}
+	required := []string{}
+	if r.Actions != nil {
+		properties["action"] = map[string]any{"type": "string", "enum": r.actionNames()}
+		required = append(required, "action")
+	} else {
+		for _, spec := range COMMAND_REGISTRY[r.Command].Args {
+			properties[spec.Name] = argumentSchema(spec)
+			required = append(required, spec.Name)
+		}
+	}
+	if len(required) == 0 {
+		return nil
+	}
+	return map[string]any{"type": "object", "properties": properties, "required": required, "additionalProperties": false}
+}
+
+func (r *RESTRoute) responseSchema() map[string]any {
+	envelope := map[string]any{"$ref": "#/components/schemas/Response"}
+	data, ok := RESPONSE_DATA_SCHEMAS[r.Command]
+	if !ok {
+		return envelope
+	}
+	return map[string]any{"allOf": []any{envelope, map[string]any{"properties": map[string]any{"data": data}}}}
+}
+
+// Every error code maps to one status, codes sharing a status are documented together.
+func errorResponses() map[string]any {
+	names := map[int][]string{}
+	for code := RESPONSE_UNKNOWN_COMMAND_ERROR; code <= RESPONSE_SCRIPT_ERROR; code++ {
+		names[code.HTTPStatus()] = append(names[code.HTTPStatus()], fmt.Sprintf("%d %s", code, code.Name()))
+	}
+
+	responses := map[string]any{}
+	for status, codes := range names {
+		sort.Strings(codes)
+		responses[fmt.Sprintf("%d", status)] = map[string]any{
+			"description": strings.Join(codes, ", "),
+			"content":     map[string]any{"application/json": map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/Response"}}},
+		}
+	}
+	return responses
+}
+
+func (s *ControlServer) OpenAPIDocument() map[string]any {
+	paths := map[string]any{}
+	for _, route := range REST_ROUTES {
+		responses := errorResponses()
+		responses["200"] = map[string]any{
+			"description": "Command executed.",
+			"content":     map[string]any{"application/json": map[string]any{"schema": route.responseSchema()}},
+		}
+		operation := map[string]any{"summary": route.Summary, "responses": responses}
+		if body := route.requestBodySchema(); body != nil {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content":  map[string]any{"application/json": map[string]any{"schema": body}},
+			}
+		}
+		if s.authenticator != nil {
+			responses["401"] = map[string]any{"description": "Missing or invalid access token."}
+			operation["security"] = []any{map[string]any{"bearer": []string{}}, map[string]any{"query": []string{}}}
+		}
+
+		methods, ok := paths[route.Path].(map[string]any)
+		if !ok {
+			methods = map[string]any{}
+			paths[route.Path] = methods
+		}
+		methods[strings.ToLower(route.Method)] = operation
+	}
+
+	return map[string]any{
+		"openapi": OPENAPI_VERSION,
+		"info":    map[string]any{"title": "v-arm control API", "version": "1"},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": map[string]any{
+				"Response": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"type":   map[string]any{"type": "string", "enum": []string{"response"}},
+						"status": map[string]any{"type": "string", "enum": []string{"ok", "error"}},
+						"code":   map[string]any{"type": "integer"},
+						"data":   map[string]any{"type": "object"},
+						"error": map[string]any{
+							"type": "object",
+							"properties": map[string]any{
+								"code":       map[string]any{"type": "integer"},
+								"name":       map[string]any{"type": "string"},
+								"message":    map[string]any{"type": "string"},
+								"robot_code": map[string]any{"type": "integer", "description": "Firmware error code."},
+								"argument":   map[string]any{"type": "string", "description": "Name of the invalid argument."},
+							},
+						},
+					},
+				},
+			},
+			"securitySchemes": map[string]any{
+				"bearer": map[string]any{"type": "http", "scheme": "bearer"},
+				"query":  map[string]any{"type": "apiKey", "in": "query", "name": "token"},
+			},
+		},
+	}
+}
+
+func (s *ControlServer) OpenAPIRequestHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(s.OpenAPIDocument())
+}
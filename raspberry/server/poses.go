@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/xTaube/vr-controlled-robot-arm/pose"
+	"github.com/xTaube/vr-controlled-robot-arm/storage"
+)
+
+// Unknown and taken names are argument errors, anything else failed to reach the library file.
+func poseErrorResponse(command CommandIdentifier, argument string, name string, err error) Response {
+	var notFound *storage.NotFoundError
+	if errors.As(err, &notFound) {
+		return &ErrorResponse{Code: RESPONSE_INVALID_ARGUMENT_ERROR, Err: &InvalidArgumentError{command.String(), argument, fmt.Sprintf("no pose named %q", name)}}
+	}
+	var taken *pose.NameTakenError
+	if errors.As(err, &taken) {
+		return &ErrorResponse{Code: RESPONSE_INVALID_ARGUMENT_ERROR, Err: &InvalidArgumentError{command.String(), argument, fmt.Sprintf("a pose named %q already exists", name)}}
+	}
+	return &ErrorResponse{Code: RESPONSE_UNKNOWN_ERROR, Err: err}
+}
+
+// The move is checked against the configured limits and runs at the speed last set, like any other.
+func (ch *CommandHandler) gotoPoseCommandHandler(ctx context.Context, command CommandIdentifier, command_args Arguments) Response {
+	name := command_args.String("name")
+	joints, err := ch.poses.Get(name)
+	if err != nil {
+		return poseErrorResponse(command, "name", name, err)
+	}
+
+	logger.InfoContext(ctx, "Moving to pose", "pose", name)
+	result, err := ch.robot.Move(joints)
+	if err != nil {
+		return ch.robotErrorResponse(err)
+	}
+	return &JointsAnglesResponse{Code: RESPONSE_OK, Joints: *result}
+}
+
+// Saving under an existing name replaces that pose.
+func (ch *CommandHandler) saveCurrentAsPoseCommandHandler(ctx context.Context, command CommandIdentifier, command_args Arguments) Response {
+	name := command_args.String("name")
+	position, errorResponse := ch.stoppedPosition()
+	if errorResponse != nil {
+		return errorResponse
+	}
+	err := ch.poses.Set(name, *position)
+	if err != nil {
+		return poseErrorResponse(command, "name", name, err)
+	}
+
+	logger.InfoContext(ctx, "Pose saved", "pose", name)
+	ch.events.Publish(&Event{Topic: TOPIC_POSES, Name: "saved", Joints: position, Details: []string{name}})
+	return &JointsAnglesResponse{Code: RESPONSE_OK, Joints: *position}
+}
+
+func (ch *CommandHandler) addPoseCommandHandler(ctx context.Context, command CommandIdentifier, command_args Arguments) Response {
+	name := command_args.String("name")
+	joints := jointsAnglesFromArguments(command_args)
+	err := ch.robot.CheckLimits(joints)
+	if err != nil {
+		return &ErrorResponse{Code: RESPONSE_ROBOT_CANNOT_EXECUTE_COMMAND_ERROR, Err: err}
+	}
+	err = ch.poses.Add(name, joints)
+	if err != nil {
+		return poseErrorResponse(command, "name", name, err)
+	}
+
+	logger.InfoContext(ctx, "Pose added", "pose", name)
+	ch.events.Publish(&Event{Topic: TOPIC_POSES, Name: "saved", Joints: &joints, Details: []string{name}})
+	return &JointsAnglesResponse{Code: RESPONSE_OK, Joints: joints}
+}
+
+func (ch *CommandHandler) listPosesCommandHandler() Response {
+	return &ResponseWithStringArguments{Code: RESPONSE_OK, Args: ch.poses.Names()}
+}
+
+func (ch *CommandHandler) renamePoseCommandHandler(ctx context.Context, command CommandIdentifier, command_args Arguments) Response {
+	from, to := command_args.String("from"), command_args.String("to")
+	err := ch.poses.Rename(from, to)
+	var taken *pose.NameTakenError
+	if errors.As(err, &taken) {
+		return poseErrorResponse(command, "to", to, err)
+	}
+	if err != nil {
+		return poseErrorResponse(command, "from", from, err)
+	}
+
+	logger.InfoContext(ctx, "Pose renamed", "pose", from, "to", to)
+	ch.events.Publish(&Event{Topic: TOPIC_POSES, Name: "renamed", Details: []string{from, to}})
+	return &ResponseWithStringArguments{Code: RESPONSE_OK, Args: []string{to}}
+}
+
+func (ch *CommandHandler) deletePoseCommandHandler(ctx context.Context, command CommandIdentifier, command_args Arguments) Response {
+	name := command_args.String("name")
+	err := ch.poses.Delete(name)
+	if err != nil {
+		return poseErrorResponse(command, "name", name, err)
+	}
+
+	logger.InfoContext(ctx, "Pose deleted", "pose", name)
+	ch.events.Publish(&Event{Topic: TOPIC_POSES, Name: "deleted", Details: []string{name}})
+	return &BaseResponse{Code: RESPONSE_OK}
+}
@@ -0,0 +1,346 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/xTaube/vr-controlled-robot-arm/gcode"
+	"github.com/xTaube/vr-controlled-robot-arm/kinematics"
+	"github.com/xTaube/vr-controlled-robot-arm/program"
+	"github.com/xTaube/vr-controlled-robot-arm/robot"
+	"github.com/xTaube/vr-controlled-robot-arm/storage"
+)
+
+func ExclusiveMiddleware(call *CommandCall, next CommandFunc) Response {
+	if call.Definition.Exclusive {
+		if name := call.Handler.programs.Running(); name != "" {
+			return &ErrorResponse{Code: RESPONSE_PROGRAM_ERROR, Err: &ProgramRunningError{name}}
+		}
+		if name := call.Handler.scripts.Running(); name != "" {
+			return &ErrorResponse{Code: RESPONSE_SCRIPT_ERROR, Err: &ScriptRunningError{name}}
+		}
+	}
+	return next(call)
+}
+
+type ProgramStateError struct {
+	reason string
+}
+
+func (err *ProgramStateError) Error() string {
+	return err.reason
+}
+
+type ProgramRunningError struct {
+	name string
+}
+
+// A paused program counts as running, it resumes from where the arm stopped.
+func (err *ProgramRunningError) Error() string {
+	return fmt.Sprintf("Program %q is running or paused, abort it first.", err.name)
+}
+
+// programRun is a taught or G-code program played back step by step, play
+// performs step i and returns the event reporting it.
+type programRun struct {
+	name   string
+	steps  int
+	play   func(ctx context.Context, i int) (*Event, error)
+	cancel context.CancelFunc
+	done   chan struct{}
+	// Closed on resume, nil while the run is not paused.
+	resumed chan struct{}
+}
+
+// ProgramRunner plays back one program at a time for the whole server, so a
+// run outlives the session that started it. Pausing and aborting take effect
+// once the step in progress, a waypoint or a G-code line, is done.
+type ProgramRunner struct {
+	robot    *robot.Robot
+	events   *EventBus
+	store    *program.Store
+	gcodes   *gcode.Store
+	geometry *kinematics.Geometry
+
+	mu      sync.Mutex
+	running *programRun
+}
+
+func (r *ProgramRunner) Store() *program.Store {
+	return r.store
+}
+
+func (r *ProgramRunner) GCodeStore() *gcode.Store {
+	return r.gcodes
+}
+
+// Geometry is nil unless kinematics are configured, G-code programs then only run joint moves.
+func (r *ProgramRunner) Geometry() *kinematics.Geometry {
+	return r.geometry
+}
+
+// Running returns the name of the running program, empty when there is none.
+func (r *ProgramRunner) Running() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running == nil {
+		return ""
+	}
+	return r.running.name
+}
+
+func (r *ProgramRunner) start(run *programRun) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running != nil {
+		return &ProgramRunningError{r.running.name}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	run.cancel, run.done = cancel, make(chan struct{})
+	r.running = run
+	go r.run(ctx, run)
+	return nil
+}
+
+func (r *ProgramRunner) Start(taught *program.Program) error {
+	total := strconv.Itoa(len(taught.Waypoints))
+	return r.start(&programRun{
+		name:  taught.Name,
+		steps: len(taught.Waypoints),
+		play: func(ctx context.Context, i int) (*Event, error) {
+			waypoint := taught.Waypoints[i]
+			err := r.playWaypoint(ctx, waypoint)
+			if err != nil {
+				return nil, err
+			}
+			joints := waypoint.Joints
+			return &Event{Topic: TOPIC_PROGRAM, Name: "waypoint", Joints: &joints, Details: []string{taught.Name, strconv.Itoa(i + 1), total}}, nil
+		},
+	})
+}
+
+// StartGCode reports each line run with the joints the arm stands at afterwards.
+func (r *ProgramRunner) StartGCode(program *gcode.Program) error {
+	executor := gcode.InitExecutor(r.robot, r.geometry)
+	total := strconv.Itoa(len(program.Blocks))
+	return r.start(&programRun{
+		name:  program.Name,
+		steps: len(program.Blocks),
+		play: func(ctx context.Context, i int) (*Event, error) {
+			block := program.Blocks[i]
+			joints, err := executor.Execute(ctx, block)
+			if err != nil {
+				return nil, err
+			}
+			return &Event{Topic: TOPIC_PROGRAM, Name: "line", Joints: joints, Details: []string{program.Name, strconv.Itoa(block.Line), strconv.Itoa(i + 1), total}}, nil
+		},
+	})
+}
+
+func (r *ProgramRunner) Pause() (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running == nil {
+		return "", &ProgramStateError{"No program is running."}
+	}
+	if r.running.resumed != nil {
+		return "", &ProgramStateError{fmt.Sprintf("Program %q is already paused.", r.running.name)}
+	}
+	r.running.resumed = make(chan struct{})
+	r.events.Publish(&Event{Topic: TOPIC_PROGRAM, Name: "paused", Details: []string{r.running.name}})
+	return r.running.name, nil
+}
+
+func (r *ProgramRunner) Resume() (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running == nil || r.running.resumed == nil {
+		return "", &ProgramStateError{"No program is paused."}
+	}
+	close(r.running.resumed)
+	r.running.resumed = nil
+	r.events.Publish(&Event{Topic: TOPIC_PROGRAM, Name: "resumed", Details: []string{r.running.name}})
+	return r.running.name, nil
+}
+
+// Abort returns without waiting for the run to end, done is closed when it did.
+func (r *ProgramRunner) Abort() (string, <-chan struct{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running == nil {
+		return "", nil, &ProgramStateError{"No program is running."}
+	}
+	r.running.cancel()
+	return r.running.name, r.running.done, nil
+}
+
+func (r *ProgramRunner) waitWhilePaused(ctx context.Context, run *programRun) error {
+	r.mu.Lock()
+	resumed := run.resumed
+	r.mu.Unlock()
+	if resumed == nil {
+		return nil
+	}
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *ProgramRunner) playWaypoint(ctx context.Context, waypoint program.Waypoint) error {
+	err := r.robot.MoveAndWait(ctx, waypoint.Joints)
+	if err != nil {
+		return err
+	}
+
+	gripper := waypoint.GripperState()
+	if gripper == robot.GRIPPER_UNKNOWN || gripper == r.robot.GripperState() {
+		return nil
+	}
+	if gripper == robot.GRIPPER_OPEN {
+		return r.robot.OpenGripper()
+	}
+	return r.robot.CloseGripper()
+}
+
+func (r *ProgramRunner) run(ctx context.Context, run *programRun) {
+	defer close(run.done)
+	defer func() {
+		r.mu.Lock()
+		r.running = nil
+		r.mu.Unlock()
+		run.cancel()
+	}()
+
+	name := run.name
+	logger.Info("Program started", "program", name, "steps", run.steps)
+	r.events.Publish(&Event{Topic: TOPIC_PROGRAM, Name: "started", Details: []string{name, strconv.Itoa(run.steps)}})
+
+	for i := 0; i < run.steps; i++ {
+		var event *Event
+		err := r.waitWhilePaused(ctx, run)
+		if err == nil {
+			err = ctx.Err()
+		}
+		if err == nil {
+			event, err = run.play(ctx, i)
+		}
+		if ctx.Err() != nil {
+			logger.Info("Program aborted", "program", name, "step", i+1)
+			r.events.Publish(&Event{Topic: TOPIC_PROGRAM, Name: "aborted", Details: []string{name, strconv.Itoa(i + 1), "aborted by operator"}})
+			return
+		}
+		if err != nil {
+			logger.Warn("Program failed", "program", name, "step", i+1, "error", err)
+			r.events.PublishFault("program", err)
+			r.events.Publish(&Event{Topic: TOPIC_PROGRAM, Name: "aborted", Details: []string{name, strconv.Itoa(i + 1), err.Error()}})
+			return
+		}
+
+		r.events.Publish(event)
+	}
+
+	logger.Info("Program finished", "program", name)
+	r.events.Publish(&Event{Topic: TOPIC_PROGRAM, Name: "finished", Details: []string{name}})
+}
+
+func (ch *CommandHandler) teachStartCommandHandler(ctx context.Context, command_args Arguments) Response {
+	name := command_args.String("name")
+	if ch.teaching != nil {
+		logger.InfoContext(ctx, "Discarding program being taught", "program", ch.teaching.Name, "waypoints", len(ch.teaching.Waypoints))
+	}
+	ch.teaching = program.InitProgram(name)
+	return &ResponseWithStringArguments{Code: RESPONSE_OK, Args: []string{name}}
+}
+
+// A waypoint is the position the arm stopped at together with the gripper state.
+func (ch *CommandHandler) teachAddWaypointCommandHandler() Response {
+	if ch.teaching == nil {
+		return &ErrorResponse{Code: RESPONSE_PROGRAM_ERROR, Err: &ProgramStateError{"Not teaching, send TEACH_START first."}}
+	}
+	position, errorResponse := ch.stoppedPosition()
+	if errorResponse != nil {
+		return errorResponse
+	}
+
+	ch.teaching.AddWaypoint(*position, ch.robot.GripperState())
+	return &JointsAnglesResponse{Code: RESPONSE_OK, Joints: *position}
+}
+
+func (ch *CommandHandler) teachSaveCommandHandler(ctx context.Context) Response {
+	if ch.teaching == nil {
+		return &ErrorResponse{Code: RESPONSE_PROGRAM_ERROR, Err: &ProgramStateError{"Not teaching, send TEACH_START first."}}
+	}
+	if len(ch.teaching.Waypoints) == 0 {
+		return &ErrorResponse{Code: RESPONSE_PROGRAM_ERROR, Err: &ProgramStateError{fmt.Sprintf("Program %q has no waypoints.", ch.teaching.Name)}}
+	}
+	err := ch.programs.Store().Save(ch.teaching)
+	if err != nil {
+		return &ErrorResponse{Code: RESPONSE_PROGRAM_ERROR, Err: err}
+	}
+
+	logger.InfoContext(ctx, "Program saved", "program", ch.teaching.Name, "waypoints", len(ch.teaching.Waypoints))
+	response := &ResponseWithStringArguments{Code: RESPONSE_OK, Args: []string{ch.teaching.Name, strconv.Itoa(len(ch.teaching.Waypoints))}}
+	ch.teaching = nil
+	return response
+}
+
+func (ch *CommandHandler) runProgramCommandHandler(command CommandIdentifier, command_args Arguments) Response {
+	name := command_args.String("name")
+	taught, err := ch.programs.Store().Load(name)
+	var notFound *storage.NotFoundError
+	if errors.As(err, &notFound) {
+		return &ErrorResponse{Code: RESPONSE_INVALID_ARGUMENT_ERROR, Err: &InvalidArgumentError{command.String(), "name", fmt.Sprintf("no program named %q", name)}}
+	}
+	if err != nil {
+		return &ErrorResponse{Code: RESPONSE_PROGRAM_ERROR, Err: err}
+	}
+	calibrated, err := ch.robot.IsCalibrated()
+	if err != nil {
+		return ch.robotErrorResponse(err)
+	}
+	if !calibrated {
+		return &ErrorResponse{Code: RESPONSE_ROBOT_CANNOT_EXECUTE_COMMAND_ERROR, Err: &robot.RobotError{Code: robot.ROBOT_NOT_CALIBRATED_ERROR}}
+	}
+
+	err = ch.programs.Start(taught)
+	if err != nil {
+		return &ErrorResponse{Code: RESPONSE_PROGRAM_ERROR, Err: err}
+	}
+	return &ResponseWithStringArguments{Code: RESPONSE_OK, Args: []string{name, strconv.Itoa(len(taught.Waypoints))}}
+}
+
+func (ch *CommandHandler) pauseProgramCommandHandler() Response {
+	name, err := ch.programs.Pause()
+	if err != nil {
+		return &ErrorResponse{Code: RESPONSE_PROGRAM_ERROR, Err: err}
+	}
+	return &ResponseWithStringArguments{Code: RESPONSE_OK, Args: []string{name}}
+}
+
+func (ch *CommandHandler) resumeProgramCommandHandler() Response {
+	name, err := ch.programs.Resume()
+	if err != nil {
+		return &ErrorResponse{Code: RESPONSE_PROGRAM_ERROR, Err: err}
+	}
+	return &ResponseWithStringArguments{Code: RESPONSE_OK, Args: []string{name}}
+}
+
+func (ch *CommandHandler) abortProgramCommandHandler() Response {
+	name, _, err := ch.programs.Abort()
+	if err != nil {
+		return &ErrorResponse{Code: RESPONSE_PROGRAM_ERROR, Err: err}
+	}
+	return &ResponseWithStringArguments{Code: RESPONSE_OK, Args: []string{name}}
+}
+
+// A nil geometry leaves G-code programs to joint moves.
+func InitProgramRunner(robot *robot.Robot, events *EventBus, store *program.Store, gcodes *gcode.Store, geometry *kinematics.Geometry) *ProgramRunner {
+	return &ProgramRunner{robot: robot, events: events, store: store, gcodes: gcodes, geometry: geometry}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xTaube/vr-controlled-robot-arm/program"
+	"github.com/xTaube/vr-controlled-robot-arm/robot"
+)
+
+// Each waypoint turns the base half a degree, so every one takes a poll of the arm.
+func testProgram(waypoints int) *program.Program {
+	taught := program.InitProgram("demo")
+	for i := range waypoints {
+		taught.AddWaypoint(robot.JointsAngles{Z: float32(i+1) / 2, Y: -90}, robot.GRIPPER_UNKNOWN)
+	}
+	return taught
+}
+
+func subscribePrograms(events *EventBus) chan *Event {
+	published := make(chan *Event, 32)
+	events.Subscribe(func(event *Event) { published <- event }).Add(TOPIC_PROGRAM)
+	return published
+}
+
+func expectEvents(t *testing.T, published chan *Event, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		select {
+		case event := <-published:
+			if event.Name != name {
+				t.Fatalf("got %s %v, want %s", event.Name, event.Details, name)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no %s event", name)
+		}
+	}
+}
+
+func TestProgramRunnerPlaysBackWaypoints(t *testing.T) {
+	controlServer := initTestControlServer(t)
+	published := subscribePrograms(controlServer.events)
+
+	if err := controlServer.programs.Start(testProgram(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := controlServer.programs.Start(testProgram(1)); err == nil {
+		t.Error("a second program started beside the first")
+	}
+	expectEvents(t, published, "started", "waypoint", "waypoint", "finished")
+
+	position, err := controlServer.robot.GetCurrentPosition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if position.Z != 1 {
+		t.Errorf("arm stopped at Z%g, want the last waypoint Z1", position.Z)
+	}
+}
+
+func TestPausedProgramsBlockTheArmUntilAborted(t *testing.T) {
+	controlServer := initTestControlServer(t)
+	runner := controlServer.programs
+	published := subscribePrograms(controlServer.events)
+
+	if err := runner.Start(testProgram(3)); err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(t, published, "started")
+	if _, err := runner.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	// The waypoint in progress is finished before the run waits.
+	expectEvents(t, published, "paused", "waypoint")
+	select {
+	case event := <-published:
+		t.Fatalf("got %s while paused", event.Name)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	call := &CommandCall{
+		Handler:    &CommandHandler{programs: runner, scripts: controlServer.scripts},
+		Definition: COMMAND_REGISTRY[MOVE_ROBOT],
+	}
+	response := ExclusiveMiddleware(call, func(call *CommandCall) Response {
+		t.Error("MOVE_ROBOT ran while a program is paused")
+		return &BaseResponse{Code: RESPONSE_OK}
+	})
+	errorResponse, ok := response.(*ErrorResponse)
+	if !ok || errorResponse.Code != RESPONSE_PROGRAM_ERROR || errorResponse.Err.Error() != `Program "demo" is running or paused, abort it first.` {
+		t.Errorf("got %+v", response)
+	}
+
+	if _, err := runner.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runner.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(t, published, "resumed", "paused", "waypoint")
+
+	_, done, err := runner.Abort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	expectEvents(t, published, "aborted")
+	if name := runner.Running(); name != "" {
+		t.Errorf("%q still running after abort", name)
+	}
+}
@@ -1,10 +1,15 @@
 package server
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"net/http"
 	"strconv"
 	"strings"
+
+	"github.com/xTaube/vr-controlled-robot-arm/codec"
+	"github.com/xTaube/vr-controlled-robot-arm/robot"
 )
 
 type ResponseCode byte
@@ -22,22 +27,148 @@ const (
 	RESPONSE_INVALID_PARAMETERS_NUMBER_ERROR
 	RESPONSE_ROBOT_CANNOT_EXECUTE_COMMAND_ERROR
 	RESPONSE_ROBOT_CALIBRATION_ERROR
+	RESPONSE_MALFORMED_REQUEST_ERROR
+	RESPONSE_INVALID_ARGUMENT_ERROR
+	RESPONSE_CONTROL_LEASE_ERROR
+	RESPONSE_FORBIDDEN_ERROR
+	RESPONSE_PROGRAM_ERROR
+	RESPONSE_SCRIPT_ERROR
 )
 
-func readFloat32(data string) float32 {
-	float, _ := strconv.ParseFloat(data, 32)
-	return float32(float)
+func (c ErrorCode) Name() string {
+	switch c {
+	case RESPONSE_UNKNOWN_COMMAND_ERROR:
+		return "UNKNOWN_COMMAND"
+	case RESPONSE_STREAM_ERROR:
+		return "STREAM_ERROR"
+	case RESPONSE_INVALID_PARAMETERS_NUMBER_ERROR:
+		return "INVALID_PARAMETERS_NUMBER"
+	case RESPONSE_ROBOT_CANNOT_EXECUTE_COMMAND_ERROR:
+		return "ROBOT_CANNOT_EXECUTE_COMMAND"
+	case RESPONSE_ROBOT_CALIBRATION_ERROR:
+		return "ROBOT_CALIBRATION_ERROR"
+	case RESPONSE_MALFORMED_REQUEST_ERROR:
+		return "MALFORMED_REQUEST"
+	case RESPONSE_INVALID_ARGUMENT_ERROR:
+		return "INVALID_ARGUMENT"
+	case RESPONSE_CONTROL_LEASE_ERROR:
+		return "CONTROL_LEASE"
+	case RESPONSE_FORBIDDEN_ERROR:
+		return "FORBIDDEN"
+	case RESPONSE_PROGRAM_ERROR:
+		return "PROGRAM_ERROR"
+	case RESPONSE_SCRIPT_ERROR:
+		return "SCRIPT_ERROR"
+	default:
+		return "UNKNOWN_ERROR"
+	}
+}
+
+func (c ErrorCode) Message() string {
+	switch c {
+	case RESPONSE_UNKNOWN_COMMAND_ERROR:
+		return "Unknown command."
+	case RESPONSE_STREAM_ERROR:
+		return "Video stream error."
+	case RESPONSE_INVALID_PARAMETERS_NUMBER_ERROR:
+		return "Invalid number of parameters."
+	case RESPONSE_ROBOT_CANNOT_EXECUTE_COMMAND_ERROR:
+		return "Robot cannot execute command."
+	case RESPONSE_ROBOT_CALIBRATION_ERROR:
+		return "Robot calibration failed."
+	case RESPONSE_MALFORMED_REQUEST_ERROR:
+		return "Malformed request."
+	case RESPONSE_INVALID_ARGUMENT_ERROR:
+		return "Invalid argument."
+	case RESPONSE_CONTROL_LEASE_ERROR:
+		return "Control is held by another session."
+	case RESPONSE_FORBIDDEN_ERROR:
+		return "Command not permitted for this role."
+	case RESPONSE_PROGRAM_ERROR:
+		return "Program cannot be taught or run in this state."
+	case RESPONSE_SCRIPT_ERROR:
+		return "Script cannot be stored or run in this state."
+	default:
+		return "Unknown error."
+	}
+}
+
+func (c ErrorCode) HTTPStatus() int {
+	switch c {
+	case RESPONSE_UNKNOWN_COMMAND_ERROR:
+		return http.StatusNotFound
+	case RESPONSE_INVALID_PARAMETERS_NUMBER_ERROR, RESPONSE_MALFORMED_REQUEST_ERROR:
+		return http.StatusBadRequest
+	case RESPONSE_INVALID_ARGUMENT_ERROR:
+		return http.StatusUnprocessableEntity
+	case RESPONSE_ROBOT_CANNOT_EXECUTE_COMMAND_ERROR, RESPONSE_ROBOT_CALIBRATION_ERROR, RESPONSE_CONTROL_LEASE_ERROR, RESPONSE_PROGRAM_ERROR, RESPONSE_SCRIPT_ERROR:
+		return http.StatusConflict
+	case RESPONSE_FORBIDDEN_ERROR:
+		return http.StatusForbidden
+	case RESPONSE_STREAM_ERROR:
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func parseCommandIdentifier(data string) (CommandIdentifier, error) {
+	command_id, err := strconv.ParseUint(data, 10, 8)
+	if err != nil {
+		return 0, &MalformedRequestError{fmt.Sprintf("invalid command identifier %q", data)}
+	}
+	return CommandIdentifier(command_id), nil
 }
 
-func ParseRequestArguments(request string) (CommandIdentifier, []string) {
+func ParseRequestArguments(request string) (CommandIdentifier, []string, error) {
 	arguments := strings.Split(request, "$")
-	log.Printf("%s\n", arguments[0])
-	command_id, _ := strconv.ParseInt(arguments[0], 10, 8)
-	return CommandIdentifier(command_id), arguments[1:]
+	command_id, err := parseCommandIdentifier(arguments[0])
+	if err != nil {
+		return 0, nil, err
+	}
+	return command_id, arguments[1:], nil
 }
 
 type Response interface {
 	Parse() []byte
+	ParseJSON() []byte
+	ParseBinary() *codec.Frame
+}
+
+func responseCodeName(response Response) string {
+	if errorResponse, ok := response.(*ErrorResponse); ok {
+		return errorResponse.Code.Name()
+	}
+	return "OK"
+}
+
+type jsonError struct {
+	Code      ErrorCode             `json:"code"`
+	Name      string                `json:"name"`
+	Message   string                `json:"message"`
+	RobotCode *robot.RobotErrorCode `json:"robot_code,omitempty"`
+	Argument  string                `json:"argument,omitempty"`
+}
+
+type jsonResponse struct {
+	Type   string     `json:"type"`
+	Status string     `json:"status"`
+	Code   byte       `json:"code"`
+	Data   any        `json:"data,omitempty"`
+	Error  *jsonError `json:"error,omitempty"`
+}
+
+func marshalJSONResponse(response any) []byte {
+	data, err := json.Marshal(response)
+	if err != nil {
+		logger.Error("Cannot encode JSON response", "error", err)
+		return []byte(fmt.Sprintf(`{"type":"response","status":"error","code":%d}`, RESPONSE_UNKNOWN_ERROR))
+	}
+	return data
+}
+
+func okJSONResponse(code ResponseCode, data any) []byte {
+	return marshalJSONResponse(jsonResponse{Type: "response", Status: "ok", Code: byte(code), Data: data})
 }
 
 type BaseResponse struct {
@@ -48,6 +179,14 @@ func (r *BaseResponse) Parse() []byte {
 	return []byte(fmt.Sprintf("%d", r.Code))
 }
 
+func (r *BaseResponse) ParseJSON() []byte {
+	return okJSONResponse(r.Code, nil)
+}
+
+func (r *BaseResponse) ParseBinary() *codec.Frame {
+	return &codec.Frame{Type: codec.FRAME_RESPONSE, Code: byte(r.Code)}
+}
+
 type ResponseWithFloat32Arguments struct {
 	Code ResponseCode
 	Args []float32
@@ -61,6 +200,14 @@ func (r *ResponseWithFloat32Arguments) Parse() []byte {
 	return []byte(response)
 }
 
+func (r *ResponseWithFloat32Arguments) ParseJSON() []byte {
+	return okJSONResponse(r.Code, map[string]any{"args": r.Args})
+}
+
+func (r *ResponseWithFloat32Arguments) ParseBinary() *codec.Frame {
+	return &codec.Frame{Type: codec.FRAME_RESPONSE, Code: byte(r.Code), Floats: r.Args}
+}
+
 type ResponseWithStringArguments struct {
 	Code ResponseCode
 	Args []string
@@ -74,11 +221,193 @@ func (r *ResponseWithStringArguments) Parse() []byte {
 	return []byte(response)
 }
 
+func (r *ResponseWithStringArguments) ParseJSON() []byte {
+	return okJSONResponse(r.Code, map[string]any{"args": r.Args})
+}
+
+func (r *ResponseWithStringArguments) ParseBinary() *codec.Frame {
+	return &codec.Frame{Type: codec.FRAME_RESPONSE, Code: byte(r.Code), Strings: r.Args}
+}
+
+type JointsAnglesResponse struct {
+	Code   ResponseCode
+	Joints robot.JointsAngles
+}
+
+func (r *JointsAnglesResponse) Parse() []byte {
+	return []byte(fmt.Sprintf("%d$%f$%f$%f$%f$%f", r.Code, r.Joints.Z, r.Joints.Y, r.Joints.X, r.Joints.V, r.Joints.W))
+}
+
+func (r *JointsAnglesResponse) ParseJSON() []byte {
+	return okJSONResponse(r.Code, map[string]float32{
+		"x": r.Joints.X,
+		"y": r.Joints.Y,
+		"z": r.Joints.Z,
+		"v": r.Joints.V,
+		"w": r.Joints.W,
+	})
+}
+
+func (r *JointsAnglesResponse) ParseBinary() *codec.Frame {
+	return &codec.Frame{
+		Type:   codec.FRAME_RESPONSE,
+		Code:   byte(r.Code),
+		Floats: []float32{r.Joints.Z, r.Joints.Y, r.Joints.X, r.Joints.V, r.Joints.W},
+	}
+}
+
+type StreamsResponse struct {
+	Code      ResponseCode
+	Addresses []string
+}
+
+func (r *StreamsResponse) Parse() []byte {
+	return []byte(strings.Join(append([]string{fmt.Sprintf("%d", r.Code)}, r.Addresses...), "$"))
+}
+
+func (r *StreamsResponse) ParseJSON() []byte {
+	return okJSONResponse(r.Code, map[string]any{"streams": r.Addresses})
+}
+
+func (r *StreamsResponse) ParseBinary() *codec.Frame {
+	return &codec.Frame{Type: codec.FRAME_RESPONSE, Code: byte(r.Code), Strings: r.Addresses}
+}
+
+type CommandCatalogueResponse struct {
+	Code     ResponseCode
+	Commands []CommandDescription
+}
+
+// Over text and binary every command is one JSON string argument. '$' separates
+// text arguments, so it is escaped, which leaves the JSON unchanged once decoded.
+func (r *CommandCatalogueResponse) strings() []string {
+	commands := make([]string, 0, len(r.Commands))
+	for _, command := range r.Commands {
+		data, err := json.Marshal(command)
+		if err != nil {
+			logger.Error("Cannot encode command description", "command", command.Name, "error", err)
+			continue
+		}
+		commands = append(commands, strings.ReplaceAll(string(data), "$", `\u0024`))
+	}
+	return commands
+}
+
+func (r *CommandCatalogueResponse) Parse() []byte {
+	return []byte(strings.Join(append([]string{fmt.Sprintf("%d", r.Code)}, r.strings()...), "$"))
+}
+
+func (r *CommandCatalogueResponse) ParseJSON() []byte {
+	return okJSONResponse(r.Code, map[string]any{"commands": r.Commands})
+}
+
+func (r *CommandCatalogueResponse) ParseBinary() *codec.Frame {
+	return &codec.Frame{Type: codec.FRAME_RESPONSE, Code: byte(r.Code), Strings: r.strings()}
+}
+
+// PromptAction is a command answering a prompt and what it does, "confirm" for instance.
+type PromptAction struct {
+	Command CommandIdentifier
+	Action  string
+}
+
+// PromptResponse asks for the next command of a workflow. Each protocol words
+// the prompt the way its clients send those commands.
+type PromptResponse struct {
+	Code ResponseCode
+	// What the workflow waits for, the actions are appended to it.
+	Message string
+	Actions []PromptAction
+}
+
+func (r *PromptResponse) prompt(describe func(command CommandIdentifier, args []string) string) string {
+	actions := make([]string, 0, len(r.Actions))
+	for _, action := range r.Actions {
+		actions = append(actions, fmt.Sprintf("%s to %s", describe(action.Command, COMMAND_REGISTRY[action.Command].Args.Names()), action.Action))
+	}
+	return fmt.Sprintf("%s Send %s.", r.Message, strings.Join(actions, ", "))
+}
+
+func (r *PromptResponse) Parse() []byte {
+	prompt := r.prompt(func(command CommandIdentifier, args []string) string {
+		if definition := COMMAND_REGISTRY[command]; definition.TextID != 0 {
+			command = definition.TextID
+		}
+		message := codec.TextMessage{Head: fmt.Sprintf("%d", command)}
+		for _, arg := range args {
+			message.Args = append(message.Args, "{"+arg+"}")
+		}
+		return fmt.Sprintf("'%s'", codec.EncodeText(message))
+	})
+	return []byte(fmt.Sprintf("%d$%s", r.Code, prompt))
+}
+
+type jsonPromptAction struct {
+	Command string   `json:"command"`
+	Action  string   `json:"action"`
+	Params  []string `json:"params"`
+}
+
+func (r *PromptResponse) ParseJSON() []byte {
+	actions := make([]jsonPromptAction, 0, len(r.Actions))
+	for _, action := range r.Actions {
+		actions = append(actions, jsonPromptAction{action.Command.String(), action.Action, COMMAND_REGISTRY[action.Command].Args.Names()})
+	}
+	prompt := r.prompt(func(command CommandIdentifier, args []string) string {
+		if len(args) == 0 {
+			return fmt.Sprintf("%q", command)
+		}
+		return fmt.Sprintf("%q with params %s", command, strings.Join(args, ", "))
+	})
+	return marshalJSONResponse(jsonResponse{
+		Type:   "prompt",
+		Status: "ok",
+		Code:   byte(r.Code),
+		Data:   map[string]any{"prompt": prompt, "actions": actions},
+	})
+}
+
+func (r *PromptResponse) ParseBinary() *codec.Frame {
+	prompt := r.prompt(func(command CommandIdentifier, args []string) string {
+		if len(args) == 0 {
+			return fmt.Sprintf("code %d", command)
+		}
+		return fmt.Sprintf("code %d with floats %s", command, strings.Join(args, ", "))
+	})
+	return &codec.Frame{Type: codec.FRAME_PROMPT, Code: byte(r.Code), Strings: []string{prompt}}
+}
+
 type ErrorResponse struct {
 	Code ErrorCode
 	Err  error
 }
 
+func (er *ErrorResponse) message() string {
+	if er.Err == nil {
+		return er.Code.Message()
+	}
+	return er.Err.Error()
+}
+
 func (er *ErrorResponse) Parse() []byte {
-	return []byte(fmt.Sprintf("%d$%s", er.Code, er.Err))
+	return []byte(fmt.Sprintf("%d$%s", er.Code, er.message()))
+}
+
+func (er *ErrorResponse) ParseJSON() []byte {
+	details := &jsonError{Code: er.Code, Name: er.Code.Name(), Message: er.message()}
+
+	var robotErr *robot.RobotError
+	if errors.As(er.Err, &robotErr) {
+		details.RobotCode = &robotErr.Code
+	}
+	var argumentErr *InvalidArgumentError
+	if errors.As(er.Err, &argumentErr) {
+		details.Argument = argumentErr.Argument
+	}
+
+	return marshalJSONResponse(jsonResponse{Type: "response", Status: "error", Code: byte(er.Code), Error: details})
+}
+
+func (er *ErrorResponse) ParseBinary() *codec.Frame {
+	return &codec.Frame{Type: codec.FRAME_RESPONSE, Code: byte(er.Code), Strings: []string{er.message()}}
 }
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	RECORD_SESSION  = "session"
+	RECORD_REQUEST  = "request"
+	RECORD_RESPONSE = "response"
+)
+
+// RecordEntry is one line of a recording. Requests are numbered in the order
+// the session received them and responses carry the number of the request they
+// answer, a calibration answers its trigger more than once.
+type RecordEntry struct {
+	Kind      string            `json:"kind"`
+	Time      float64           `json:"t"`
+	Seq       uint64            `json:"seq,omitempty"`
+	Command   CommandIdentifier `json:"command,omitempty"`
+	Name      string            `json:"name,omitempty"`
+	Args      []string          `json:"args,omitempty"`
+	Response  json.RawMessage   `json:"response,omitempty"`
+	Session   string            `json:"session,omitempty"`
+	Transport string            `json:"transport,omitempty"`
+	Principal string            `json:"principal,omitempty"`
+	Started   string            `json:"started,omitempty"`
+}
+
+// Recorder writes a session's requests and responses to a JSON lines file,
+// times are seconds since the session started.
+type Recorder struct {
+	mu       sync.Mutex
+	file     *os.File
+	writer   *bufio.Writer
+	encoder  *json.Encoder
+	started  time.Time
+	sequence map[*Request]uint64
+	last     uint64
+	failed   bool
+}
+
+func (r *Recorder) write(entry *RecordEntry) {
+	entry.Time = time.Since(r.started).Seconds()
+	err := r.encoder.Encode(entry)
+	if err == nil {
+		err = r.writer.Flush()
+	}
+	if err != nil && !r.failed {
+		r.failed = true
+		logger.Warn("Cannot write session recording", "path", r.file.Name(), "error", err)
+	}
+}
+
+func (r *Recorder) Request(request *Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.last++
+	r.sequence[request] = r.last
+	r.write(&RecordEntry{Kind: RECORD_REQUEST, Seq: r.last, Command: request.Command, Name: request.Command.String(), Args: request.Args})
+}
+
+// Responses to requests that were never received, e.g. malformed ones, are not recorded.
+func (r *Recorder) Response(request *Request, response Response) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	seq, ok := r.sequence[request]
+	if !ok {
+		return
+	}
+	r.write(&RecordEntry{Kind: RECORD_RESPONSE, Seq: seq, Response: response.ParseJSON()})
+}
+
+// Done forgets a request once its handler returned, no more responses can follow.
+func (r *Recorder) Done(request *Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.sequence, request)
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.writer.Flush()
+	return r.file.Close()
+}
+
+func CreateRecorder(dir string, identity SessionIdentity) (*Recorder, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	started := time.Now()
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s.jsonl", started.Format("20060102-150405"), identity.ID))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	writer := bufio.NewWriter(file)
+	recorder := &Recorder{
+		file:     file,
+		writer:   writer,
+		encoder:  json.NewEncoder(writer),
+		started:  started,
+		sequence: map[*Request]uint64{},
+	}
+	recorder.write(&RecordEntry{
+		Kind:      RECORD_SESSION,
+		Session:   identity.ID,
+		Transport: identity.Transport,
+		Principal: identity.Principal.String(),
+		Started:   started.Format(time.RFC3339Nano),
+	})
+	return recorder, nil
+}
@@ -0,0 +1,535 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/xTaube/vr-controlled-robot-arm/auth"
+	"github.com/xTaube/vr-controlled-robot-arm/storage"
+)
+
+// CommandDefinition is everything the server knows about a command. Defining one
+// makes it known to validation, authorization, dispatch and LIST_COMMANDS.
+type CommandDefinition struct {
+	ID          CommandIdentifier
+	Name        string
+	Description string
+	Args        CommandSchema
+	// Least role allowed to issue the command.
+	Role auth.Role
+	// Set for commands that drive the arm or its cameras, only the holder of the control lease may send them.
+	// Pausing or aborting a program and stopping a script are not, stopping the arm should never wait for the lease.
+	Control bool
+	// Set for commands that would fight a running program or script for the arm.
+	Exclusive bool
+	// Set for commands only the workflow started by this command accepts, they have no Handle.
+	Within CommandIdentifier
+	// Id text clients written before the command had one of its own send for it within the workflow.
+	TextID CommandIdentifier
+	Handle func(ch *CommandHandler, call *CommandCall) Response
+}
+
+var COMMAND_REGISTRY = map[CommandIdentifier]*CommandDefinition{}
+
+// defineCommand registers a command and returns its id, which is written
+// nowhere but in the definition.
+func defineCommand(definition *CommandDefinition) CommandIdentifier {
+	if defined, ok := COMMAND_REGISTRY[definition.ID]; ok {
+		panic(fmt.Sprintf("%s and %s share the command id %d", defined.Name, definition.Name, definition.ID))
+	}
+	COMMAND_REGISTRY[definition.ID] = definition
+	return definition.ID
+}
+
+var START_VIDEO_STREAM = defineCommand(&CommandDefinition{
+	ID:          1,
+	Name:        "START_VIDEO_STREAM",
+	Description: "Start streaming all cameras, answering with the stream addresses.",
+	Role:        auth.ROLE_OPERATOR,
+	Control:     true,
+	Handle: func(ch *CommandHandler, call *CommandCall) Response {
+		return ch.startVideoStreamCommandHandler(call.Context)
+	},
+})
+
+var STOP_VIDEO_STREAM = defineCommand(&CommandDefinition{
+	ID:          2,
+	Name:        "STOP_VIDEO_STREAM",
+	Description: "Stop streaming all cameras.",
+	Role:        auth.ROLE_OPERATOR,
+	Control:     true,
+	Handle: func(ch *CommandHandler, call *CommandCall) Response {
+		return ch.stopVideoStreamCommandHandler(call.Context)
+	},
+})
+
+var MOVE_ROBOT = defineCommand(&CommandDefinition{
+	ID:          3,
+	Name:        "MOVE_ROBOT",
+	Description: "Start a move to the joint angles, answering with them.",
+	Args:        jointsAnglesSchema,
+	Role:        auth.ROLE_OPERATOR,
+	Control:     true,
+	Exclusive:   true,
+	Handle: func(ch *CommandHandler, call *CommandCall) Response {
+		return ch.moveArmCommandHandler(call.Context, call.Args)
+	},
+})
+
+var SET_ROBOT_SPEED = defineCommand(&CommandDefinition{
+	ID:          4,
+	Name:        "SET_ROBOT_SPEED",
+	Description: "Set the speed of the following moves, within limits.min_speed and limits.max_speed.",
+	Args:        CommandSchema{{Name: "speed", Type: ARGUMENT_FLOAT32, Unit: "steps/s^2"}},
+	Role:        auth.ROLE_OPERATOR,
+	Control:     true,
+	Handle: func(ch *CommandHandler, call *CommandCall) Response {
+		return ch.setRobotSpeedCommandHandler(call.Context, call.Args)
+	},
+})
+
+var GET_ROBOT_CURRENT_POSITION = defineCommand(&CommandDefinition{
+	ID:          5,
+	Name:        "GET_ROBOT_CURRENT_POSITION",
+	Description: "Joint angles the arm stands at.",
+	Role:        auth.ROLE_OBSERVER,
+	Handle: func(ch *CommandHandler, call *CommandCall) Response {
+		return ch.getRobotCurrentPositionCommandHandler()
+	},
+})
+
+var CALIBRATE_ROBOT = defineCommand(&CommandDefinition{
+	ID:          6,
+	Name:        "CALIBRATE_ROBOT",
+	Description: "Start calibrating, each step is answered with the calibration commands.",
+	Role:        auth.ROLE_ADMIN,
+	Control:     true,
+	Exclusive:   true,
+	Handle: func(ch *CommandHandler, call *CommandCall) Response {
+		return ch.calibrateRobotCommandHandler(call.Request)
+	},
+})
+
+var OPEN_GRIPPER = defineCommand(&CommandDefinition{
+	ID:          7,
+	Name:        "OPEN_GRIPPER",
+	Description: "Open the gripper.",
+	Role:        auth.ROLE_OPERATOR,
+	Control:     true,
+	Exclusive:   true,
+	Handle: func(ch *CommandHandler, call *CommandCall) Response {
+		return ch.openGripperCommandHandler()
+	},
+})
+
+var CLOSE_GRIPPER = defineCommand(&CommandDefinition{
+	ID:          8,
+	Name:        "CLOSE_GRIPPER",
+	Description: "Close the gripper.",
+	Role:        auth.ROLE_OPERATOR,
+	Control:     true,
+	Exclusive:   true,
+	Handle: func(ch *CommandHandler, call *CommandCall) Response {
+		return ch.closeGripperCommandHandler()
+	},
+})
+
+var SUBSCRIBE = defineCommand(&CommandDefinition{
+	ID:          9,
+	Name:        "SUBSCRIBE",
+	Description: "Receive the events of a topic, answering with the topics subscribed to.",
+	Args:        CommandSchema{{Name: "topic", Type: ARGUMENT_STRING, Values: eventTopicNames()}},
+	Role:        auth.ROLE_OBSERVER,
+	Handle: func(ch *CommandHandler, call *CommandCall) Response {
+		return ch.subscribeCommandHandler(call.Args)
+	},
+})
+
+var UNSUBSCRIBE = defineCommand(&CommandDefinition{
+	ID:          10,
+	Name:        "UNSUBSCRIBE",
+	Description: "Stop receiving the events of a topic, answering with the topics still subscribed to.",
+	Args:        CommandSchema{{Name: "topic", Type: ARGUMENT_STRING, Values: eventTopicNames()}},
+	Role:        auth.ROLE_OBSERVER,
+	Handle: func(ch *CommandHandler, call *CommandCall) Response {
+		return ch.unsubscribeCommandHandler(call.Args)
+	},
+})
+
+var ACQUIRE_CONTROL = defineCommand(&CommandDefinition{
+	ID:          11,
+	Name:        "ACQUIRE_CONTROL",
+	Description: "Take the control lease if it is free, answering with the session id.",
+	Role:        auth.ROLE_OPERATOR,
+	Handle: func(ch *CommandHandler, call *CommandCall) Response {
+		return ch.acquireControlCommandHandler()
+	},
+})
+
+var RELEASE_CONTROL = defineCommand(&CommandDefinition{
+	ID:          12,
+	Name:        "RELEASE_CONTROL",
+	Description: "Give up the control lease.",
+	Role:        auth.ROLE_OPERATOR,
+	Handle: func(ch *CommandHandler, call *CommandCall) Response {
+		return ch.releaseControlCommandHandler()
+	},
+})
+
+var HANDOVER_CONTROL = defineCommand(&CommandDefinition{
+	ID:          13,
+	Name:        "HANDOVER_CONTROL",
+	Description: "Pass the control lease on to another session.",
+	Args:        CommandSchema{{Name: "session", Type: ARGUMENT_STRING}},
+	Role:        auth.ROLE_OPERATOR,
+	Handle: func(ch *CommandHandler, call *CommandCall) Response {
+		return ch.handoverControlCommandHandler(call.Args)
+	},
+})
+
+var HEARTBEAT = defineCommand(&CommandDefinition{
+	ID:          14,
+	Name:        "HEARTBEAT",
+	Description: "Keep the control lease alive while idle.",
+	Role:        auth.ROLE_OPERATOR,
+	Handle: func(ch *CommandHandler, call *CommandCall) Response {
+		return ch.heartbeatCommandHandler()
+	},
+})
+
+var GET_CONTROL = defineCommand(&CommandDefinition{
+	ID:          15,
+	Name:        "GET_CONTROL",
+	Description: "Id of this session and of the one holding control.",
+	Role:        auth.ROLE_OBSERVER,
+	Handle: func(ch *CommandHandler, call *CommandCall) Response {
+		return ch.getControlCommandHandler()
+	},
+})
+
+var GET_VIDEO_STREAMS = defineCommand(&CommandDefinition{
+	ID:          16,
+	Name:        "GET_VIDEO_STREAMS",
+	Description: "Addresses of the running streams.",
+	Role:        auth.ROLE_OBSERVER,
+	Handle: func(ch *CommandHandler, call *CommandCall) Response {
+		return ch.getVideoStreamsCommandHandler()
+	},
+})
+
+var TEACH_START = defineCommand(&CommandDefinition{
+	ID:          17,
+	Name:        "TEACH_START",
+	Description: "Start teaching a program in this session.",
+	Args:        CommandSchema{nameSpec},
+	Role:        auth.ROLE_OPERATOR,
+	Handle: func(ch *CommandHandler, call *CommandCall) Response {
+		return ch.teachStartCommandHandler(call.Context, call.Args)
+	},
+})
+
+var TEACH_ADD_WAYPOINT = defineCommand(&CommandDefinition{
+	ID:          18,
+	Name:        "TEACH_ADD_WAYPOINT",
+	Description: "Record where the arm stands and the gripper state as the next waypoint.",
+	Role:        auth.ROLE_OPERATOR,
+	Handle: func(ch *CommandHandler, call *CommandCall) Response {
+		return ch.teachAddWaypointCommandHandler()
+	},
+})
+
+var TEACH_SAVE = defineCommand(&CommandDefinition{
+	ID:          19,
+	Name:        "TEACH_SAVE",
+	Description: "Store the program being taught, answering with its name and number of waypoints.",
+	Role:        auth.ROLE_OPERATOR,
+	Handle: func(ch *CommandHandler, call *CommandCall) Response {
+		return ch.teachSaveCommandHandler(call.Context)
+	},
+})
+
+var RUN_PROGRAM = defineCommand(&CommandDefinition{
+	ID:          20,
+	Name:        "RUN_PROGRAM",
+	Description: "Run a taught program, progress is reported on the program topic.",
+	Args:        CommandSchema{nameSpec},
+	Role:        auth.ROLE_OPERATOR,
+	Control:     true,
+	Exclusive:   true,
+	Handle: func(ch *CommandHandler, call *CommandCall) Response {
+		return ch.runProgramCommandHandler(call.Command, call.Args)
+	},
+})
+
+var PAUSE_PROGRAM = defineCommand(&CommandDefinition{
+	ID:          21,
+	Name:        "PAUSE_PROGRAM",
+	Description: "Pause the running program once the step in progress is done.",
+	Role:        auth.ROLE_OPERATOR,
+	Handle: func(ch *CommandHandler, call *CommandCall) Response {
+		return ch.pauseProgramCommandHandler()
+	},
+})
+
+var RESUME_PROGRAM = defineCommand(&CommandDefinition{
+	ID:          22,
+	Name:        "RESUME_PROGRAM",
+	Description: "Resume the paused program.",
+	Role:        auth.ROLE_OPERATOR,
+	Control:     true,
+	Handle: func(ch *CommandHandler, call *CommandCall) Response {
+		return ch.resumeProgramCommandHandler()
+	},
+})
+
+var ABORT_PROGRAM = defineCommand(&CommandDefinition{
+	ID:          23,
+	Name:        "ABORT_PROGRAM",
+	Description: "Abort the running program once the step in progress is done.",
+	Role:        auth.ROLE_OPERATOR,
+	Handle: func(ch *CommandHandler, call *CommandCall) Response {
+		return ch.abortProgramCommandHandler()
+	},
+})
+
+var GOTO_POSE = defineCommand(&CommandDefinition{
+	ID:          24,
+	Name:        "GOTO_POSE",
+	Description: "Start a move to a stored pose, answering with its joint angles.",
+	Args:        CommandSchema{nameSpec},
+	Role:        auth.ROLE_OPERATOR,
+	Control:     true,
+	Exclusive:   true,
+	Handle: func(ch *CommandHandler, call *CommandCall) Response {
+		return ch.gotoPoseCommandHandler(call.Context, call.Command, call.Args)
+	},
+})
+
+var SAVE_CURRENT_AS_POSE = defineCommand(&CommandDefinition{
+	ID:          25,
+	Name:        "SAVE_CURRENT_AS_POSE",
+	Description: "Store where the arm stands as a pose, replacing one of the same name.",
+	Args:        CommandSchema{nameSpec},
+	Role:        auth.ROLE_OPERATOR,
+	Handle: func(ch *CommandHandler, call *CommandCall) Response {
+		return ch.saveCurrentAsPoseCommandHandler(call.Context, call.Command, call.Args)
+	},
+})
+
+var ADD_POSE = defineCommand(&CommandDefinition{
+	ID:          26,
+	Name:        "ADD_POSE",
+	Description: "Store joint angles as a new pose.",
+	Args:        append(CommandSchema{nameSpec}, jointsAnglesSchema...),
+	Role:        auth.ROLE_OPERATOR,
+	Handle: func(ch *CommandHandler, call *CommandCall) Response {
+		return ch.addPoseCommandHandler(call.Context, call.Command, call.Args)
+	},
+})
+
+var LIST_POSES = defineCommand(&CommandDefinition{
+	ID:          27,
+	Name:        "LIST_POSES",
+	Description: "Names of the stored poses in alphabetical order.",
+	Role:        auth.ROLE_OBSERVER,
+	Handle: func(ch *CommandHandler, call *CommandCall) Response {
+		return ch.listPosesCommandHandler()
+	},
+})
+
+var RENAME_POSE = defineCommand(&CommandDefinition{
+	ID:          28,
+	Name:        "RENAME_POSE",
+	Description: "Rename a stored pose.",
+	Args: CommandSchema{
+		{Name: "from", Type: ARGUMENT_STRING, Pattern: storage.NAME_PATTERN},
+		{Name: "to", Type: ARGUMENT_STRING, Pattern: storage.NAME_PATTERN},
+	},
+	Role: auth.ROLE_OPERATOR,
+	Handle: func(ch *CommandHandler, call *CommandCall) Response {
+		return ch.renamePoseCommandHandler(call.Context, call.Command, call.Args)
+	},
+})
+
+var DELETE_POSE = defineCommand(&CommandDefinition{
+	ID:          29,
+	Name:        "DELETE_POSE",
+	Description: "Delete a stored pose.",
+	Args:        CommandSchema{nameSpec},
+	Role:        auth.ROLE_OPERATOR,
+	Handle: func(ch *CommandHandler, call *CommandCall) Response {
+		return ch.deletePoseCommandHandler(call.Context, call.Command, call.Args)
+	},
+})
+
+var UPLOAD_SCRIPT = defineCommand(&CommandDefinition{
+	ID:          30,
+	Name:        "UPLOAD_SCRIPT",
+	Description: "Store a Starlark script, replacing one of the same name.",
+	Args: CommandSchema{
+		nameSpec,
+		{Name: "source", Type: ARGUMENT_STRING},
+	},
+	Role: auth.ROLE_OPERATOR,
+	Handle: func(ch *CommandHandler, call *CommandCall) Response {
+		return ch.uploadScriptCommandHandler(call.Context, call.Command, call.Args)
+	},
+})
+
+var RUN_SCRIPT = defineCommand(&CommandDefinition{
+	ID:          31,
+	Name:        "RUN_SCRIPT",
+	Description: "Run an uploaded script, its output is reported on the script topic.",
+	Args:        CommandSchema{nameSpec},
+	Role:        auth.ROLE_OPERATOR,
+	Control:     true,
+	Exclusive:   true,
+	Handle: func(ch *CommandHandler, call *CommandCall) Response {
+		return ch.runScriptCommandHandler(call.Command, call.Args)
+	},
+})
+
+var STOP_SCRIPT = defineCommand(&CommandDefinition{
+	ID:          32,
+	Name:        "STOP_SCRIPT",
+	Description: "Stop the running script.",
+	Role:        auth.ROLE_OPERATOR,
+	Handle: func(ch *CommandHandler, call *CommandCall) Response {
+		return ch.stopScriptCommandHandler()
+	},
+})
+
+var UPLOAD_GCODE = defineCommand(&CommandDefinition{
+	ID:          33,
+	Name:        "UPLOAD_GCODE",
+	Description: "Store a G-code program, replacing one of the same name.",
+	Args: CommandSchema{
+		nameSpec,
+		{Name: "source", Type: ARGUMENT_STRING},
+	},
+	Role: auth.ROLE_OPERATOR,
+	Handle: func(ch *CommandHandler, call *CommandCall) Response {
+		return ch.uploadGCodeCommandHandler(call.Context, call.Command, call.Args)
+	},
+})
+
+var RUN_GCODE = defineCommand(&CommandDefinition{
+	ID:          34,
+	Name:        "RUN_GCODE",
+	Description: "Run an uploaded G-code program, progress is reported on the program topic.",
+	Args:        CommandSchema{nameSpec},
+	Role:        auth.ROLE_OPERATOR,
+	Control:     true,
+	Exclusive:   true,
+	Handle: func(ch *CommandHandler, call *CommandCall) Response {
+		return ch.runGCodeCommandHandler(call.Command, call.Args)
+	},
+})
+
+var LIST_COMMANDS = defineCommand(&CommandDefinition{
+	ID:          35,
+	Name:        "LIST_COMMANDS",
+	Description: "Catalogue of the commands with their arguments and requirements.",
+	Role:        auth.ROLE_OBSERVER,
+	Handle: func(ch *CommandHandler, call *CommandCall) Response {
+		return ch.listCommandsCommandHandler()
+	},
+})
+
+var CALIBRATION_CONFIRM = defineCommand(&CommandDefinition{
+	ID:          36,
+	Name:        "CALIBRATION_CONFIRM",
+	Description: "Take the position reached as the reference of the joints and finish calibrating.",
+	Role:        auth.ROLE_ADMIN,
+	Control:     true,
+	Within:      CALIBRATE_ROBOT,
+	TextID:      1,
+})
+
+var CALIBRATION_ABORT = defineCommand(&CommandDefinition{
+	ID:          37,
+	Name:        "CALIBRATION_ABORT",
+	Description: "Stop calibrating, the arm stays uncalibrated.",
+	Role:        auth.ROLE_ADMIN,
+	Control:     true,
+	Within:      CALIBRATE_ROBOT,
+	TextID:      2,
+})
+
+var CALIBRATION_MOVE = defineCommand(&CommandDefinition{
+	ID:          38,
+	Name:        "CALIBRATION_MOVE",
+	Description: "Start a move to the joint angles while calibrating, answering with them.",
+	Args:        jointsAnglesSchema,
+	Role:        auth.ROLE_ADMIN,
+	Control:     true,
+	Within:      CALIBRATE_ROBOT,
+	TextID:      3,
+})
+
+// ArgumentDescription is an ArgumentSpec as clients see it, Min and Max are only set for numbers with a range.
+type ArgumentDescription struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	Unit    string   `json:"unit,omitempty"`
+	Min     *float64 `json:"min,omitempty"`
+	Max     *float64 `json:"max,omitempty"`
+	Values  []string `json:"values,omitempty"`
+	Pattern string   `json:"pattern,omitempty"`
+}
+
+// CommandDescription is one entry of the catalogue answered to LIST_COMMANDS.
+type CommandDescription struct {
+	ID          CommandIdentifier     `json:"id"`
+	Name        string                `json:"name"`
+	Description string                `json:"description"`
+	Args        []ArgumentDescription `json:"args"`
+	Role        string                `json:"role"`
+	Control     bool                  `json:"control"`
+	Exclusive   bool                  `json:"exclusive"`
+	// Command starting the workflow that accepts this one, empty for commands accepted anytime.
+	Within string `json:"within,omitempty"`
+	// Id text clients may send for it within the workflow, 0 when there is none.
+	TextID CommandIdentifier `json:"text_id,omitempty"`
+}
+
+func (d *CommandDefinition) describe() CommandDescription {
+	description := CommandDescription{
+		ID:          d.ID,
+		Name:        d.Name,
+		Description: d.Description,
+		Args:        []ArgumentDescription{},
+		Role:        d.Role.String(),
+		Control:     d.Control,
+		Exclusive:   d.Exclusive,
+		TextID:      d.TextID,
+	}
+	if d.Within != 0 {
+		description.Within = d.Within.String()
+	}
+	for _, spec := range d.Args {
+		argument := ArgumentDescription{Name: spec.Name, Type: spec.Type.String(), Unit: spec.Unit, Values: spec.Values}
+		if spec.Type == ARGUMENT_FLOAT32 && spec.bounded() {
+			argument.Min, argument.Max = &spec.Min, &spec.Max
+		}
+		if spec.Pattern != nil {
+			argument.Pattern = spec.Pattern.String()
+		}
+		description.Args = append(description.Args, argument)
+	}
+	return description
+}
+
+// CommandCatalogue describes every command in the order of their ids.
+func CommandCatalogue() []CommandDescription {
+	catalogue := make([]CommandDescription, 0, len(COMMAND_REGISTRY))
+	for _, definition := range COMMAND_REGISTRY {
+		catalogue = append(catalogue, definition.describe())
+	}
+	sort.Slice(catalogue, func(i, j int) bool { return catalogue[i].ID < catalogue[j].ID })
+	return catalogue
+}
+
+func (ch *CommandHandler) listCommandsCommandHandler() Response {
+	return &CommandCatalogueResponse{Code: RESPONSE_OK, Commands: CommandCatalogue()}
+}
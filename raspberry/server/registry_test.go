@@ -0,0 +1,30 @@
+package server
+
+import "testing"
+
+func TestCommandCatalogue(t *testing.T) {
+	catalogue := CommandCatalogue()
+	names := map[string]bool{}
+	for i, command := range catalogue {
+		if i > 0 && command.ID <= catalogue[i-1].ID {
+			t.Errorf("%s is listed after %s", command.Name, catalogue[i-1].Name)
+		}
+		if names[command.Name] {
+			t.Errorf("%s is defined twice", command.Name)
+		}
+		names[command.Name] = true
+
+		identifier, ok := CommandIdentifierByName(command.Name)
+		if !ok || identifier != command.ID {
+			t.Errorf("%s resolves to %d", command.Name, identifier)
+		}
+		definition := COMMAND_REGISTRY[command.ID]
+		if (definition.Handle == nil) != (definition.Within != 0) {
+			t.Errorf("%s must have a handler unless a workflow accepts it", command.Name)
+		}
+	}
+
+	if within := COMMAND_REGISTRY[CALIBRATION_MOVE].describe().Within; within != "CALIBRATE_ROBOT" {
+		t.Errorf("CALIBRATION_MOVE is accepted within %q", within)
+	}
+}
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// After the last request, responses still missing are waited for this long by default.
+const REPLAY_RESPONSE_TIMEOUT = 5 * time.Second
+
+type Recording struct {
+	Session   RecordEntry
+	Requests  []RecordEntry
+	Responses map[uint64][]json.RawMessage
+}
+
+type RecordingFormatError struct {
+	path string
+	line int
+	err  error
+}
+
+func (err *RecordingFormatError) Error() string {
+	return fmt.Sprintf("Invalid recording %s, line %d: %s", err.path, err.line, err.err)
+}
+
+func LoadRecording(path string) (*Recording, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	recording := &Recording{Responses: map[uint64][]json.RawMessage{}}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		entry := RecordEntry{}
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return nil, &RecordingFormatError{path, line, err}
+		}
+		switch entry.Kind {
+		case RECORD_SESSION:
+			recording.Session = entry
+		case RECORD_REQUEST:
+			recording.Requests = append(recording.Requests, entry)
+		case RECORD_RESPONSE:
+			recording.Responses[entry.Seq] = append(recording.Responses[entry.Seq], entry.Response)
+		default:
+			return nil, &RecordingFormatError{path, line, fmt.Errorf("unknown kind %q", entry.Kind)}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return recording, nil
+}
+
+type ReplayOptions struct {
+	// Speed scales the recorded pauses between requests, 2 replays twice as fast.
+	Speed float64
+	// Strict compares whole responses, otherwise only their status and code.
+	Strict          bool
+	ResponseTimeout time.Duration
+}
+
+type Divergence struct {
+	Seq      uint64
+	Command  string
+	Index    int
+	Reason   string
+	Expected json.RawMessage
+	Actual   json.RawMessage
+}
+
+func (d *Divergence) String() string {
+	return fmt.Sprintf("request %d (%s) response %d: %s\n  expected %s\n  actual   %s", d.Seq, d.Command, d.Index+1, d.Reason, d.Expected, d.Actual)
+}
+
+type ReplayReport struct {
+	Requests    int
+	Responses   int
+	Duration    time.Duration
+	Divergences []Divergence
+}
+
+// Fields that differ between runs by nature, ids are assigned by the replay.
+func comparableResponse(data json.RawMessage, strict bool) map[string]any {
+	response := map[string]any{}
+	json.Unmarshal(data, &response)
+	delete(response, "id")
+	if !strict {
+		return map[string]any{"status": response["status"], "code": response["code"]}
+	}
+	return response
+}
+
+type replayResponses struct {
+	mu       sync.Mutex
+	received map[uint64][]json.RawMessage
+	changed  chan struct{}
+}
+
+func (r *replayResponses) add(seq uint64, data json.RawMessage) {
+	r.mu.Lock()
+	r.received[seq] = append(r.received[seq], data)
+	r.mu.Unlock()
+	select {
+	case r.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (r *replayResponses) complete(expected map[uint64][]json.RawMessage) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for seq, responses := range expected {
+		if len(r.received[seq]) < len(responses) {
+			return false
+		}
+	}
+	return true
+}
+
+func readReplayResponses(transport MessageTransport, responses *replayResponses) {
+	for {
+		data, err := transport.ReadMessage()
+		if err != nil {
+			return
+		}
+		envelope := struct {
+			Type string          `json:"type"`
+			ID   json.RawMessage `json:"id"`
+		}{}
+		err = json.Unmarshal(data, &envelope)
+		if err != nil || envelope.Type == "event" {
+			continue
+		}
+		seq, err := strconv.ParseUint(string(envelope.ID), 10, 64)
+		if err != nil {
+			continue
+		}
+		responses.add(seq, data)
+	}
+}
+
+// Replay sends the recorded requests over transport, which has to speak the
+// JSON subprotocol, keeping their original pacing scaled by options.Speed, and
+// compares the responses with the recorded ones.
+func Replay(ctx context.Context, transport MessageTransport, recording *Recording, options ReplayOptions) (*ReplayReport, error) {
+	if options.Speed <= 0 {
+		options.Speed = 1
+	}
+	if options.ResponseTimeout <= 0 {
+		options.ResponseTimeout = REPLAY_RESPONSE_TIMEOUT
+	}
+
+	responses := &replayResponses{received: map[uint64][]json.RawMessage{}, changed: make(chan struct{}, 1)}
+	go readReplayResponses(transport, responses)
+
+	started := time.Now()
+	first := 0.0
+	if len(recording.Requests) > 0 {
+		first = recording.Requests[0].Time
+	}
+	for _, request := range recording.Requests {
+		due := started.Add(time.Duration((request.Time - first) / options.Speed * float64(time.Second)))
+		select {
+		case <-time.After(time.Until(due)):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		data, err := json.Marshal(map[string]any{"id": request.Seq, "command": request.Command, "args": request.Args})
+		if err != nil {
+			return nil, err
+		}
+		err = transport.WriteMessage(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	timeout := time.NewTimer(options.ResponseTimeout)
+	defer timeout.Stop()
+	for !responses.complete(recording.Responses) {
+		select {
+		case <-responses.changed:
+		case <-timeout.C:
+			return compareReplay(recording, responses, options, time.Since(started)), nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return compareReplay(recording, responses, options, time.Since(started)), nil
+}
+
+func compareReplay(recording *Recording, responses *replayResponses, options ReplayOptions, duration time.Duration) *ReplayReport {
+	responses.mu.Lock()
+	defer responses.mu.Unlock()
+
+	report := &ReplayReport{Requests: len(recording.Requests), Duration: duration}
+	for _, request := range recording.Requests {
+		expected := recording.Responses[request.Seq]
+		actual := responses.received[request.Seq]
+		report.Responses += len(actual)
+		for i := 0; i < max(len(expected), len(actual)); i++ {
+			divergence := Divergence{Seq: request.Seq, Command: request.Name, Index: i}
+			switch {
+			case i >= len(actual):
+				divergence.Reason = "missing response"
+				divergence.Expected = expected[i]
+			case i >= len(expected):
+				divergence.Reason = "unexpected response"
+				divergence.Actual = actual[i]
+			case !reflect.DeepEqual(comparableResponse(expected[i], options.Strict), comparableResponse(actual[i], options.Strict)):
+				divergence.Reason = "different response"
+				divergence.Expected, divergence.Actual = expected[i], actual[i]
+			default:
+				continue
+			}
+			report.Divergences = append(report.Divergences, divergence)
+		}
+	}
+	return report
+}
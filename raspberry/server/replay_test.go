@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xTaube/vr-controlled-robot-arm/auth"
+)
+
+// Records a session doing a move, a speed change the robot refuses and a move out of range.
+func recordTestSession(t *testing.T) *Recording {
+	dir := t.TempDir()
+	t.Run("record", func(t *testing.T) {
+		controlServer := initTestControlServer(t)
+		controlServer.recordingDir = dir
+		operator := connectTestClient(t, controlServer, auth.ROLE_OPERATOR)
+		operator.expect("MOVE_ROBOT", testMove, int(RESPONSE_OK))
+		operator.expect("SET_ROBOT_SPEED", `{"speed":200}`, int(RESPONSE_ROBOT_CANNOT_EXECUTE_COMMAND_ERROR))
+		operator.expect("MOVE_ROBOT", `{"z":10,"y":-90,"x":20,"v":15,"w":-361}`, int(RESPONSE_INVALID_ARGUMENT_ERROR))
+	})
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if err != nil || len(paths) != 1 {
+		t.Fatalf("recordings %v: %v", paths, err)
+	}
+	recording, err := LoadRecording(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recording.Session.Kind != RECORD_SESSION || len(recording.Requests) != 3 || len(recording.Responses) != 3 {
+		t.Fatalf("got %+v", recording)
+	}
+	if request := recording.Requests[1]; request.Name != "SET_ROBOT_SPEED" || len(request.Args) != 1 || request.Args[0] != "200" {
+		t.Errorf("request 2: got %+v", request)
+	}
+	return recording
+}
+
+func replayTestSession(t *testing.T, recording *Recording) *ReplayReport {
+	operator := connectTestClient(t, initTestControlServer(t), auth.ROLE_OPERATOR)
+	report, err := Replay(context.Background(), operator.transport, recording, ReplayOptions{Speed: 10, ResponseTimeout: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestReplayReproducesTheRecordedSession(t *testing.T) {
+	recording := recordTestSession(t)
+	report := replayTestSession(t, recording)
+	if report.Requests != 3 || report.Responses != 3 || len(report.Divergences) != 0 {
+		t.Errorf("got %+v", report)
+	}
+}
+
+func TestReplayReportsDivergences(t *testing.T) {
+	recording := recordTestSession(t)
+	accepted, _ := json.Marshal(map[string]any{"type": "response", "status": "OK", "code": RESPONSE_OK})
+	recording.Responses[2] = []json.RawMessage{accepted}
+	delete(recording.Responses, 3)
+
+	report := replayTestSession(t, recording)
+	if len(report.Divergences) != 2 {
+		t.Fatalf("got %+v", report.Divergences)
+	}
+	for i, want := range []Divergence{{Seq: 2, Command: "SET_ROBOT_SPEED", Reason: "different response"}, {Seq: 3, Command: "MOVE_ROBOT", Reason: "unexpected response"}} {
+		got := report.Divergences[i]
+		if got.Seq != want.Seq || got.Command != want.Command || got.Reason != want.Reason {
+			t.Errorf("divergence %d: got %s", i, got.String())
+		}
+	}
+}
+
+func TestLoadRecordingRefusesUnknownKinds(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	if err := os.WriteFile(path, []byte("{\"kind\":\"session\"}\n\n{\"kind\":\"note\"}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := LoadRecording(path)
+	if formatError, ok := err.(*RecordingFormatError); !ok || formatError.line != 3 {
+		t.Errorf("got %v, want a RecordingFormatError on line 3", err)
+	}
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+)
+
+const MAX_REST_BODY_SIZE = 1 << 16
+
+// RESTRoute exposes one command over plain HTTP, the JSON body holding its
+// params by name. Routes with Actions pick the command from the "action" field instead.
+type RESTRoute struct {
+	Method  string
+	Path    string
+	Summary string
+	Command CommandIdentifier
+	Actions map[string]CommandIdentifier
+}
+
+var REST_ROUTES = []RESTRoute{
+	{Method: http.MethodGet, Path: "/robot/state", Summary: "Current joint angles of the arm.", Command: GET_ROBOT_CURRENT_POSITION},
+	{Method: http.MethodPost, Path: "/robot/move", Summary: "Move the arm to the given joint angles.", Command: MOVE_ROBOT},
+	{Method: http.MethodPost, Path: "/robot/speed", Summary: "Set the acceleration of the arm.", Command: SET_ROBOT_SPEED},
+	{
+		Method:  http.MethodPost,
+		Path:    "/robot/gripper",
+		Summary: "Open or close the gripper.",
+		Actions: map[string]CommandIdentifier{"open": OPEN_GRIPPER, "close": CLOSE_GRIPPER},
+	},
+	{Method: http.MethodPost, Path: "/video/start", Summary: "Start streaming all cameras.", Command: START_VIDEO_STREAM},
+	{Method: http.MethodPost, Path: "/video/stop", Summary: "Stop streaming all cameras.", Command: STOP_VIDEO_STREAM},
+	{Method: http.MethodGet, Path: "/video/streams", Summary: "Addresses of the running streams.", Command: GET_VIDEO_STREAMS},
+	{Method: http.MethodGet, Path: "/poses", Summary: "Names of the stored poses.", Command: LIST_POSES},
+	{Method: http.MethodPost, Path: "/poses/goto", Summary: "Move the arm to a stored pose.", Command: GOTO_POSE},
+	{Method: http.MethodPost, Path: "/programs/run", Summary: "Run a taught program.", Command: RUN_PROGRAM},
+	{
+		Method:  http.MethodPost,
+		Path:    "/programs/current",
+		Summary: "Pause, resume or abort the running program.",
+		Actions: map[string]CommandIdentifier{"pause": PAUSE_PROGRAM, "resume": RESUME_PROGRAM, "abort": ABORT_PROGRAM},
+	},
+	{Method: http.MethodPost, Path: "/gcode", Summary: "Upload a G-code program, replacing one of the same name.", Command: UPLOAD_GCODE},
+	{Method: http.MethodPost, Path: "/gcode/run", Summary: "Run an uploaded G-code program.", Command: RUN_GCODE},
+	{Method: http.MethodPost, Path: "/scripts", Summary: "Upload a script, replacing one of the same name.", Command: UPLOAD_SCRIPT},
+	{Method: http.MethodPost, Path: "/scripts/run", Summary: "Run an uploaded script.", Command: RUN_SCRIPT},
+	{Method: http.MethodPost, Path: "/scripts/stop", Summary: "Stop the running script.", Command: STOP_SCRIPT},
+	{Method: http.MethodGet, Path: "/commands", Summary: "Catalogue of the commands, to generate client bindings from.", Command: LIST_COMMANDS},
+	{Method: http.MethodGet, Path: "/control/lease", Summary: "Id of this request's session and of the one holding control.", Command: GET_CONTROL},
+}
+
+func (r *RESTRoute) actionNames() []string {
+	names := make([]string, 0, len(r.Actions))
+	for name := range r.Actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *RESTRoute) decodeRequest(w http.ResponseWriter, httpRequest *http.Request) (*Request, error) {
+	params := map[string]any{}
+	data, err := io.ReadAll(http.MaxBytesReader(w, httpRequest.Body, MAX_REST_BODY_SIZE))
+	if err != nil {
+		return nil, &MalformedRequestError{err.Error()}
+	}
+	if len(bytes.TrimSpace(data)) > 0 {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		err = decoder.Decode(&params)
+		if err != nil {
+			return nil, &MalformedRequestError{err.Error()}
+		}
+	}
+
+	command := r.Command
+	if r.Actions != nil {
+		action, _ := params["action"].(string)
+		var ok bool
+		command, ok = r.Actions[action]
+		if !ok {
+			return nil, &InvalidArgumentError{r.Path, "action", fmt.Sprintf("%q is not one of %v", action, r.actionNames())}
+		}
+		delete(params, "action")
+	}
+
+	args, err := jsonParamsToArguments(command, params)
+	if err != nil {
+		return nil, err
+	}
+	return &Request{Command: command, Args: args}, nil
+}
+
+// restSession lives for a single HTTP request, so interactive commands such as calibration cannot use it.
+type restSession struct {
+	ctx      context.Context
+	identity SessionIdentity
+}
+
+func (s *restSession) Receive() (*Request, error) {
+	return nil, ErrSessionClosed
+}
+
+func (s *restSession) Send(request *Request, response Response) error {
+	return ErrSessionClosed
+}
+
+func (s *restSession) Context() context.Context {
+	return s.ctx
+}
+
+func (s *restSession) Identity() SessionIdentity {
+	return s.identity
+}
+
+func (s *restSession) Close() error {
+	return nil
+}
+
+func writeRESTResponse(w http.ResponseWriter, response Response) {
+	status := http.StatusOK
+	if errorResponse, ok := response.(*ErrorResponse); ok {
+		status = errorResponse.Code.HTTPStatus()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(response.ParseJSON())
+}
+
+func (s *ControlServer) RESTRequestHandler(route RESTRoute) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := s.authorizeRequest(w, r)
+		if !ok {
+			return
+		}
+
+		request, err := route.decodeRequest(w, r)
+		if err != nil {
+			code := RESPONSE_MALFORMED_REQUEST_ERROR
+			if _, ok := err.(*InvalidArgumentError); ok {
+				code = RESPONSE_INVALID_ARGUMENT_ERROR
+			}
+			writeRESTResponse(w, &ErrorResponse{Code: code, Err: err})
+			return
+		}
+
+		identity := NewSessionIdentity("rest", r.RemoteAddr, "", principal)
+		session := &restSession{ctx: sessionLogContext(r.Context(), identity), identity: identity}
+		s.lease.Join(session.identity.ID, principal.Role.Includes(COMMAND_REGISTRY[ACQUIRE_CONTROL].Role))
+		defer s.lease.Leave(session.identity.ID)
+		commandHandler, subscription := s.initCommandHandler(session, nil)
+		defer subscription.Close()
+
+		writeRESTResponse(w, commandHandler.Handle(request))
+	}
+}
+
+func addRESTHandlers(mux *http.ServeMux, controlServer *ControlServer) {
+	for _, route := range REST_ROUTES {
+		mux.HandleFunc(route.Method+" "+route.Path, controlServer.RESTRequestHandler(route))
+	}
+	mux.HandleFunc("GET /openapi.json", controlServer.OpenAPIRequestHandler)
+}
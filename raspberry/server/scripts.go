@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/xTaube/vr-controlled-robot-arm/pose"
+	"github.com/xTaube/vr-controlled-robot-arm/robot"
+	"github.com/xTaube/vr-controlled-robot-arm/script"
+	"github.com/xTaube/vr-controlled-robot-arm/storage"
+)
+
+type ScriptStateError struct {
+	reason string
+}
+
+func (err *ScriptStateError) Error() string {
+	return err.reason
+}
+
+type ScriptRunningError struct {
+	name string
+}
+
+func (err *ScriptRunningError) Error() string {
+	return fmt.Sprintf("Script %q is running, stop it first.", err.name)
+}
+
+type scriptRun struct {
+	script *script.Script
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// ScriptRunner runs one script at a time for the whole server, like a program
+// a run outlives the session that started it. What the script prints is
+// published on the script topic.
+type ScriptRunner struct {
+	robot  *robot.Robot
+	events *EventBus
+	store  *script.Store
+	poses  *pose.Library
+	limits script.Limits
+
+	mu      sync.Mutex
+	running *scriptRun
+}
+
+func (r *ScriptRunner) Store() *script.Store {
+	return r.store
+}
+
+// Running returns the name of the running script, empty when there is none.
+func (r *ScriptRunner) Running() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running == nil {
+		return ""
+	}
+	return r.running.script.Name
+}
+
+func (r *ScriptRunner) Start(routine *script.Script) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running != nil {
+		return &ScriptRunningError{r.running.script.Name}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &scriptRun{script: routine, cancel: cancel, done: make(chan struct{})}
+	r.running = run
+	go r.run(ctx, run)
+	return nil
+}
+
+// Stop returns without waiting for the run to end, done is closed when it did.
+func (r *ScriptRunner) Stop() (string, <-chan struct{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running == nil {
+		return "", nil, &ScriptStateError{"No script is running."}
+	}
+	r.running.cancel()
+	return r.running.script.Name, r.running.done, nil
+}
+
+func (r *ScriptRunner) run(ctx context.Context, run *scriptRun) {
+	defer close(run.done)
+	defer func() {
+		r.mu.Lock()
+		r.running = nil
+		r.mu.Unlock()
+		run.cancel()
+	}()
+
+	name := run.script.Name
+	logger.Info("Script started", "script", name)
+	r.events.Publish(&Event{Topic: TOPIC_SCRIPT, Name: "started", Details: []string{name}})
+
+	err := script.Run(ctx, r.robot, r.poses, run.script, r.limits, func(line string) {
+		r.events.Publish(&Event{Topic: TOPIC_SCRIPT, Name: "output", Details: []string{name, line}})
+	})
+	if errors.Is(err, context.Canceled) {
+		logger.Info("Script stopped", "script", name)
+		r.events.Publish(&Event{Topic: TOPIC_SCRIPT, Name: "aborted", Details: []string{name, err.Error()}})
+		return
+	}
+	if err != nil {
+		logger.Warn("Script failed", "script", name, "error", err)
+		r.events.PublishFault("script", err)
+		r.events.Publish(&Event{Topic: TOPIC_SCRIPT, Name: "aborted", Details: []string{name, err.Error()}})
+		return
+	}
+
+	logger.Info("Script finished", "script", name)
+	r.events.Publish(&Event{Topic: TOPIC_SCRIPT, Name: "finished", Details: []string{name}})
+}
+
+// Scripts are compiled on upload, so mistakes are reported before anything runs.
+func (ch *CommandHandler) uploadScriptCommandHandler(ctx context.Context, command CommandIdentifier, command_args Arguments) Response {
+	routine := &script.Script{Name: command_args.String("name"), Source: command_args.String("source")}
+	err := script.Compile(routine)
+	if err != nil {
+		return &ErrorResponse{Code: RESPONSE_INVALID_ARGUMENT_ERROR, Err: &InvalidArgumentError{command.String(), "source", err.Error()}}
+	}
+	err = ch.scripts.Store().Save(routine)
+	if err != nil {
+		return &ErrorResponse{Code: RESPONSE_SCRIPT_ERROR, Err: err}
+	}
+
+	logger.InfoContext(ctx, "Script uploaded", "script", routine.Name, "bytes", len(routine.Source))
+	return &ResponseWithStringArguments{Code: RESPONSE_OK, Args: []string{routine.Name}}
+}
+
+func (ch *CommandHandler) runScriptCommandHandler(command CommandIdentifier, command_args Arguments) Response {
+	name := command_args.String("name")
+	routine, err := ch.scripts.Store().Load(name)
+	var notFound *storage.NotFoundError
+	if errors.As(err, &notFound) {
+		return &ErrorResponse{Code: RESPONSE_INVALID_ARGUMENT_ERROR, Err: &InvalidArgumentError{command.String(), "name", fmt.Sprintf("no script named %q", name)}}
+	}
+	if err != nil {
+		return &ErrorResponse{Code: RESPONSE_SCRIPT_ERROR, Err: err}
+	}
+
+	err = ch.scripts.Start(routine)
+	if err != nil {
+		return &ErrorResponse{Code: RESPONSE_SCRIPT_ERROR, Err: err}
+	}
+	return &ResponseWithStringArguments{Code: RESPONSE_OK, Args: []string{name}}
+}
+
+func (ch *CommandHandler) stopScriptCommandHandler() Response {
+	name, _, err := ch.scripts.Stop()
+	if err != nil {
+		return &ErrorResponse{Code: RESPONSE_SCRIPT_ERROR, Err: err}
+	}
+	return &ResponseWithStringArguments{Code: RESPONSE_OK, Args: []string{name}}
+}
+
+func InitScriptRunner(robot *robot.Robot, events *EventBus, store *script.Store, poses *pose.Library, limits script.Limits) *ScriptRunner {
+	return &ScriptRunner{robot: robot, events: events, store: store, poses: poses, limits: limits}
+}
@@ -1,41 +1,88 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"log"
+	"net"
 	"net/http"
+	"time"
 
 	"github.com/quic-go/quic-go/http3"
 	"github.com/quic-go/webtransport-go"
-	"github.com/xTaube/vr-controlled-robot-arm/robot"
-	"github.com/xTaube/vr-controlled-robot-arm/video"
+	"github.com/xTaube/vr-controlled-robot-arm/logging"
+	"github.com/xTaube/vr-controlled-robot-arm/metrics"
 )
 
-func addWebTransportHandlers(s *webtransport.Server) {
-	http.HandleFunc("/control", WebTransportControlRequestHandler(s))
+var logger = logging.Logger("server")
+
+const WEBTRANSPORT_STREAM_TIMEOUT = 10 * time.Second
+
+func addWebTransportHandlers(mux *http.ServeMux, s *webtransport.Server, controlServer *ControlServer) {
+	mux.HandleFunc("/control", controlServer.WebTransportControlRequestHandler(s))
 }
 
-func RunWebTransportServer(port string, certFilePath string, keyFilePath string) error {
+// The servers run until ctx ends, sessions they upgraded are closed by ControlServer.Shutdown.
+func RunWebTransportServer(ctx context.Context, port string, certFilePath string, keyFilePath string, controlServer *ControlServer) error {
+	mux := http.NewServeMux()
 	server := &webtransport.Server{
-		H3: http3.Server{Addr: fmt.Sprintf(":%v", port)},
+		H3: http3.Server{Addr: fmt.Sprintf(":%v", port), Handler: mux},
 	}
-	addWebTransportHandlers(server)
+	addWebTransportHandlers(mux, server, controlServer)
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
 
-	log.Printf("Starting server on address: %s", server.H3.Addr)
+	logger.Info("Starting WebTransport server", "address", server.H3.Addr)
 	err := server.ListenAndServeTLS(certFilePath, keyFilePath)
+	if ctx.Err() != nil {
+		return nil
+	}
 	return err
 }
 
-func addWebSocketHandlers(robot *robot.Robot, video0 *video.VideoStream, video1 *video.VideoStream) {
-	http.HandleFunc("/control", WebSocketControlRequestHandler(robot, video0, video1))
+func addWebSocketHandlers(mux *http.ServeMux, controlServer *ControlServer) {
+	mux.HandleFunc("/control", controlServer.WebSocketControlRequestHandler)
 }
 
-func RunWebSocketServer(port string, robot *robot.Robot, video0 *video.VideoStream, video1 *video.VideoStream) error {
-	addWebSocketHandlers(robot, video0, video1)
-	log.Printf("Starting server on address: :%s", port)
-	err := http.ListenAndServe(
-		fmt.Sprintf(":%s", port),
-		nil,
-	)
+// With a certificate the port serves wss://, plain requests arriving on it are handled according to plainConnections.
+func RunWebSocketServer(ctx context.Context, port string, certificate *tls.Certificate, plainConnections string, controlServer *ControlServer) error {
+	mux := http.NewServeMux()
+	addWebSocketHandlers(mux, controlServer)
+	addRESTHandlers(mux, controlServer)
+	addHealthHandlers(mux, controlServer)
+	mux.Handle("GET /metrics", metrics.Handler())
+	address := fmt.Sprintf(":%s", port)
+	if certificate == nil {
+		server := &http.Server{Addr: address, Handler: mux}
+		go shutdownOnDone(ctx, server)
+		logger.Info("Starting server", "address", address)
+		err := server.ListenAndServe()
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	tlsListener, plainListener := splitTLSListener(listener)
+	go (&http.Server{Handler: plainConnectionsHandler(plainConnections)}).Serve(plainListener)
+
+	server := &http.Server{
+		Handler: mux,
+		// Websockets are not upgraded over HTTP/2, so it is not offered.
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{*certificate}, NextProtos: []string{"http/1.1"}},
+	}
+	go shutdownOnDone(ctx, server)
+	logger.Info("Starting TLS server", "address", address, "fingerprint", CertificateFingerprint(certificate))
+	err = server.ServeTLS(tlsListener, "", "")
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
 	return err
 }
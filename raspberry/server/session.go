@@ -0,0 +1,234 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+
+	"github.com/xTaube/vr-controlled-robot-arm/auth"
+	"github.com/xTaube/vr-controlled-robot-arm/logging"
+)
+
+const (
+	MAX_PIPELINED_REQUESTS = 32
+	MAX_QUEUED_MESSAGES    = 64
+)
+
+var ErrSessionClosed = errors.New("session closed")
+
+var lastSessionID atomic.Uint64
+
+type SessionIdentity struct {
+	ID          string
+	Transport   string
+	RemoteAddr  string
+	Subprotocol string
+	Principal   auth.Principal
+}
+
+func (i SessionIdentity) String() string {
+	return fmt.Sprintf("%s %s from %s", i.Transport, i.ID, i.RemoteAddr)
+}
+
+// Lines logged with a session's context carry its id.
+func sessionLogContext(ctx context.Context, identity SessionIdentity) context.Context {
+	return logging.WithAttrs(ctx, slog.String("session", identity.ID))
+}
+
+func NewSessionIdentity(transport string, remoteAddr string, subprotocol string, principal auth.Principal) SessionIdentity {
+	return SessionIdentity{
+		ID:          fmt.Sprintf("session-%d", lastSessionID.Add(1)),
+		Transport:   transport,
+		RemoteAddr:  remoteAddr,
+		Subprotocol: subprotocol,
+		Principal:   principal,
+	}
+}
+
+// Session is a single client talking to the server, whatever carries its messages.
+// Only the session reads requests, so workflows waiting for input never race the
+// main loop, and only the session writes.
+type Session interface {
+	Receive() (*Request, error)
+	Send(request *Request, response Response) error
+	Context() context.Context
+	Identity() SessionIdentity
+	Close() error
+}
+
+type MessageTransport interface {
+	ReadMessage() ([]byte, error)
+	WriteMessage(data []byte) error
+	Close() error
+}
+
+type incomingRequest struct {
+	request *Request
+	err     error
+}
+
+// TransportSession owns the transport: reads happen in readLoop and
+// every write, responses and pushed events alike, goes through writeLoop.
+type TransportSession struct {
+	transport MessageTransport
+	codec     Codec
+	identity  SessionIdentity
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+	requests  chan incomingRequest
+	outgoing  chan []byte
+	flushed   chan struct{}
+	readErr   error
+	recorder  *Recorder
+}
+
+func (s *TransportSession) readLoop() {
+	defer close(s.requests)
+	for {
+		data, err := s.transport.ReadMessage()
+		if err != nil {
+			s.readErr = err
+			return
+		}
+
+		request, err := s.codec.DecodeRequest(data)
+		select {
+		case s.requests <- incomingRequest{request, err}:
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *TransportSession) writeLoop() {
+	for {
+		select {
+		case data := <-s.outgoing:
+			if data == nil {
+				close(s.flushed)
+				return
+			}
+			err := s.transport.WriteMessage(data)
+			if err != nil {
+				logger.WarnContext(s.ctx, "Cannot send message", "error", err)
+			}
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *TransportSession) Receive() (*Request, error) {
+	for incoming := range s.requests {
+		if incoming.err != nil {
+			s.Send(incoming.request, &ErrorResponse{Code: RESPONSE_MALFORMED_REQUEST_ERROR, Err: incoming.err})
+			continue
+		}
+		s.recordRequest(incoming.request)
+		return incoming.request, nil
+	}
+	if s.readErr == nil {
+		return nil, ErrSessionClosed
+	}
+	return nil, s.readErr
+}
+
+func (s *TransportSession) Send(request *Request, response Response) error {
+	requestID := ""
+	if request != nil {
+		requestID = request.ID
+		s.recordResponse(request, response)
+	}
+
+	select {
+	case s.outgoing <- s.codec.EncodeResponse(response, requestID):
+		return nil
+	case <-s.ctx.Done():
+		return ErrSessionClosed
+	}
+}
+
+// Events are dropped rather than queued behind a slow client, the next one supersedes them anyway.
+func (s *TransportSession) Notify(event *Event) {
+	select {
+	case s.outgoing <- s.codec.EncodeResponse(event, ""):
+	case <-s.ctx.Done():
+	default:
+		logger.WarnContext(s.ctx, "Dropped event, client is not keeping up", "topic", event.Topic.String())
+	}
+}
+
+func (s *TransportSession) Context() context.Context {
+	return s.ctx
+}
+
+func (s *TransportSession) Identity() SessionIdentity {
+	return s.identity
+}
+
+func (s *TransportSession) Close() error {
+	err := error(nil)
+	s.closeOnce.Do(func() {
+		s.cancel()
+		err = s.transport.Close()
+	})
+	return err
+}
+
+func (s *TransportSession) recordRequest(request *Request) {
+	if s.recorder != nil {
+		s.recorder.Request(request)
+	}
+}
+
+func (s *TransportSession) recordResponse(request *Request, response Response) {
+	if s.recorder != nil {
+		s.recorder.Response(request, response)
+	}
+}
+
+func (s *TransportSession) recordDone(request *Request) {
+	if s.recorder != nil {
+		s.recorder.Done(request)
+	}
+}
+
+// Finish sends a last event after everything already queued and closes the session once it is written or ctx ends.
+func (s *TransportSession) Finish(ctx context.Context, event *Event) error {
+	for _, data := range [][]byte{s.codec.EncodeResponse(event, ""), nil} {
+		select {
+		case s.outgoing <- data:
+		case <-ctx.Done():
+			return s.Close()
+		case <-s.ctx.Done():
+			return s.Close()
+		}
+	}
+	select {
+	case <-s.flushed:
+	case <-ctx.Done():
+	case <-s.ctx.Done():
+	}
+	return s.Close()
+}
+
+func InitTransportSession(ctx context.Context, transport MessageTransport, codec Codec, identity SessionIdentity) *TransportSession {
+	ctx, cancel := context.WithCancel(sessionLogContext(ctx, identity))
+	session := &TransportSession{
+		transport: transport,
+		codec:     codec,
+		identity:  identity,
+		ctx:       ctx,
+		cancel:    cancel,
+		requests:  make(chan incomingRequest, MAX_PIPELINED_REQUESTS),
+		outgoing:  make(chan []byte, MAX_QUEUED_MESSAGES),
+		flushed:   make(chan struct{}),
+	}
+	go session.readLoop()
+	go session.writeLoop()
+	return session
+}
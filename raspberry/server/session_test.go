@@ -0,0 +1,259 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xTaube/vr-controlled-robot-arm/auth"
+	"github.com/xTaube/vr-controlled-robot-arm/codec"
+	"github.com/xTaube/vr-controlled-robot-arm/gcode"
+	"github.com/xTaube/vr-controlled-robot-arm/pose"
+	"github.com/xTaube/vr-controlled-robot-arm/program"
+	"github.com/xTaube/vr-controlled-robot-arm/robot"
+	"github.com/xTaube/vr-controlled-robot-arm/script"
+)
+
+var testLimits = robot.Limits{
+	MinSpeed: 1,
+	MaxSpeed: 100,
+	X:        robot.JointLimits{Min: -180, Max: 180},
+	Y:        robot.JointLimits{Min: -180, Max: 180},
+	Z:        robot.JointLimits{Min: -180, Max: 180},
+	V:        robot.JointLimits{Min: -90, Max: 90},
+	W:        robot.JointLimits{Min: -90, Max: 90},
+}
+
+// A control server around a calibrated simulated arm, keeping its files in a temporary directory.
+func initTestControlServer(t *testing.T) *ControlServer {
+	dir := t.TempDir()
+	poses, err := pose.LoadLibrary(filepath.Join(dir, "poses.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	arm := robot.InitSimulatedRobot(testLimits)
+	if err := arm.StartCalibration(); err != nil {
+		t.Fatal(err)
+	}
+	if err := arm.FinishCalibration(); err != nil {
+		t.Fatal(err)
+	}
+	return InitControlServer(
+		arm,
+		nil,
+		time.Minute,
+		nil,
+		"",
+		program.InitStore(dir),
+		gcode.InitStore(dir),
+		nil,
+		script.InitStore(dir),
+		script.Limits{Timeout: time.Second, MaxSteps: 100},
+		poses,
+		DefaultMiddleware(),
+	)
+}
+
+type testClient struct {
+	t         *testing.T
+	transport *MemoryTransport
+	identity  SessionIdentity
+	lastID    int
+}
+
+type testResponse struct {
+	Type    string          `json:"type"`
+	ID      int             `json:"id"`
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// Connects a JSON session over a MemoryTransport, so every request goes through Serve and the whole middleware chain.
+func connectTestClient(t *testing.T, controlServer *ControlServer, role auth.Role, background ...func(commandHandler *CommandHandler)) *testClient {
+	serverEnd, clientEnd := InitMemoryTransportPair()
+	identity := NewSessionIdentity("memory", "test", codec.JSON_SUBPROTOCOL, auth.Principal{Name: role.String(), Role: role})
+	done := make(chan struct{})
+	go func() {
+		controlServer.Serve(InitTransportSession(context.Background(), serverEnd, &JSONCodec{}, identity), background...)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		clientEnd.Close()
+		<-done
+	})
+	return &testClient{t: t, transport: clientEnd, identity: identity}
+}
+
+// Sends a request and returns its id.
+func (c *testClient) send(command string, params string) int {
+	c.t.Helper()
+	c.lastID++
+	request := fmt.Sprintf(`{"id":%d,"command":%q`, c.lastID, command)
+	if params != "" {
+		request += `,"params":` + params
+	}
+	if err := c.transport.WriteMessage([]byte(request + "}")); err != nil {
+		c.t.Fatal(err)
+	}
+	return c.lastID
+}
+
+// Returns the next response to request id, skipping events and other responses in between.
+func (c *testClient) receive(id int) testResponse {
+	c.t.Helper()
+	for {
+		data, err := c.transport.ReadMessage()
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		response := testResponse{}
+		if err := json.Unmarshal(data, &response); err != nil {
+			c.t.Fatalf("%s: %v", data, err)
+		}
+		if response.Type != "event" && response.ID == id {
+			return response
+		}
+	}
+}
+
+func (c *testClient) call(command string, params string) testResponse {
+	c.t.Helper()
+	return c.receive(c.send(command, params))
+}
+
+func (c *testClient) expect(command string, params string, code int) testResponse {
+	c.t.Helper()
+	response := c.call(command, params)
+	if response.Code != code {
+		c.t.Errorf("%s %s: got code %d (%s), want %d", command, params, response.Code, response.Message, code)
+	}
+	return response
+}
+
+const testMove = `{"z":10,"y":-90,"x":20,"v":15,"w":-30}`
+
+func TestSessionGoesThroughTheMiddlewareChain(t *testing.T) {
+	controlServer := initTestControlServer(t)
+	operator := connectTestClient(t, controlServer, auth.ROLE_OPERATOR)
+
+	operator.expect("GET_ROBOT_CURRENT_POSITION", "", int(RESPONSE_OK))
+	// Commands of a workflow are unknown outside of it.
+	operator.expect("CALIBRATION_CONFIRM", "", int(RESPONSE_UNKNOWN_COMMAND_ERROR))
+	operator.expect("CALIBRATE_ROBOT", "", int(RESPONSE_FORBIDDEN_ERROR))
+	operator.expect("MOVE_ROBOT", `{"z":10}`, int(RESPONSE_MALFORMED_REQUEST_ERROR))
+	operator.expect("MOVE_ROBOT", `{"z":10,"y":-90,"x":20,"v":15,"w":-361}`, int(RESPONSE_INVALID_ARGUMENT_ERROR))
+	operator.expect("SET_ROBOT_SPEED", "", int(RESPONSE_INVALID_PARAMETERS_NUMBER_ERROR))
+	// The speed range is the robot's limits, not the schema's.
+	operator.expect("SET_ROBOT_SPEED", `{"speed":20}`, int(RESPONSE_OK))
+	operator.expect("SET_ROBOT_SPEED", `{"speed":200}`, int(RESPONSE_ROBOT_CANNOT_EXECUTE_COMMAND_ERROR))
+	operator.expect("MOVE_ROBOT", testMove, int(RESPONSE_OK))
+	if holder := controlServer.lease.Holder(); holder != operator.identity.ID {
+		t.Errorf("lease held by %q after a move, want %q", holder, operator.identity.ID)
+	}
+
+	other := connectTestClient(t, controlServer, auth.ROLE_OPERATOR)
+	other.expect("MOVE_ROBOT", testMove, int(RESPONSE_CONTROL_LEASE_ERROR))
+	other.expect("GET_ROBOT_CURRENT_POSITION", "", int(RESPONSE_OK))
+
+	observer := connectTestClient(t, controlServer, auth.ROLE_OBSERVER)
+	observer.expect("MOVE_ROBOT", testMove, int(RESPONSE_FORBIDDEN_ERROR))
+	observer.expect("GET_CONTROL", "", int(RESPONSE_OK))
+}
+
+func TestDatagramsWaitOutWorkflows(t *testing.T) {
+	controlServer := initTestControlServer(t)
+	finished := atomic.Bool{}
+	// Registered first, so it runs once the session below has been served.
+	t.Cleanup(func() {
+		if !finished.Load() {
+			t.Error("Serve returned before its background work finished")
+		}
+	})
+
+	handlers := make(chan *CommandHandler, 1)
+	admin := connectTestClient(t, controlServer, auth.ROLE_ADMIN, func(commandHandler *CommandHandler) {
+		handlers <- commandHandler
+		<-commandHandler.session.Context().Done()
+		time.Sleep(10 * time.Millisecond)
+		finished.Store(true)
+	})
+	commandHandler := <-handlers
+
+	calibration := admin.send("CALIBRATE_ROBOT", "")
+	if prompt := admin.receive(calibration); prompt.Type != "prompt" {
+		t.Fatalf("got %+v, want a prompt", prompt)
+	}
+	if commandHandler.OutsideWorkflow(func() {}) {
+		t.Error("a datagram was handled during CALIBRATE_ROBOT")
+	}
+
+	admin.send("CALIBRATION_ABORT", "")
+	admin.receive(calibration)
+	if !commandHandler.OutsideWorkflow(func() {}) {
+		t.Error("datagrams are still dropped after CALIBRATE_ROBOT")
+	}
+}
+
+// The firmware reports the wrist as raw servo values, poses and waypoints must store joint angles.
+func TestPosesAndWaypointsStoreTheWristAsCommanded(t *testing.T) {
+	controlServer := initTestControlServer(t)
+	handlers := make(chan *CommandHandler, 1)
+	operator := connectTestClient(t, controlServer, auth.ROLE_OPERATOR, func(commandHandler *CommandHandler) {
+		handlers <- commandHandler
+	})
+	commandHandler := <-handlers
+
+	operator.expect("MOVE_ROBOT", `{"z":0,"y":-90,"x":0,"v":15,"w":-30}`, int(RESPONSE_OK))
+	operator.expect("SAVE_CURRENT_AS_POSE", `{"name":"wrist"}`, int(RESPONSE_OK))
+	saved, err := controlServer.poses.Get("wrist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.V != 15 || saved.W != -30 {
+		t.Errorf("pose wrist = V%g W%g, want V15 W-30", saved.V, saved.W)
+	}
+
+	operator.expect("TEACH_START", `{"name":"wrist"}`, int(RESPONSE_OK))
+	operator.expect("TEACH_ADD_WAYPOINT", "", int(RESPONSE_OK))
+	waypoint := commandHandler.teaching.Waypoints[0].Joints
+	if waypoint.V != 15 || waypoint.W != -30 {
+		t.Errorf("waypoint wrist = V%g W%g, want V15 W-30", waypoint.V, waypoint.W)
+	}
+}
+
+// The VR app and other text clients calibrate with 1, 2 and 3 as they did before CALIBRATION_CONFIRM,
+// CALIBRATION_ABORT and CALIBRATION_MOVE had ids of their own.
+func TestTextSessionsCalibrateWithTheOlderIds(t *testing.T) {
+	controlServer := initTestControlServer(t)
+	serverEnd, clientEnd := InitMemoryTransportPair()
+	identity := NewSessionIdentity("memory", "test", "", ANONYMOUS_PRINCIPAL)
+	go controlServer.Serve(InitTransportSession(context.Background(), serverEnd, &TextCodec{}, identity))
+	defer clientEnd.Close()
+
+	exchange := func(request string) codec.TextMessage {
+		t.Helper()
+		if err := clientEnd.WriteMessage([]byte(request)); err != nil {
+			t.Fatal(err)
+		}
+		data, err := clientEnd.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return codec.DecodeText(data)
+	}
+
+	if prompt := exchange(fmt.Sprintf("%d#1", CALIBRATE_ROBOT)); prompt.Head != "0" || prompt.ID != "1" {
+		t.Fatalf("got %+v, want the prompt", prompt)
+	}
+	if moved := exchange("3#2$0$-90$0$10$0"); moved.Head != "0" || moved.ID != "2" || len(moved.Args) != 5 {
+		t.Errorf("move while calibrating: got %+v", moved)
+	}
+	if finished := exchange("1#3"); finished.Head != "0" || finished.ID != "1" {
+		t.Errorf("confirm: got %+v, want CALIBRATE_ROBOT answered", finished)
+	}
+}
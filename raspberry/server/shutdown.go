@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+const SHUTDOWN_REASON = "server shutting down"
+
+// In-flight REST requests get this long to finish once the server stops accepting connections.
+const HTTP_SHUTDOWN_TIMEOUT = 2 * time.Second
+
+// Sessions are tracked so shutdown can reach them, upgraded connections are invisible to http.Server.
+func (s *ControlServer) track(session *TransportSession) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing {
+		return false
+	}
+	s.sessions[session] = true
+	s.served.Add(1)
+	return true
+}
+
+func (s *ControlServer) untrack(session *TransportSession) {
+	s.mu.Lock()
+	delete(s.sessions, session)
+	s.mu.Unlock()
+	s.served.Done()
+}
+
+// Shutdown aborts a running program or script, refuses new sessions, tells connected
+// clients why they are dropped and waits for their handlers to return. Closing
+// a session also aborts and reverts a calibration waiting for its input.
+func (s *ControlServer) Shutdown(ctx context.Context) error {
+	name, aborted, err := s.programs.Abort()
+	if err == nil {
+		logger.Info("Aborting program", "program", name)
+		select {
+		case <-aborted:
+		case <-ctx.Done():
+		}
+	}
+	name, stopped, err := s.scripts.Stop()
+	if err == nil {
+		logger.Info("Stopping script", "script", name)
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+		}
+	}
+
+	s.mu.Lock()
+	s.closing = true
+	sessions := make([]*TransportSession, 0, len(s.sessions))
+	for session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	s.mu.Unlock()
+
+	logger.Info("Closing sessions", "sessions", len(sessions))
+	event := &Event{Topic: TOPIC_SERVER, Name: "shutdown", Details: []string{SHUTDOWN_REASON}}
+	for _, session := range sessions {
+		go session.Finish(ctx, event)
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		s.served.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func shutdownOnDone(ctx context.Context, server *http.Server) {
+	<-ctx.Done()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), HTTP_SHUTDOWN_TIMEOUT)
+	defer cancel()
+	server.Shutdown(shutdownCtx)
+}
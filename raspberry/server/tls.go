@@ -0,0 +1,119 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const SELF_SIGNED_CERTIFICATE_VALIDITY = 5 * 365 * 24 * time.Hour
+
+const (
+	PLAIN_CONNECTIONS_REJECT   = "reject"
+	PLAIN_CONNECTIONS_REDIRECT = "redirect"
+)
+
+// Fingerprint of the leaf certificate as colon separated SHA-256 hex, the form browsers and most pinning APIs show.
+func CertificateFingerprint(certificate *tls.Certificate) string {
+	sum := sha256.Sum256(certificate.Certificate[0])
+	encoded := strings.ToUpper(hex.EncodeToString(sum[:]))
+	pairs := make([]string, 0, len(sum))
+	for i := 0; i < len(encoded); i += 2 {
+		pairs = append(pairs, encoded[i:i+2])
+	}
+	return strings.Join(pairs, ":")
+}
+
+func certificateHosts(hosts []string) []string {
+	unique := []string{"localhost"}
+	for _, host := range hosts {
+		if host != "" && !slices.Contains(unique, host) {
+			unique = append(unique, host)
+		}
+	}
+	return unique
+}
+
+func createSelfSignedCertificate(certFilePath string, keyFilePath string, hosts []string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "v-arm control server"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(SELF_SIGNED_CERTIFICATE_VALIDITY),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range certificateHosts(hosts) {
+		ip := net.ParseIP(host)
+		if ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range []string{certFilePath, keyFilePath} {
+		err = os.MkdirAll(filepath.Dir(path), 0700)
+		if err != nil {
+			return err
+		}
+	}
+	err = os.WriteFile(keyFilePath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(certFilePath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// A missing certificate is generated only when selfSigned is set, and kept
+// afterwards so clients pinning its fingerprint survive restarts.
+func LoadOrCreateCertificate(certFilePath string, keyFilePath string, selfSigned bool, hosts []string) (*tls.Certificate, error) {
+	_, err := os.Stat(certFilePath)
+	if errors.Is(err, os.ErrNotExist) && selfSigned {
+		logger.Info("Generating self-signed certificate", "path", certFilePath, "hosts", certificateHosts(hosts))
+		err = createSelfSignedCertificate(certFilePath, keyFilePath, hosts)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot generate self-signed certificate: %w", err)
+		}
+	}
+
+	certificate, err := tls.LoadX509KeyPair(certFilePath, keyFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot load TLS certificate: %w", err)
+	}
+	return &certificate, nil
+}
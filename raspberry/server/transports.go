@@ -0,0 +1,94 @@
+package server
+
+import (
+	"io"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/quic-go/webtransport-go"
+	"github.com/xTaube/vr-controlled-robot-arm/codec"
+)
+
+type webSocketTransport struct {
+	connection  *websocket.Conn
+	messageType int
+}
+
+func (t *webSocketTransport) ReadMessage() ([]byte, error) {
+	_, data, err := t.connection.ReadMessage()
+	return data, err
+}
+
+func (t *webSocketTransport) WriteMessage(data []byte) error {
+	return t.connection.WriteMessage(t.messageType, data)
+}
+
+func (t *webSocketTransport) Close() error {
+	return t.connection.Close()
+}
+
+func initWebSocketTransport(connection *websocket.Conn, codec Codec) *webSocketTransport {
+	transport := &webSocketTransport{connection: connection, messageType: websocket.TextMessage}
+	if codec.IsBinary() {
+		transport.messageType = websocket.BinaryMessage
+	}
+	return transport
+}
+
+type webTransportStream struct {
+	stream webtransport.Stream
+}
+
+func (t *webTransportStream) ReadMessage() ([]byte, error) {
+	return codec.ReadStreamMessage(t.stream)
+}
+
+func (t *webTransportStream) WriteMessage(data []byte) error {
+	return codec.WriteStreamMessage(t.stream, data)
+}
+
+func (t *webTransportStream) Close() error {
+	t.stream.CancelRead(0)
+	return t.stream.Close()
+}
+
+// MemoryTransport is one end of an in-process connection, used to drive a
+// session without a network, e.g. when replaying recordings.
+type MemoryTransport struct {
+	incoming  chan []byte
+	outgoing  chan []byte
+	closed    chan struct{}
+	closeOnce *sync.Once
+}
+
+func (t *MemoryTransport) ReadMessage() ([]byte, error) {
+	select {
+	case data := <-t.incoming:
+		return data, nil
+	case <-t.closed:
+		return nil, io.EOF
+	}
+}
+
+func (t *MemoryTransport) WriteMessage(data []byte) error {
+	select {
+	case t.outgoing <- data:
+		return nil
+	case <-t.closed:
+		return io.ErrClosedPipe
+	}
+}
+
+func (t *MemoryTransport) Close() error {
+	t.closeOnce.Do(func() { close(t.closed) })
+	return nil
+}
+
+func InitMemoryTransportPair() (*MemoryTransport, *MemoryTransport) {
+	toServer := make(chan []byte)
+	toClient := make(chan []byte)
+	closed := make(chan struct{})
+	closeOnce := &sync.Once{}
+	return &MemoryTransport{incoming: toServer, outgoing: toClient, closed: closed, closeOnce: closeOnce},
+		&MemoryTransport{incoming: toClient, outgoing: toServer, closed: closed, closeOnce: closeOnce}
+}
@@ -3,7 +3,6 @@ package server
 import (
 	"fmt"
 
-	"github.com/gorilla/websocket"
 	"github.com/xTaube/vr-controlled-robot-arm/robot"
 )
 
@@ -17,11 +16,11 @@ func (e *WorkflowAbortedError) Error() string {
 }
 
 type Workflow interface {
-	Start()
+	Start(trigger *Request) error
 }
 
 type Step interface {
-	Execute() error
+	Execute(trigger *Request) error
 	Revert() error
 }
 
@@ -30,7 +29,7 @@ type PrepareRobotForCalibrationStep struct {
 	robot       *robot.Robot
 }
 
-func (s *PrepareRobotForCalibrationStep) Execute() error {
+func (s *PrepareRobotForCalibrationStep) Execute(trigger *Request) error {
 	err := s.robot.StartCalibration()
 
 	if err != nil {
@@ -53,7 +52,7 @@ type FinishRobotForCalibrationStep struct {
 	robot       *robot.Robot
 }
 
-func (s *FinishRobotForCalibrationStep) Execute() error {
+func (s *FinishRobotForCalibrationStep) Execute(trigger *Request) error {
 	err := s.robot.FinishCalibration()
 
 	if err != nil {
@@ -66,59 +65,75 @@ func (s *FinishRobotForCalibrationStep) Revert() error {
 	return nil
 }
 
+// Text clients such as the VR app answer a workflow with the ids its commands
+// had before they got their own, TextID maps those back for the text protocol.
+func textWorkflowCommand(session Session, workflow CommandIdentifier, command CommandIdentifier) CommandIdentifier {
+	if _, ok := CodecForSubprotocol(session.Identity().Subprotocol).(*TextCodec); !ok {
+		return command
+	}
+	for id, definition := range COMMAND_REGISTRY {
+		if definition.Within == workflow && definition.TextID != 0 && definition.TextID == command {
+			return id
+		}
+	}
+	return command
+}
+
 type XYZAxisCalibrationStep struct {
 	workflow_id string
-	connection  *websocket.Conn
+	session     Session
 	robot       *robot.Robot
 }
 
-func (s *XYZAxisCalibrationStep) Execute() error {
-	response := ResponseWithStringArguments{
-		Code: RESPONSE_OK,
-		Args: []string{"You're calibrating XYZ axis. Send '1' to confirm, send '2' to abort, send '3${X-deg}${Y-deg}${Z-deg}${V-deg}${W-deg}' to move."}}
-	s.connection.WriteMessage(websocket.TextMessage, response.Parse())
+func (s *XYZAxisCalibrationStep) Execute(trigger *Request) error {
+	s.session.Send(trigger, &PromptResponse{
+		Code:    RESPONSE_OK,
+		Message: "You're calibrating XYZ axis.",
+		Actions: []PromptAction{{CALIBRATION_CONFIRM, "confirm"}, {CALIBRATION_ABORT, "abort"}, {CALIBRATION_MOVE, "move"}},
+	})
 
 	for {
-		_, request, err := s.connection.ReadMessage()
+		request, err := s.session.Receive()
 		if err != nil {
-			response := ErrorResponse{Code: RESPONSE_UNKNOWN_ERROR, Err: err}
-			s.connection.WriteMessage(websocket.TextMessage, response.Parse())
+			return &WorkflowAbortedError{s.workflow_id, err.Error()}
 		}
-		command, args := ParseRequestArguments(string(request))
 
-		switch command {
-		case 1:
+		request.Command = textWorkflowCommand(s.session, CALIBRATE_ROBOT, request.Command)
+		definition, ok := COMMAND_REGISTRY[request.Command]
+		if !ok || definition.Within != CALIBRATE_ROBOT {
+			s.session.Send(request, &ErrorResponse{
+				Code: RESPONSE_UNKNOWN_COMMAND_ERROR,
+				Err:  &CommandNotFound{request.Command},
+			})
+			continue
+		}
+		args, err := definition.Args.Validate(definition.Name, request.Args)
+		if err != nil {
+			s.session.Send(request, validationErrorResponse(err))
+			continue
+		}
+
+		switch request.Command {
+		case CALIBRATION_CONFIRM:
 			if !s.robot.IsIdle() {
-				response := ErrorResponse{
+				s.session.Send(request, &ErrorResponse{
 					Code: RESPONSE_ROBOT_CANNOT_EXECUTE_COMMAND_ERROR,
 					Err:  &robot.RobotError{Code: robot.ROBOT_IS_IN_MOVE_ERROR, Err: nil},
-				}
-				s.connection.WriteMessage(websocket.TextMessage, response.Parse())
+				})
 				continue
 			}
 			return nil
 
-		case 2:
+		case CALIBRATION_ABORT:
 			return &WorkflowAbortedError{s.workflow_id, "user input"}
 
-		case 3:
-			fallback, err := s.robot.Move(
-				robot.JointsAngles{Z: readFloat32(args[0]), Y: readFloat32(args[1]), X: readFloat32(args[2]), V: readFloat32(args[3]), W: readFloat32(args[4])},
-			)
+		case CALIBRATION_MOVE:
+			fallback, err := s.robot.Move(jointsAnglesFromArguments(args))
 			if err != nil {
-				response := ErrorResponse{Code: RESPONSE_ROBOT_CANNOT_EXECUTE_COMMAND_ERROR, Err: err}
-				s.connection.WriteMessage(websocket.TextMessage, response.Parse())
+				s.session.Send(request, &ErrorResponse{Code: RESPONSE_ROBOT_CANNOT_EXECUTE_COMMAND_ERROR, Err: err})
 				continue
 			}
-			response := ResponseWithFloat32Arguments{Code: RESPONSE_OK, Args: []float32{fallback.Z, fallback.Y, fallback.X, fallback.V, fallback.W}}
-			s.connection.WriteMessage(websocket.TextMessage, response.Parse())
-
-		default:
-			response := ErrorResponse{
-				Code: RESPONSE_UNKNOWN_COMMAND_ERROR,
-				Err:  &CommandNotFound{command},
-			}
-			s.connection.WriteMessage(websocket.TextMessage, response.Parse())
+			s.session.Send(request, &JointsAnglesResponse{Code: RESPONSE_OK, Joints: *fallback})
 		}
 	}
 }
@@ -131,10 +146,11 @@ type RobotCalibrationWorkflow struct {
 	workflow_id string
 	steps       []Step
 	stepIdx     int
+	events      *EventBus
 }
 
-func (w *RobotCalibrationWorkflow) executeStep() error {
-	err := w.steps[w.stepIdx].Execute()
+func (w *RobotCalibrationWorkflow) executeStep(trigger *Request) error {
+	err := w.steps[w.stepIdx].Execute(trigger)
 	if err != nil {
 		return err
 	}
@@ -160,29 +176,33 @@ func (w *RobotCalibrationWorkflow) revert() {
 	}
 }
 
-func (w *RobotCalibrationWorkflow) Start() error {
+func (w *RobotCalibrationWorkflow) Start(trigger *Request) error {
 	w.stepIdx = 0
+	w.events.Publish(&Event{Topic: TOPIC_CALIBRATION, Name: "started"})
 	for {
-		err := w.executeStep()
+		err := w.executeStep(trigger)
 		if err != nil {
 			w.revert()
+			w.events.Publish(&Event{Topic: TOPIC_CALIBRATION, Name: "aborted", Details: []string{err.Error()}})
 			return err
 		}
 
 		w.stepIdx++
 		if w.stepIdx >= len(w.steps) {
+			w.events.Publish(&Event{Topic: TOPIC_CALIBRATION, Name: "finished"})
 			return nil
 		}
 	}
 }
 
-func InitRobotCalibrationWorkflow(connection *websocket.Conn, robot *robot.Robot) *RobotCalibrationWorkflow {
+func InitRobotCalibrationWorkflow(session Session, robot *robot.Robot, events *EventBus) *RobotCalibrationWorkflow {
 	workflow_id := "XYZ robot calibration"
 	return &RobotCalibrationWorkflow{
 		workflow_id: workflow_id,
+		events:      events,
 		steps: []Step{
 			&PrepareRobotForCalibrationStep{workflow_id: workflow_id, robot: robot},
-			&XYZAxisCalibrationStep{workflow_id: workflow_id, connection: connection, robot: robot},
+			&XYZAxisCalibrationStep{workflow_id: workflow_id, session: session, robot: robot},
 			&FinishRobotForCalibrationStep{workflow_id: workflow_id, robot: robot},
 		},
 	}
@@ -0,0 +1,67 @@
+// Package storage holds what the stores of named programs, scripts and poses
+// have in common: the names they accept and how their files are written.
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// Names become file names, so they are kept to a safe set of characters.
+var NAME_PATTERN = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Kind is what the name is given to, as in "program" or "pose".
+type InvalidNameError struct {
+	Kind string
+	Name string
+}
+
+func (err *InvalidNameError) Error() string {
+	return fmt.Sprintf("Invalid %s name %q, use up to 64 letters, digits, '-' or '_'.", err.Kind, err.Name)
+}
+
+type NotFoundError struct {
+	Kind string
+	Name string
+}
+
+func (err *NotFoundError) Error() string {
+	return fmt.Sprintf("No %s named %q.", err.Kind, err.Name)
+}
+
+func ValidateName(kind string, name string) error {
+	if !NAME_PATTERN.MatchString(name) {
+		return &InvalidNameError{kind, name}
+	}
+	return nil
+}
+
+// WriteFile replaces the file in one step, readers and a crash midway see
+// either the old or the new content. The file gets perm, missing directories
+// are created searchable by whoever may read it, 0755 for 0644 and 0700 for 0600.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(path), perm|(perm&0444)>>2)
+	if err != nil {
+		return err
+	}
+	// A temporary file of its own, so two writers never share one.
+	temporary, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temporary.Name())
+
+	_, err = temporary.Write(data)
+	if err == nil {
+		err = temporary.Chmod(perm)
+	}
+	if closeErr := temporary.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(temporary.Name(), path)
+}
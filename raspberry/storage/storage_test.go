@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateName(t *testing.T) {
+	for _, name := range []string{"pick", "Pick_and-place_2"} {
+		if err := ValidateName("program", name); err != nil {
+			t.Errorf("%q: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "../etc", "with space", "a.yaml", string(make([]byte, 65))} {
+		err := ValidateName("program", name)
+		var invalid *InvalidNameError
+		if !errors.As(err, &invalid) || invalid.Kind != "program" {
+			t.Errorf("%q: got %v, want an InvalidNameError", name, err)
+		}
+	}
+}
+
+func TestWriteFileReplacesTheFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "programs", "pick.yaml")
+	for _, content := range []string{"first", "second"} {
+		if err := WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Fatalf("got %q, want %q", data, content)
+		}
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("temporary file left behind: %v", entries)
+	}
+}
+
+func TestWriteFileKeepsPrivateFilesPrivate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth", "tokens.yaml")
+	if err := WriteFile(path, []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]os.FileMode{path: 0600, filepath.Dir(path): 0700} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != want {
+			t.Errorf("%s: mode %v, want %v", name, info.Mode().Perm(), want)
+		}
+	}
+}
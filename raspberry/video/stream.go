@@ -1,13 +1,21 @@
 package video
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/xTaube/vr-controlled-robot-arm/logging"
+	"github.com/xTaube/vr-controlled-robot-arm/metrics"
 )
 
+var logger = logging.Logger("video")
+
 type StreamOnError struct{}
 
 func (err *StreamOnError) Error() string {
@@ -32,6 +40,16 @@ const (
 	FPS30 Framerate = 30
 )
 
+const FFMPEG_STOP_TIMEOUT = 2 * time.Second
+
+type StreamState string
+
+const (
+	STREAM_STOPPED StreamState = "stopped"
+	STREAM_RUNNING StreamState = "running"
+	STREAM_EXITED  StreamState = "exited"
+)
+
 type Resoulution struct {
 	Width  int
 	Height int
@@ -43,15 +61,29 @@ type VideoStream struct {
 	framerate          Framerate
 	inputFormat        InputFormat
 	outputServerAddres string
-	ffmpegProcess      *exec.Cmd
+	publicHost         string
+	logDir             string
+
+	mu            sync.Mutex
+	ffmpegProcess *exec.Cmd
+	exited        chan struct{}
+	exitErr       error
+	starts        int
 }
 
 func (vs *VideoStream) Start() (string, error) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
 	if vs.ffmpegProcess != nil {
 		return "", &StreamOnError{}
 	}
 
-	logFile, err := os.Create(fmt.Sprintf("/home/majkel/v-arm/stream-logs/%s.txt", time.Now()))
+	err := os.MkdirAll(vs.logDir, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	logFile, err := os.Create(filepath.Join(vs.logDir, fmt.Sprintf("%s.txt", time.Now())))
 	if err != nil {
 		return "", err
 	}
@@ -87,22 +119,98 @@ func (vs *VideoStream) Start() (string, error) {
 
 	err = vs.ffmpegProcess.Start()
 	if err != nil {
+		vs.ffmpegProcess = nil
+		logFile.Close()
 		return "", err
 	}
 
-	return strings.Replace(vs.outputServerAddres, "localhost", os.Getenv("RASPBERRY_ADDRESS"), 1), nil
+	vs.starts++
+	if vs.starts > 1 {
+		metrics.FFmpegRestarts.WithLabelValues(vs.device).Inc()
+	}
+	metrics.StreamStarted.WithLabelValues(vs.device).SetToCurrentTime()
+	logger.Info("ffmpeg started", "device", vs.device, "pid", vs.ffmpegProcess.Process.Pid, "address", vs.publicAddress())
+
+	// Reaping ffmpeg here is what lets State notice it exited on its own.
+	process, exited := vs.ffmpegProcess, make(chan struct{})
+	vs.exited = exited
+	go func() {
+		err := process.Wait()
+		logFile.Close()
+		vs.mu.Lock()
+		vs.exitErr = err
+		if vs.ffmpegProcess == process {
+			logger.Warn("ffmpeg exited unexpectedly", "device", vs.device, "error", err, "log", logFile.Name())
+			metrics.FFmpegExits.WithLabelValues(vs.device).Inc()
+			metrics.StreamStarted.WithLabelValues(vs.device).Set(0)
+		}
+		vs.mu.Unlock()
+		close(exited)
+	}()
+
+	return vs.publicAddress(), nil
+}
+
+func (vs *VideoStream) publicAddress() string {
+	return strings.Replace(vs.outputServerAddres, "localhost", vs.publicHost, 1)
+}
+
+func (vs *VideoStream) Address() (string, bool) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	if vs.ffmpegProcess == nil {
+		return "", false
+	}
+	return vs.publicAddress(), true
+}
+
+func (vs *VideoStream) State() (StreamState, error) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	if vs.ffmpegProcess == nil {
+		return STREAM_STOPPED, nil
+	}
+	select {
+	case <-vs.exited:
+		return STREAM_EXITED, vs.exitErr
+	default:
+		return STREAM_RUNNING, nil
+	}
+}
+
+func (vs *VideoStream) Device() string {
+	return vs.device
+}
+
+func (vs *VideoStream) ServerAddress() string {
+	return vs.outputServerAddres
 }
 
+// ffmpeg is interrupted first so it can close the RTSP session, and killed if it does not exit in time.
 func (vs *VideoStream) Stop() error {
+	vs.mu.Lock()
 	if vs.ffmpegProcess == nil {
+		vs.mu.Unlock()
 		return &StreamOffError{}
 	}
-
-	err := vs.ffmpegProcess.Process.Kill()
-	if err != nil {
+	process, exited := vs.ffmpegProcess.Process, vs.exited
+	vs.ffmpegProcess = nil
+	metrics.StreamStarted.WithLabelValues(vs.device).Set(0)
+	vs.mu.Unlock()
+
+	err := process.Signal(os.Interrupt)
+	if err == nil {
+		select {
+		case <-exited:
+			return nil
+		case <-time.After(FFMPEG_STOP_TIMEOUT):
+			logger.Warn("ffmpeg did not exit after interrupt, killing it", "device", vs.device, "pid", process.Pid)
+		}
+	}
+	err = process.Kill()
+	if err != nil && !errors.Is(err, os.ErrProcessDone) {
 		return err
 	}
-	vs.ffmpegProcess = nil
 	return nil
 }
 
@@ -112,6 +220,8 @@ func InitVideoStream(
 	framerate Framerate,
 	inputFormat InputFormat,
 	outputServerAddres string,
+	publicHost string,
+	logDir string,
 ) *VideoStream {
 	return &VideoStream{
 		device:             device,
@@ -119,6 +229,7 @@ func InitVideoStream(
 		framerate:          framerate,
 		inputFormat:        inputFormat,
 		outputServerAddres: outputServerAddres,
-		ffmpegProcess:      nil,
+		publicHost:         publicHost,
+		logDir:             logDir,
 	}
 }
@@ -126,7 +126,7 @@ RESULT_CODE Arm::get_current_position(JointsAngles *position) {
     position->y = (float)this->y_stepper->currentPosition()*Y_AX_DEG_PER_STEP;
     position->z = (float)this->z_stepper->currentPosition()*Z_AX_DEG_PER_STEP;
     position->v = (float)this->v_servo->read();
-    position->w = (float)this->v_servo->read();
+    position->w = (float)this->w_servo->read();
 
     return RESULT_OK;
 }